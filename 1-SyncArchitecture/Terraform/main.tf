@@ -61,9 +61,9 @@ resource "docker_image" "app" {
   name = "${module.ecr.repository_url}:latest"
 
   build {
-    # relative path from terraform/ → src/
-    context = "../src"
-    # Dockerfile defaults to "Dockerfile" in that context
+    # repository root, so the shared order module is in the build context
+    context    = "../.."
+    dockerfile = "1-SyncArchitecture/src/Dockerfile"
   }
 }
 
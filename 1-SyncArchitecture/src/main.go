@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"sync"
@@ -9,57 +11,24 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/idempotency"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/payment"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/pricing"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/validation"
 )
 
-// Order represents an e-commerce order
-type Order struct {
-	OrderID    string    `json:"order_id"`
-	CustomerID int       `json:"customer_id"`
-	Status     string    `json:"status"` // pending, processing, completed
-	Items      []Item    `json:"items"`
-	CreatedAt  time.Time `json:"created_at"`
-}
-
-// Item represents an item in an order
-type Item struct {
-	ProductID string  `json:"product_id"`
-	Quantity  int     `json:"quantity"`
-	Price     float64 `json:"price"`
-}
-
-// PaymentProcessor simulates the bottleneck with limited throughput
-type PaymentProcessor struct {
-	// Buffered channel to limit concurrent payment processing
-	// Size of 1 ensures only one payment processes at a time
-	semaphore chan struct{}
-}
-
-// NewPaymentProcessor creates a payment processor with limited throughput
-func NewPaymentProcessor() *PaymentProcessor {
-	return &PaymentProcessor{
-		semaphore: make(chan struct{}, 1),
-	}
-}
-
-// ProcessPayment simulates payment verification with 3 second delay
-func (p *PaymentProcessor) ProcessPayment(orderID string) error {
-	// Acquire semaphore (blocks if another payment is processing)
-	p.semaphore <- struct{}{}
-	defer func() { <-p.semaphore }()
-
-	log.Printf("Processing payment for order %s...", orderID)
-
-	// Simulate the 3-second payment verification delay
-	time.Sleep(3 * time.Second)
-
-	log.Printf("Payment processed for order %s", orderID)
-	return nil
-}
+// actor names this service in order status history
+const actor = "order-processor-sync"
 
 // OrderHandler handles order requests
 type OrderHandler struct {
-	paymentProcessor *PaymentProcessor
-	stats            *Stats
+	paymentGateway payment.Gateway
+	repo           repository.OrderRepository
+	pricer         *pricing.Engine
+	rules          validation.Rules
+	stats          *Stats
 }
 
 // Stats tracks system metrics
@@ -71,10 +40,13 @@ type Stats struct {
 }
 
 // NewOrderHandler creates a new order handler
-func NewOrderHandler(processor *PaymentProcessor) *OrderHandler {
+func NewOrderHandler(gateway payment.Gateway, repo repository.OrderRepository, pricer *pricing.Engine) *OrderHandler {
 	return &OrderHandler{
-		paymentProcessor: processor,
-		stats:            &Stats{},
+		paymentGateway: gateway,
+		repo:           repo,
+		pricer:         pricer,
+		rules:          validation.DefaultRules(),
+		stats:          &Stats{},
 	}
 }
 
@@ -88,13 +60,14 @@ func (h *OrderHandler) HandleSyncOrder(w http.ResponseWriter, r *http.Request) {
 	h.stats.mu.Unlock()
 
 	// Parse order from request body
-	var order Order
-	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+	order, problem := validation.DecodeOrder(r.Body, h.rules)
+	if problem != nil {
 		h.stats.mu.Lock()
 		h.stats.failedOrders++
 		h.stats.mu.Unlock()
 
-		http.Error(w, "Invalid order format", http.StatusBadRequest)
+		problem.Instance = r.URL.Path
+		problem.Write(w)
 		return
 	}
 
@@ -103,25 +76,67 @@ func (h *OrderHandler) HandleSyncOrder(w http.ResponseWriter, r *http.Request) {
 		order.OrderID = uuid.New().String()
 	}
 	order.CreatedAt = time.Now()
-	order.Status = "processing"
+	order.Transition(orders.StatusProcessing, actor, "order received")
+
+	// Compute totals, discounts and tax, redeeming the promo code
+	if _, err := h.pricer.Price(r.Context(), &order); err != nil {
+		h.stats.mu.Lock()
+		h.stats.failedOrders++
+		h.stats.mu.Unlock()
+
+		var pricingErr *pricing.Error
+		if !errors.As(err, &pricingErr) {
+			log.Printf("Failed to price order %s: %v", order.OrderID, err)
+			http.Error(w, "Failed to price order", http.StatusInternalServerError)
+			return
+		}
+		problem := validation.Invalid(validation.FieldError{Field: pricingErr.Field, Message: pricingErr.Message})
+		problem.Instance = r.URL.Path
+		problem.Write(w)
+		return
+	}
+
+	// Record the order so its status can be looked up
+	if err := h.repo.Create(r.Context(), &order); err != nil {
+		h.stats.mu.Lock()
+		h.stats.failedOrders++
+		h.stats.mu.Unlock()
+		h.releasePromo(r.Context(), &order)
+
+		if errors.Is(err, repository.ErrExists) {
+			http.Error(w, "Order already exists", http.StatusConflict)
+			return
+		}
+		log.Printf("Failed to store order %s: %v", order.OrderID, err)
+		http.Error(w, "Failed to store order", http.StatusInternalServerError)
+		return
+	}
 
-	// Process payment (this blocks for 3 seconds)
-	if err := h.paymentProcessor.ProcessPayment(order.OrderID); err != nil {
+	// Process payment (this blocks for the configured payment latency, 3s by default)
+	auth, err := payment.ChargeOrder(r.Context(), h.paymentGateway, &order)
+	if err != nil {
 		h.stats.mu.Lock()
 		h.stats.failedOrders++
 		h.stats.mu.Unlock()
 
-		order.Status = "failed"
-		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Payment failed for order %s: %v", order.OrderID, err)
+
+		h.setStatus(r.Context(), &order, orders.StatusFailed, "payment failed: "+err.Error())
+		h.releasePromo(r.Context(), &order)
+		status, message := http.StatusInternalServerError, "Payment processing failed"
+		if errors.Is(err, payment.ErrDeclined) {
+			status, message = http.StatusPaymentRequired, "Payment declined"
+		}
+		w.WriteHeader(status)
 		json.NewEncoder(w).Encode(map[string]string{
-			"error":    "Payment processing failed",
+			"error":    message,
 			"order_id": order.OrderID,
 		})
 		return
 	}
 
 	// Payment successful
-	order.Status = "completed"
+	h.setPaymentStatus(r.Context(), &order, orders.StatusCompleted, "payment captured", auth.ID)
 
 	h.stats.mu.Lock()
 	h.stats.successfulOrders++
@@ -135,6 +150,7 @@ func (h *OrderHandler) HandleSyncOrder(w http.ResponseWriter, r *http.Request) {
 		"order_id":        order.OrderID,
 		"status":          order.Status,
 		"message":         "Order processed successfully",
+		"pricing":         order.Pricing,
 		"processing_time": time.Since(startTime).Seconds(),
 	}
 	json.NewEncoder(w).Encode(response)
@@ -142,6 +158,37 @@ func (h *OrderHandler) HandleSyncOrder(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Order %s completed in %.2f seconds", order.OrderID, time.Since(startTime).Seconds())
 }
 
+// releasePromo gives back the promo redemption of an order that was not
+// accepted
+func (h *OrderHandler) releasePromo(ctx context.Context, order *orders.Order) {
+	if err := h.pricer.Release(ctx, order); err != nil {
+		log.Printf("Failed to release promo %s of order %s: %v", order.PromoCode, order.OrderID, err)
+	}
+}
+
+// setStatus records an order status change, using the order's version for
+// optimistic locking. The response does not depend on it, so failures are
+// only logged.
+func (h *OrderHandler) setStatus(ctx context.Context, order *orders.Order, status orders.Status, reason string) {
+	h.setPaymentStatus(ctx, order, status, reason, "")
+}
+
+// setPaymentStatus is setStatus for a change that captures the payment
+// authorization authorizationID
+func (h *OrderHandler) setPaymentStatus(ctx context.Context, order *orders.Order, status orders.Status, reason, authorizationID string) {
+	t, err := order.PaymentTransition(status, actor, reason, authorizationID)
+	if err != nil {
+		log.Printf("Failed to set order %s to %s: %v", order.OrderID, status, err)
+		return
+	}
+	version, err := h.repo.UpdateStatus(ctx, order.OrderID, t, order.Version)
+	if err != nil {
+		log.Printf("Failed to set order %s to %s: %v", order.OrderID, status, err)
+		return
+	}
+	order.Version = version
+}
+
 // HandleHealth returns health status
 func (h *OrderHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -168,22 +215,49 @@ func (h *OrderHandler) HandleStats(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	// Create payment processor with bottleneck
-	paymentProcessor := NewPaymentProcessor()
+	// Create payment gateway with bottleneck (one payment at a time by default)
+	defaults := payment.DefaultSimulatedConfig()
+	defaults.MaxConcurrent = 1
+	paymentGateway, err := payment.NewGatewayFromEnv(defaults)
+	if err != nil {
+		log.Fatal("Invalid payment configuration:", err)
+	}
+
+	// Create order store (in-memory unless ORDER_STORE is set)
+	orderRepo, err := repository.NewFromEnv(context.TODO())
+	if err != nil {
+		log.Fatal("Invalid order store configuration:", err)
+	}
+
+	// Promo codes and tax rules (PRICING_CONFIG), with redemption counts
+	// kept next to the orders
+	pricer, err := pricing.NewEngineFromEnv(context.TODO())
+	if err != nil {
+		log.Fatal("Invalid pricing configuration:", err)
+	}
 
 	// Create order handler
-	orderHandler := NewOrderHandler(paymentProcessor)
+	orderHandler := NewOrderHandler(paymentGateway, orderRepo, pricer)
+
+	// Idempotency-Key support for order creation
+	idempotencyStore, err := idempotency.NewStoreFromEnv(context.TODO())
+	if err != nil {
+		log.Fatal("Invalid idempotency store configuration:", err)
+	}
+	idempotent := idempotency.Middleware(idempotencyStore, idempotency.DefaultConfig())
 
 	// Setup routes
 	router := mux.NewRouter()
-	router.HandleFunc("/orders/sync", orderHandler.HandleSyncOrder).Methods("POST")
+	router.HandleFunc("/orders/sync", idempotent(orderHandler.HandleSyncOrder)).Methods("POST")
 	router.HandleFunc("/health", orderHandler.HandleHealth).Methods("GET")
 	router.HandleFunc("/stats", orderHandler.HandleStats).Methods("GET")
 
 	// Start server
 	port := ":8080"
 	log.Printf("Starting synchronous order processor on port %s", port)
-	log.Printf("Payment processing bottleneck: 3 seconds per order")
+	if d, ok := payment.ExpectedChargeTime(paymentGateway); ok {
+		log.Printf("Payment processing bottleneck: about %s per order", d.Round(time.Millisecond))
+	}
 	log.Printf("Expected behavior under load: requests will queue and timeout")
 
 	if err := http.ListenAndServe(port, router); err != nil {
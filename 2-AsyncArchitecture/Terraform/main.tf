@@ -31,6 +31,75 @@ module "sns" {
   topic_name = var.sns_topic_name
 }
 
+# DynamoDB table for order status lookups
+module "orders_table" {
+  source     = "./modules/dynamodb"
+  table_name = var.orders_table_name
+  hash_key   = "order_id"
+
+  # Used by ListByCustomer (GET /orders?customer_id=...)
+  global_secondary_indexes = [{
+    name           = "customer_id-created_at-index"
+    hash_key       = "customer_id"
+    hash_key_type  = "N"
+    range_key      = "created_at"
+    range_key_type = "S"
+  }]
+}
+
+# DynamoDB outbox of SNS events written together with async orders
+module "outbox_table" {
+  source        = "./modules/dynamodb"
+  table_name    = var.outbox_table_name
+  hash_key      = "event_id"
+  ttl_attribute = "expires_at"
+
+  # Sparse index of unsent events, read by the relay
+  global_secondary_indexes = [{
+    name           = "pending-created_at-index"
+    hash_key       = "pending"
+    hash_key_type  = "S"
+    range_key      = "created_at"
+    range_key_type = "S"
+  }]
+}
+
+# DynamoDB table for Idempotency-Key replay on POST /orders/*
+module "idempotency_table" {
+  source        = "./modules/dynamodb"
+  table_name    = var.idempotency_table_name
+  hash_key      = "idempotency_key"
+  ttl_attribute = "expires_at"
+}
+
+# DynamoDB stock levels and per-order reservations for oversell protection
+module "inventory_table" {
+  source     = "./modules/dynamodb"
+  table_name = var.inventory_table_name
+  hash_key   = "product_id"
+}
+
+module "reservations_table" {
+  source     = "./modules/dynamodb"
+  table_name = var.reservations_table_name
+  hash_key   = "order_id"
+}
+
+# DynamoDB counters enforcing promo code redemption limits
+module "promo_redemptions_table" {
+  source     = "./modules/dynamodb"
+  table_name = var.promo_table_name
+  hash_key   = "code"
+}
+
+# DynamoDB ledger of charged orders so redelivered events are not charged twice
+module "processed_orders_table" {
+  source        = "./modules/dynamodb"
+  table_name    = var.ledger_table_name
+  hash_key      = "order_id"
+  ttl_attribute = "expires_at"
+}
+
 # SQS Queue for order processing
 module "sqs" {
   source = "./modules/sqs"
@@ -40,7 +109,7 @@ module "sqs" {
   receive_wait_time  = var.sqs_receive_wait_time
   sns_topic_arn      = module.sns.topic_arn
   enable_dlq         = true
-  max_receive_count  = 3
+  max_receive_count  = var.sqs_max_receive_count
 }
 
 data "aws_iam_role" "lab_role" {
@@ -72,7 +141,16 @@ module "ecs_receiver" {
 
   # Environment variables for receiver
   environment_variables = {
-    SNS_TOPIC_ARN = module.sns.topic_arn
+    SNS_TOPIC_ARN      = module.sns.topic_arn
+    ORDER_STORE        = "dynamodb"
+    ORDERS_TABLE       = module.orders_table.table_name
+    IDEMPOTENCY_TABLE  = module.idempotency_table.table_name
+    OUTBOX_TABLE       = module.outbox_table.table_name
+    PROMO_TABLE        = module.promo_redemptions_table.table_name
+    INVENTORY_TABLE    = module.inventory_table.table_name
+    RESERVATIONS_TABLE = module.reservations_table.table_name
+    INVENTORY_STOCK    = var.inventory_stock
+    EVENT_ENCODING     = var.event_encoding
   }
 }
 
@@ -105,6 +183,23 @@ module "ecs_processor" {
   environment_variables = {
     SQS_QUEUE_URL = module.sqs.queue_url
     WORKER_COUNT  = tostring(var.processor_worker_count)
+    MAX_IN_FLIGHT = tostring(var.processor_max_in_flight)
+    ORDER_STORE   = "dynamodb"
+    ORDERS_TABLE  = module.orders_table.table_name
+    LEDGER_TABLE  = module.processed_orders_table.table_name
+
+    INVENTORY_TABLE    = module.inventory_table.table_name
+    RESERVATIONS_TABLE = module.reservations_table.table_name
+    PROMO_TABLE        = module.promo_redemptions_table.table_name
+
+    SQS_VISIBILITY_TIMEOUT        = tostring(var.sqs_visibility_timeout)
+    VISIBILITY_HEARTBEAT_INTERVAL = var.visibility_heartbeat_interval
+    VISIBILITY_MAX_LIFETIME       = var.visibility_max_lifetime
+
+    # Give up on a message when the queue moves it to the DLQ; messages
+    # that can never succeed are sent there at once
+    RETRY_MAX_ATTEMPTS = tostring(var.sqs_max_receive_count)
+    DLQ_URL            = module.sqs.dlq_url
   }
 }
 
@@ -113,7 +208,8 @@ resource "docker_image" "receiver" {
   name = "${module.ecr_receiver.repository_url}:latest"
 
   build {
-    context = "../src/order-receiver"
+    context    = "../.."
+    dockerfile = "2-AsyncArchitecture/src/order-receiver/Dockerfile"
   }
 }
 
@@ -126,7 +222,8 @@ resource "docker_image" "processor" {
   name = "${module.ecr_processor.repository_url}:latest"
 
   build {
-    context = "../src/order-processor"
+    context    = "../.."
+    dockerfile = "2-AsyncArchitecture/src/order-processor/Dockerfile"
   }
 }
 
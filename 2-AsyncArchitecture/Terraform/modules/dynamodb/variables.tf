@@ -0,0 +1,27 @@
+variable "table_name" {
+  description = "Name of the DynamoDB table"
+  type        = string
+}
+
+variable "hash_key" {
+  description = "Partition key attribute (string)"
+  type        = string
+}
+
+variable "global_secondary_indexes" {
+  description = "Global secondary indexes (attribute types: S or N)"
+  type = list(object({
+    name           = string
+    hash_key       = string
+    hash_key_type  = string
+    range_key      = string
+    range_key_type = string
+  }))
+  default = []
+}
+
+variable "ttl_attribute" {
+  description = "Attribute holding the expiry time (epoch seconds), null disables TTL"
+  type        = string
+  default     = null
+}
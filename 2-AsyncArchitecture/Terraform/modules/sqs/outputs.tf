@@ -16,4 +16,8 @@ output "queue_name" {
 output "dlq_arn" {
   description = "ARN of the dead letter queue (if enabled)"
   value       = var.enable_dlq ? aws_sqs_queue.dlq[0].arn : null
-}
\ No newline at end of file
+}
+output "dlq_url" {
+  description = "URL of the dead letter queue (if enabled)"
+  value       = var.enable_dlq ? aws_sqs_queue.dlq[0].id : null
+}
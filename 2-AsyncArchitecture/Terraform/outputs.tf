@@ -57,6 +57,11 @@ output "sqs_queue_name" {
   value       = module.sqs.queue_name
 }
 
+output "sqs_dlq_url" {
+  description = "URL of the dead letter queue, for the dlq command"
+  value       = module.sqs.dlq_url
+}
+
 # Phase 5 scaling instructions
 output "scaling_instructions" {
   description = "How to scale processor workers"
@@ -66,6 +66,11 @@ variable "processor_worker_count" {
   default = 1  # Start with 1 worker goroutine per task
 }
 
+variable "processor_max_in_flight" {
+  type    = number
+  default = 10  # Messages processed at once per task, across its workers
+}
+
 # ===== AUTO SCALING SETTINGS =====
 variable "target_cpu" {
   type    = number
@@ -83,6 +88,62 @@ variable "sns_topic_name" {
   default = "order-processing-events"
 }
 
+# DynamoDB table holding order status
+variable "orders_table_name" {
+  type    = string
+  default = "orders"
+}
+
+# DynamoDB table holding SNS events waiting to be published
+variable "outbox_table_name" {
+  type    = string
+  default = "order-outbox"
+}
+
+# DynamoDB table holding Idempotency-Key responses
+variable "idempotency_table_name" {
+  type    = string
+  default = "idempotency-keys"
+}
+
+# DynamoDB table of product stock levels
+variable "inventory_table_name" {
+  type    = string
+  default = "inventory"
+}
+
+# DynamoDB table of stock held by accepted orders
+variable "reservations_table_name" {
+  type    = string
+  default = "inventory-reservations"
+}
+
+# Initial stock of flash sale products, e.g. "PROD-101=50,PROD-102=10";
+# products without a level are not limited
+variable "inventory_stock" {
+  type    = string
+  default = ""
+}
+
+# Encoding of events published on the order topic: "envelope", or
+# CloudEvents "structured" or "binary"
+variable "event_encoding" {
+  type    = string
+  default = "envelope"
+}
+
+# DynamoDB table counting promo code redemptions
+variable "promo_table_name" {
+  type    = string
+  default = "promo-redemptions"
+}
+
+# DynamoDB table recording which orders have already been charged
+variable "ledger_table_name" {
+  type    = string
+  default = "processed-orders"
+}
+
 # ===== SQS CONFIGURATION =====
 variable "sqs_queue_name" {
   type    = string
@@ -97,4 +158,20 @@ variable "sqs_visibility_timeout" {
 variable "sqs_receive_wait_time" {
   type    = number
   default = 20  # seconds (long polling)
-}
\ No newline at end of file
+}
+
+variable "sqs_max_receive_count" {
+  type    = number
+  default = 3  # receives before a message moves to the DLQ
+}
+# How often the processor extends the visibility of a message in progress
+variable "visibility_heartbeat_interval" {
+  type    = string
+  default = "10s"
+}
+
+# How long heartbeats may keep one message hidden before it is abandoned
+variable "visibility_max_lifetime" {
+  type    = string
+  default = "10m"
+}
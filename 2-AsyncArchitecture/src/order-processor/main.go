@@ -2,243 +2,136 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"log"
 	"os"
-	"strconv"
-	"sync"
-	"sync/atomic"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
-	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/inventory"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/ledger"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/messaging"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/payment"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/pricing"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/processor"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/retry"
 )
 
-// Order represents an e-commerce order
-type Order struct {
-	OrderID    string    `json:"order_id"`
-	CustomerID int       `json:"customer_id"`
-	Status     string    `json:"status"`
-	Items      []Item    `json:"items"`
-	CreatedAt  time.Time `json:"created_at"`
-}
-
-// Item represents an item in an order
-type Item struct {
-	ProductID string  `json:"product_id"`
-	Quantity  int     `json:"quantity"`
-	Price     float64 `json:"price"`
-}
-
-// ProcessorStats tracks processing metrics
-type ProcessorStats struct {
-	mu                sync.Mutex
-	messagesReceived  int64
-	messagesProcessed int64
-	messagesFailed    int64
-	startTime         time.Time
-}
-
-// OrderProcessor handles SQS messages and payment processing
-type OrderProcessor struct {
-	sqsClient     *sqs.Client
-	queueURL      string
-	workerCount   int
-	stats         *ProcessorStats
-	activeWorkers int32
-}
-
-func NewOrderProcessor(sqsClient *sqs.Client, queueURL string, workerCount int) *OrderProcessor {
-	return &OrderProcessor{
-		sqsClient:   sqsClient,
-		queueURL:    queueURL,
-		workerCount: workerCount,
-		stats: &ProcessorStats{
-			startTime: time.Now(),
-		},
+func main() {
+	// Get configuration from environment
+	queueURL := os.Getenv("SQS_QUEUE_URL")
+	if queueURL == "" {
+		log.Fatal("SQS_QUEUE_URL environment variable not set")
 	}
-}
-
-// ProcessPayment simulates payment processing with 3-second delay
-func (p *OrderProcessor) ProcessPayment(orderID string) error {
-	log.Printf("Worker processing payment for order %s...", orderID)
-	time.Sleep(3 * time.Second)
-	log.Printf("Payment processed for order %s", orderID)
-	return nil
-}
-
-// ProcessMessage handles a single SQS message
-func (p *OrderProcessor) ProcessMessage(ctx context.Context, message types.Message) {
-	atomic.AddInt64(&p.stats.messagesReceived, 1)
 
-	// Extract order from SNS message wrapper
-	var snsMessage struct {
-		Message string `json:"Message"`
+	// Workers, in-flight limit and shutdown drain timeout
+	cfg, err := processor.ConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid processor configuration:", err)
 	}
 
-	if err := json.Unmarshal([]byte(*message.Body), &snsMessage); err != nil {
-		log.Printf("Failed to parse SNS message: %v", err)
-		atomic.AddInt64(&p.stats.messagesFailed, 1)
-		return
+	// Initialize AWS SDK
+	awsCfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatal("Unable to load AWS SDK config:", err)
 	}
 
-	// Parse the actual order
-	var order Order
-	if err := json.Unmarshal([]byte(snsMessage.Message), &order); err != nil {
-		log.Printf("Failed to parse order: %v", err)
-		atomic.AddInt64(&p.stats.messagesFailed, 1)
-		return
+	// 20s long polling; received messages are hidden for the visibility
+	// timeout, extended by heartbeats while they are processed
+	cfg.Queue, err = messaging.QueueConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid queue configuration:", err)
 	}
+	sqsClient := sqs.NewFromConfig(awsCfg)
+	consumer := messaging.NewSQSConsumer(sqsClient, queueURL, cfg.Queue)
+	cfg.Consumer = consumer
 
-	// Process payment
-	startTime := time.Now()
-	if err := p.ProcessPayment(order.OrderID); err != nil {
-		log.Printf("Payment processing failed for order %s: %v", order.OrderID, err)
-		atomic.AddInt64(&p.stats.messagesFailed, 1)
-		return
+	// Deletes are sent in batches of up to 10
+	ackConfig, err := messaging.AckConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid acknowledgement configuration:", err)
 	}
+	cfg.Acker = messaging.NewAcker(consumer, ackConfig)
 
-	// Delete message from queue after successful processing
-	deleteInput := &sqs.DeleteMessageInput{
-		QueueUrl:      aws.String(p.queueURL),
-		ReceiptHandle: message.ReceiptHandle,
+	// Messages that can never succeed go straight to the dead-letter queue
+	if dlqURL := os.Getenv("DLQ_URL"); dlqURL != "" {
+		cfg.DeadLetters = messaging.NewSQSPublisher(sqsClient, dlqURL)
 	}
 
-	if _, err := p.sqsClient.DeleteMessage(ctx, deleteInput); err != nil {
-		log.Printf("Failed to delete message: %v", err)
-		// Message will become visible again after visibility timeout
+	// Messages may arrive in an SNS envelope, with raw delivery or straight
+	// from a producer
+	cfg.Unwrapper, err = messaging.UnwrapperFromEnv()
+	if err != nil {
+		log.Fatal("Invalid SNS delivery configuration:", err)
 	}
 
-	atomic.AddInt64(&p.stats.messagesProcessed, 1)
-	log.Printf("Order %s processed in %.2f seconds", order.OrderID, time.Since(startTime).Seconds())
-}
-
-// Worker polls SQS and processes messages
-func (p *OrderProcessor) Worker(ctx context.Context, workerID int) {
-	atomic.AddInt32(&p.activeWorkers, 1)
-	defer atomic.AddInt32(&p.activeWorkers, -1)
-
-	log.Printf("Worker %d started", workerID)
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Printf("Worker %d stopping", workerID)
-			return
-		default:
-			// Poll SQS for messages
-			receiveInput := &sqs.ReceiveMessageInput{
-				QueueUrl:            aws.String(p.queueURL),
-				MaxNumberOfMessages: 10,
-				WaitTimeSeconds:     20, // Long polling
-				VisibilityTimeout:   30,
-			}
-
-			result, err := p.sqsClient.ReceiveMessage(ctx, receiveInput)
-			if err != nil {
-				log.Printf("Worker %d: Failed to receive messages: %v", workerID, err)
-				time.Sleep(5 * time.Second)
-				continue
-			}
-
-			// Process each message
-			for _, message := range result.Messages {
-				p.ProcessMessage(ctx, message)
-			}
-		}
+	// Failed messages are retried with backoff up to RETRY_MAX_ATTEMPTS
+	cfg.Retry, err = retry.PolicyFromEnv()
+	if err != nil {
+		log.Fatal("Invalid retry configuration:", err)
 	}
-}
-
-// Start begins processing with configured number of workers
-func (p *OrderProcessor) Start(ctx context.Context) {
-	log.Printf("Starting order processor with %d workers", p.workerCount)
-
-	var wg sync.WaitGroup
 
-	// Start worker goroutines
-	for i := 0; i < p.workerCount; i++ {
-		wg.Add(1)
-		go func(workerID int) {
-			defer wg.Done()
-			p.Worker(ctx, workerID)
-		}(i)
+	// MAX_IN_FLIGHT already bounds concurrent charges
+	cfg.Payment, err = payment.NewGatewayFromEnv(payment.DefaultSimulatedConfig())
+	if err != nil {
+		log.Fatal("Invalid payment configuration:", err)
 	}
 
-	// Start stats reporter
-	go p.ReportStats(ctx)
-
-	// Wait for all workers to finish
-	wg.Wait()
-	log.Println("All workers stopped")
-}
-
-// ReportStats periodically logs processing statistics
-func (p *OrderProcessor) ReportStats(ctx context.Context) {
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			p.stats.mu.Lock()
-			received := atomic.LoadInt64(&p.stats.messagesReceived)
-			processed := atomic.LoadInt64(&p.stats.messagesProcessed)
-			failed := atomic.LoadInt64(&p.stats.messagesFailed)
-			uptime := time.Since(p.stats.startTime)
-			activeWorkers := atomic.LoadInt32(&p.activeWorkers)
-			p.stats.mu.Unlock()
-
-			rate := float64(processed) / uptime.Seconds()
-
-			log.Printf("=== PROCESSOR STATS ===")
-			log.Printf("Uptime: %.0f seconds", uptime.Seconds())
-			log.Printf("Active Workers: %d/%d", activeWorkers, p.workerCount)
-			log.Printf("Messages Received: %d", received)
-			log.Printf("Messages Processed: %d", processed)
-			log.Printf("Messages Failed: %d", failed)
-			log.Printf("Processing Rate: %.2f orders/second", rate)
-			log.Printf("====================")
-		}
+	// Create order store shared with the receiver
+	cfg.Orders, err = repository.NewFromEnv(context.TODO())
+	if err != nil {
+		log.Fatal("Invalid order store configuration:", err)
 	}
-}
 
-func main() {
-	// Get configuration from environment
-	queueURL := os.Getenv("SQS_QUEUE_URL")
-	if queueURL == "" {
-		log.Fatal("SQS_QUEUE_URL environment variable not set")
+	// Create processed-order ledger shared by all processor tasks
+	cfg.Ledger, err = ledger.NewStoreFromEnv(context.TODO())
+	if err != nil {
+		log.Fatal("Invalid ledger configuration:", err)
 	}
-
-	// Get worker count from environment (default to 1)
-	workerCount := 1
-	if wc := os.Getenv("WORKER_COUNT"); wc != "" {
-		if count, err := strconv.Atoi(wc); err == nil && count > 0 {
-			workerCount = count
-		}
+	cfg.LedgerConfig, err = ledger.ConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid ledger configuration:", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
 	}
 
-	// Initialize AWS SDK
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	// Stock reservations made by the receiver
+	cfg.Inventory, err = inventory.NewStoreFromEnv(context.TODO())
 	if err != nil {
-		log.Fatal("Unable to load AWS SDK config:", err)
+		log.Fatal("Invalid inventory configuration:", err)
+	}
+	inventoryConfig, err := inventory.ConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid inventory configuration:", err)
 	}
+	cfg.ReservationTTL = inventoryConfig.ReservationTTL
 
-	sqsClient := sqs.NewFromConfig(cfg)
+	// Promo redemptions counted by the receiver, given back for orders that
+	// fail for good
+	cfg.Pricer, err = pricing.NewEngineFromEnv(context.TODO())
+	if err != nil {
+		log.Fatal("Invalid pricing configuration:", err)
+	}
 
 	// Create processor
-	processor := NewOrderProcessor(sqsClient, queueURL, workerCount)
+	orderProcessor := processor.New(cfg)
 
-	// Start processing
-	ctx := context.Background()
+	// Start processing until ECS stops the task
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 	log.Printf("Starting order processor service")
 	log.Printf("SQS Queue: %s", queueURL)
-	log.Printf("Worker Count: %d", workerCount)
-	log.Printf("Each worker can process 1 order every 3 seconds")
-	log.Printf("Maximum throughput: %.2f orders/second", float64(workerCount)/3.0)
+	log.Printf("Worker Count: %d", cfg.Workers)
+	log.Printf("Max In Flight: %d", cfg.MaxInFlight)
+	if d, ok := payment.ExpectedChargeTime(cfg.Payment); ok && d > 0 {
+		log.Printf("Each payment takes about %s", d.Round(time.Millisecond))
+		log.Printf("Maximum throughput: %.2f orders/second", float64(cfg.MaxInFlight)/d.Seconds())
+	}
 
-	processor.Start(ctx)
+	orderProcessor.Start(ctx)
+	log.Printf("Order processor stopped")
 }
@@ -3,63 +3,60 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/sns"
-	"github.com/aws/aws-sdk-go-v2/service/sns/types"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/event"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/idempotency"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/inventory"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/ledger"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/messaging"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/money"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/outbox"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/payment"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/pricing"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/processor"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/retry"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/validation"
 )
 
-// Order represents an e-commerce order
-type Order struct {
-	OrderID    string    `json:"order_id"`
-	CustomerID int       `json:"customer_id"`
-	Status     string    `json:"status"` // pending, processing, completed
-	Items      []Item    `json:"items"`
-	CreatedAt  time.Time `json:"created_at"`
-}
-
-// Item represents an item in an order
-type Item struct {
-	ProductID string  `json:"product_id"`
-	Quantity  int     `json:"quantity"`
-	Price     float64 `json:"price"`
-}
+// actor names this service in order status history
+const actor = "order-receiver"
 
-// PaymentProcessor simulates the bottleneck with limited throughput
-type PaymentProcessor struct {
-	semaphore chan struct{}
-}
-
-func NewPaymentProcessor() *PaymentProcessor {
-	return &PaymentProcessor{
-		semaphore: make(chan struct{}, 1),
-	}
-}
-
-func (p *PaymentProcessor) ProcessPayment(orderID string) error {
-	p.semaphore <- struct{}{}
-	defer func() { <-p.semaphore }()
-
-	log.Printf("Processing payment for order %s...", orderID)
-	time.Sleep(3 * time.Second)
-	log.Printf("Payment processed for order %s", orderID)
-	return nil
-}
+// A refund the gateway confirmed is recorded up to recordRefundAttempts
+// times, waiting recordRefundBackoff longer after each failure
+const (
+	recordRefundAttempts = 3
+	recordRefundBackoff  = 200 * time.Millisecond
+)
 
 // OrderHandler handles order requests
 type OrderHandler struct {
-	paymentProcessor *PaymentProcessor
-	snsClient        *sns.Client
-	topicArn         string
-	stats            *Stats
+	paymentGateway payment.Gateway
+	publisher      messaging.EventPublisher
+	repo           repository.OrderRepository
+	outbox         outbox.Store
+	relay          *outbox.Relay
+	// codec encodes events for the order topic
+	codec     event.Codec
+	pricer    *pricing.Engine
+	inventory inventory.Store
+	// reservationTTL bounds how long an accepted order holds stock
+	reservationTTL time.Duration
+	rules          validation.Rules
+	stats          *Stats
 }
 
 // Stats tracks system metrics
@@ -70,15 +67,26 @@ type Stats struct {
 	asyncOrders      int
 	successfulOrders int
 	failedOrders     int
+	soldOutOrders    int
+	cancelledOrders  int
+	refundedOrders   int
 }
 
-func NewOrderHandler(processor *PaymentProcessor, snsClient *sns.Client, topicArn string) *OrderHandler {
-	return &OrderHandler{
-		paymentProcessor: processor,
-		snsClient:        snsClient,
-		topicArn:         topicArn,
-		stats:            &Stats{},
+func NewOrderHandler(gateway payment.Gateway, publisher messaging.EventPublisher, repo repository.OrderRepository, outboxStore outbox.Store, relayConfig outbox.Config, pricer *pricing.Engine, inventoryStore inventory.Store, reservationTTL time.Duration, codec event.Codec) *OrderHandler {
+	h := &OrderHandler{
+		paymentGateway: gateway,
+		publisher:      publisher,
+		repo:           repo,
+		outbox:         outboxStore,
+		pricer:         pricer,
+		inventory:      inventoryStore,
+		reservationTTL: reservationTTL,
+		codec:          codec,
+		rules:          validation.DefaultRules(),
+		stats:          &Stats{},
 	}
+	h.relay = outbox.NewRelay(outboxStore, h.publishEvent, relayConfig)
+	return h
 }
 
 // HandleSyncOrder processes orders synchronously (existing endpoint)
@@ -90,13 +98,14 @@ func (h *OrderHandler) HandleSyncOrder(w http.ResponseWriter, r *http.Request) {
 	h.stats.syncOrders++
 	h.stats.mu.Unlock()
 
-	var order Order
-	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+	order, problem := validation.DecodeOrder(r.Body, h.rules)
+	if problem != nil {
 		h.stats.mu.Lock()
 		h.stats.failedOrders++
 		h.stats.mu.Unlock()
 
-		http.Error(w, "Invalid order format", http.StatusBadRequest)
+		problem.Instance = r.URL.Path
+		problem.Write(w)
 		return
 	}
 
@@ -104,24 +113,66 @@ func (h *OrderHandler) HandleSyncOrder(w http.ResponseWriter, r *http.Request) {
 		order.OrderID = uuid.New().String()
 	}
 	order.CreatedAt = time.Now()
-	order.Status = "processing"
+	order.Transition(orders.StatusProcessing, actor, "order received")
 
-	// Process payment synchronously (blocks for 3 seconds)
-	if err := h.paymentProcessor.ProcessPayment(order.OrderID); err != nil {
+	// Compute totals, discounts and tax, redeeming the promo code
+	if _, err := h.pricer.Price(r.Context(), &order); err != nil {
 		h.stats.mu.Lock()
 		h.stats.failedOrders++
 		h.stats.mu.Unlock()
 
-		order.Status = "failed"
-		w.WriteHeader(http.StatusInternalServerError)
+		var pricingErr *pricing.Error
+		if !errors.As(err, &pricingErr) {
+			log.Printf("Failed to price order %s: %v", order.OrderID, err)
+			http.Error(w, "Failed to price order", http.StatusInternalServerError)
+			return
+		}
+		problem := validation.Invalid(validation.FieldError{Field: pricingErr.Field, Message: pricingErr.Message})
+		problem.Instance = r.URL.Path
+		problem.Write(w)
+		return
+	}
+
+	// Record the order so its status can be looked up
+	if err := h.repo.Create(r.Context(), &order); err != nil {
+		h.stats.mu.Lock()
+		h.stats.failedOrders++
+		h.stats.mu.Unlock()
+		h.releasePromo(r.Context(), &order)
+
+		if errors.Is(err, repository.ErrExists) {
+			http.Error(w, "Order already exists", http.StatusConflict)
+			return
+		}
+		log.Printf("Failed to store order %s: %v", order.OrderID, err)
+		http.Error(w, "Failed to store order", http.StatusInternalServerError)
+		return
+	}
+
+	// Process payment synchronously (blocks for the configured payment latency, 3s by default)
+	auth, err := payment.ChargeOrder(r.Context(), h.paymentGateway, &order)
+	if err != nil {
+		h.stats.mu.Lock()
+		h.stats.failedOrders++
+		h.stats.mu.Unlock()
+
+		log.Printf("Payment failed for order %s: %v", order.OrderID, err)
+
+		h.setStatus(r.Context(), &order, orders.StatusFailed, "payment failed: "+err.Error())
+		h.releasePromo(r.Context(), &order)
+		status, message := http.StatusInternalServerError, "Payment processing failed"
+		if errors.Is(err, payment.ErrDeclined) {
+			status, message = http.StatusPaymentRequired, "Payment declined"
+		}
+		w.WriteHeader(status)
 		json.NewEncoder(w).Encode(map[string]string{
-			"error":    "Payment processing failed",
+			"error":    message,
 			"order_id": order.OrderID,
 		})
 		return
 	}
 
-	order.Status = "completed"
+	h.setPaymentStatus(r.Context(), &order, orders.StatusCompleted, "payment captured", auth.ID)
 
 	h.stats.mu.Lock()
 	h.stats.successfulOrders++
@@ -134,6 +185,7 @@ func (h *OrderHandler) HandleSyncOrder(w http.ResponseWriter, r *http.Request) {
 		"order_id":        order.OrderID,
 		"status":          order.Status,
 		"message":         "Order processed successfully",
+		"pricing":         order.Pricing,
 		"processing_time": time.Since(startTime).Seconds(),
 		"processing_mode": "synchronous",
 	}
@@ -142,7 +194,8 @@ func (h *OrderHandler) HandleSyncOrder(w http.ResponseWriter, r *http.Request) {
 	log.Printf("Sync order %s completed in %.2f seconds", order.OrderID, time.Since(startTime).Seconds())
 }
 
-// HandleAsyncOrder accepts orders and publishes to SNS for async processing
+// HandleAsyncOrder accepts orders and queues them in the outbox for SNS
+// publishing and async processing
 func (h *OrderHandler) HandleAsyncOrder(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 
@@ -151,13 +204,14 @@ func (h *OrderHandler) HandleAsyncOrder(w http.ResponseWriter, r *http.Request)
 	h.stats.asyncOrders++
 	h.stats.mu.Unlock()
 
-	var order Order
-	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+	order, problem := validation.DecodeOrder(r.Body, h.rules)
+	if problem != nil {
 		h.stats.mu.Lock()
 		h.stats.failedOrders++
 		h.stats.mu.Unlock()
 
-		http.Error(w, "Invalid order format", http.StatusBadRequest)
+		problem.Instance = r.URL.Path
+		problem.Write(w)
 		return
 	}
 
@@ -165,40 +219,89 @@ func (h *OrderHandler) HandleAsyncOrder(w http.ResponseWriter, r *http.Request)
 		order.OrderID = uuid.New().String()
 	}
 	order.CreatedAt = time.Now()
-	order.Status = "accepted"
+	order.Transition(orders.StatusAccepted, actor, "order received")
 
-	// Publish order to SNS for async processing
-	orderJSON, err := json.Marshal(order)
-	if err != nil {
+	// Compute totals, discounts and tax, redeeming the promo code
+	if _, err := h.pricer.Price(r.Context(), &order); err != nil {
 		h.stats.mu.Lock()
 		h.stats.failedOrders++
 		h.stats.mu.Unlock()
 
-		http.Error(w, "Failed to marshal order", http.StatusInternalServerError)
+		var pricingErr *pricing.Error
+		if !errors.As(err, &pricingErr) {
+			log.Printf("Failed to price order %s: %v", order.OrderID, err)
+			http.Error(w, "Failed to price order", http.StatusInternalServerError)
+			return
+		}
+		problem := validation.Invalid(validation.FieldError{Field: pricingErr.Field, Message: pricingErr.Message})
+		problem.Instance = r.URL.Path
+		problem.Write(w)
 		return
 	}
 
-	input := &sns.PublishInput{
-		Message:  aws.String(string(orderJSON)),
-		TopicArn: aws.String(h.topicArn),
-		MessageAttributes: map[string]types.MessageAttributeValue{
-			"order_id": {
-				DataType:    aws.String("String"),
-				StringValue: aws.String(order.OrderID),
-			},
-		},
+	// Hold stock before accepting, so the flash sale cannot accept more
+	// units than exist; the processor commits or releases the hold
+	if err := h.inventory.Reserve(r.Context(), order.OrderID, inventory.Lines(&order), h.reservationTTL); err != nil {
+		h.stats.mu.Lock()
+		h.stats.failedOrders++
+		h.stats.mu.Unlock()
+		h.releasePromo(r.Context(), &order)
+
+		var soldOut *inventory.SoldOutError
+		switch {
+		case errors.As(err, &soldOut):
+			h.stats.mu.Lock()
+			h.stats.soldOutOrders++
+			h.stats.mu.Unlock()
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":      "Sold out",
+				"order_id":   order.OrderID,
+				"product_id": soldOut.ProductID,
+				"requested":  soldOut.Requested,
+				"available":  soldOut.Available,
+			})
+		case errors.Is(err, inventory.ErrReserved):
+			// Only an accepted order holds a reservation
+			http.Error(w, "Order already exists", http.StatusConflict)
+		default:
+			log.Printf("Failed to reserve stock for order %s: %v", order.OrderID, err)
+			http.Error(w, "Failed to reserve stock", http.StatusInternalServerError)
+		}
+		return
 	}
 
-	_, err = h.snsClient.Publish(context.TODO(), input)
+	// Record the order together with its pending SNS event; the relay
+	// publishes it in the background, so a failed publish never loses an
+	// accepted order
+	correlationID := correlationID(r, order.OrderID)
+	err := h.outbox.CreateOrder(r.Context(), &order, func(o *orders.Order) (*outbox.Event, error) {
+		envelope, err := event.NewOrderCreated(o, correlationID)
+		if err != nil {
+			return nil, err
+		}
+		return outboxEvent(o.OrderID, envelope)
+	})
 	if err != nil {
 		h.stats.mu.Lock()
 		h.stats.failedOrders++
 		h.stats.mu.Unlock()
-
-		log.Printf("Failed to publish to SNS: %v", err)
-		http.Error(w, "Failed to queue order", http.StatusInternalServerError)
+		h.releasePromo(r.Context(), &order)
+		if err := h.inventory.Release(r.Context(), order.OrderID); err != nil {
+			log.Printf("Failed to release stock of order %s: %v", order.OrderID, err)
+		}
+
+		if errors.Is(err, repository.ErrExists) {
+			http.Error(w, "Order already exists", http.StatusConflict)
+			return
+		}
+		log.Printf("Failed to store order %s: %v", order.OrderID, err)
+		http.Error(w, "Failed to store order", http.StatusInternalServerError)
 		return
 	}
+	h.relay.Notify()
 
 	h.stats.mu.Lock()
 	h.stats.successfulOrders++
@@ -210,8 +313,9 @@ func (h *OrderHandler) HandleAsyncOrder(w http.ResponseWriter, r *http.Request)
 
 	response := map[string]interface{}{
 		"order_id":        order.OrderID,
-		"status":          "accepted",
+		"status":          order.Status,
 		"message":         "Order accepted for processing",
+		"pricing":         order.Pricing,
 		"processing_time": time.Since(startTime).Seconds(),
 		"processing_mode": "asynchronous",
 	}
@@ -220,6 +324,329 @@ func (h *OrderHandler) HandleAsyncOrder(w http.ResponseWriter, r *http.Request)
 	log.Printf("Async order %s accepted in %.4f seconds", order.OrderID, time.Since(startTime).Seconds())
 }
 
+// outboxEvent serializes an envelope for the outbox, adding the order ID to
+// its message attributes
+func outboxEvent(orderID string, envelope *event.Envelope) (*outbox.Event, error) {
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+	attributes := envelope.Attributes()
+	attributes["order_id"] = orderID
+	return outbox.NewEvent(orderID, string(body), attributes), nil
+}
+
+// correlationID returns the X-Correlation-ID of the request, or the order
+// ID if the client sent none
+func correlationID(r *http.Request, orderID string) string {
+	if id := r.Header.Get("X-Correlation-ID"); id != "" {
+		return id
+	}
+	return orderID
+}
+
+// publishEvent sends an outbox event to the order topic in the topic's
+// encoding. The outbox holds envelopes, which are published as stored
+// unless the topic takes CloudEvents.
+func (h *OrderHandler) publishEvent(ctx context.Context, queued *outbox.Event) error {
+	if h.codec.Encoding == event.EncodingEnvelope {
+		return h.publisher.Publish(ctx, queued.Payload, queued.Attributes)
+	}
+
+	envelope, err := event.Decode([]byte(queued.Payload))
+	if err != nil {
+		return fmt.Errorf("decode outbox event %s: %w", queued.ID, err)
+	}
+	body, attributes, err := h.codec.Encode(envelope, queued.OrderID)
+	if err != nil {
+		return err
+	}
+	return h.publisher.Publish(ctx, body, attributes)
+}
+
+// HandleListOrders returns the orders of the customer given by the
+// customer_id query parameter, newest first
+func (h *OrderHandler) HandleListOrders(w http.ResponseWriter, r *http.Request) {
+	customerID, err := strconv.Atoi(r.URL.Query().Get("customer_id"))
+	if err != nil {
+		http.Error(w, "Invalid customer_id", http.StatusBadRequest)
+		return
+	}
+
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if n, err := strconv.Atoi(l); err == nil && n > 0 {
+			limit = n
+		}
+	}
+
+	customerOrders, err := h.repo.ListByCustomer(r.Context(), customerID, limit)
+	if err != nil {
+		log.Printf("Failed to list orders for customer %d: %v", customerID, err)
+		http.Error(w, "Failed to list orders", http.StatusInternalServerError)
+		return
+	}
+	if customerOrders == nil {
+		customerOrders = []*orders.Order{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"customer_id": customerID,
+		"orders":      customerOrders,
+	})
+}
+
+// HandleGetOrder returns the current state of an order with its status
+// history
+func (h *OrderHandler) HandleGetOrder(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+
+	order, err := h.repo.Get(r.Context(), orderID)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load order %s: %v", orderID, err)
+		http.Error(w, "Failed to load order", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(order)
+}
+
+// HandleCancelOrder cancels an order whose payment has not started, or
+// refunds it if the payment was captured. Orders being charged return 409;
+// cancelling a cancelled or refunded order again reports its status.
+func (h *OrderHandler) HandleCancelOrder(w http.ResponseWriter, r *http.Request) {
+	orderID := mux.Vars(r)["id"]
+
+	order, err := h.repo.Get(r.Context(), orderID)
+	if errors.Is(err, repository.ErrNotFound) {
+		http.Error(w, "Order not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to load order %s: %v", orderID, err)
+		http.Error(w, "Failed to load order", http.StatusInternalServerError)
+		return
+	}
+
+	message := ""
+	switch order.Status {
+	case orders.StatusAccepted, orders.StatusFailed:
+		if !h.cancel(r.Context(), w, order) {
+			return
+		}
+		message = "Order cancelled"
+	case orders.StatusCompleted, orders.StatusRefunding:
+		if !h.refund(r.Context(), w, order, correlationID(r, orderID)) {
+			return
+		}
+		message = "Order refunded"
+	case orders.StatusCancelled:
+		message = "Order already cancelled"
+	case orders.StatusRefunded:
+		message = "Order already refunded"
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":    "Payment in progress, retry once the order has completed",
+			"order_id": order.OrderID,
+			"status":   order.Status,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"order_id": order.OrderID,
+		"status":   order.Status,
+		"message":  message,
+	})
+}
+
+// cancel marks an order the processor has not charged as cancelled, so the
+// processor skips it, and gives back its stock and promo redemption. It
+// writes the error response and returns false on failure.
+func (h *OrderHandler) cancel(ctx context.Context, w http.ResponseWriter, order *orders.Order) bool {
+	t, err := order.Transition(orders.StatusCancelled, actor, "cancelled by customer")
+	if err == nil {
+		order.Version, err = h.repo.UpdateStatus(ctx, order.OrderID, t, order.Version)
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		// The processor picked the order up in the meantime
+		http.Error(w, "Order changed while cancelling, retry", http.StatusConflict)
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to cancel order %s: %v", order.OrderID, err)
+		http.Error(w, "Failed to cancel order", http.StatusInternalServerError)
+		return false
+	}
+
+	if err := h.inventory.Release(ctx, order.OrderID); err != nil {
+		log.Printf("Failed to release stock of order %s: %v", order.OrderID, err)
+	}
+	h.releasePromo(ctx, order)
+
+	h.stats.mu.Lock()
+	h.stats.cancelledOrders++
+	h.stats.mu.Unlock()
+
+	log.Printf("Order %s cancelled", order.OrderID)
+	return true
+}
+
+// refund returns the captured payment of an order and queues an
+// OrderRefunded event. The order moves to refunding first, so concurrent
+// cancellations cannot refund twice. If the gateway fails it stays there
+// and a retried cancellation tries again; the refund is keyed by order, so
+// the gateway never returns the money twice. It writes the error response
+// and returns false on failure.
+func (h *OrderHandler) refund(ctx context.Context, w http.ResponseWriter, order *orders.Order, correlationID string) bool {
+	authorizationID := order.AuthorizationID()
+	if authorizationID == "" {
+		log.Printf("Order %s has no captured authorization to refund", order.OrderID)
+		http.Error(w, "No captured payment recorded for order", http.StatusConflict)
+		return false
+	}
+	req, err := payment.NewRequest(order)
+	if err != nil {
+		log.Printf("Failed to compute refund of order %s: %v", order.OrderID, err)
+		http.Error(w, "Failed to refund order", http.StatusInternalServerError)
+		return false
+	}
+
+	t, err := order.Transition(orders.StatusRefunding, actor, "cancelled by customer")
+	if err == nil {
+		order.Version, err = h.repo.UpdateStatus(ctx, order.OrderID, t, order.Version)
+	}
+	if errors.Is(err, repository.ErrVersionConflict) {
+		http.Error(w, "Order changed while cancelling, retry", http.StatusConflict)
+		return false
+	}
+	if err != nil {
+		log.Printf("Failed to start refund of order %s: %v", order.OrderID, err)
+		http.Error(w, "Failed to refund order", http.StatusInternalServerError)
+		return false
+	}
+
+	auth := payment.Authorization{ID: authorizationID, Request: req}
+	if err := h.paymentGateway.Refund(ctx, auth, req.Amount, payment.RefundKey(order.OrderID)); err != nil {
+		log.Printf("Refund failed for order %s: %v", order.OrderID, err)
+		http.Error(w, "Refund failed, retry the cancellation", http.StatusInternalServerError)
+		return false
+	}
+	if err := h.recordRefund(ctx, order, authorizationID, req.Amount, correlationID); err != nil {
+		log.Printf("Failed to record refund of order %s: %v", order.OrderID, err)
+		http.Error(w, "Refund issued but not yet recorded", http.StatusInternalServerError)
+		return false
+	}
+	h.relay.Notify()
+	h.releasePromo(ctx, order)
+
+	h.stats.mu.Lock()
+	h.stats.refundedOrders++
+	h.stats.mu.Unlock()
+
+	log.Printf("Order %s refunded %s", order.OrderID, req.Amount)
+	return true
+}
+
+// recordRefund runs finishRefund up to recordRefundAttempts times,
+// reloading the order between attempts. The gateway has already returned
+// the money, so it is not called again.
+func (h *OrderHandler) recordRefund(ctx context.Context, order *orders.Order, authorizationID string, amount money.Money, correlationID string) error {
+	for attempt := 1; ; attempt++ {
+		err := h.finishRefund(ctx, order, authorizationID, amount, correlationID)
+		if err == nil || attempt == recordRefundAttempts {
+			return err
+		}
+		log.Printf("Failed to record refund of order %s, retrying: %v", order.OrderID, err)
+
+		select {
+		case <-time.After(time.Duration(attempt) * recordRefundBackoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		// finishRefund moved the copy in hand to refunded
+		current, getErr := h.repo.Get(ctx, order.OrderID)
+		if getErr != nil {
+			return fmt.Errorf("%w (reload: %v)", err, getErr)
+		}
+		*order = *current
+		if order.Status == orders.StatusRefunded {
+			// The write went through before the error was reported
+			return nil
+		}
+	}
+}
+
+// finishRefund moves a refunded order to refunded and queues its
+// OrderRefunded event in the outbox, in one transaction so the event is
+// never lost
+func (h *OrderHandler) finishRefund(ctx context.Context, order *orders.Order, authorizationID string, amount money.Money, correlationID string) error {
+	envelope, err := event.New(event.OrderRefunded, correlationID, event.OrderRefundedPayload{
+		OrderID:         order.OrderID,
+		CustomerID:      order.CustomerID,
+		AuthorizationID: authorizationID,
+		Amount:          amount,
+		RefundedAt:      time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	queued, err := outboxEvent(order.OrderID, envelope)
+	if err != nil {
+		return err
+	}
+
+	t, err := order.PaymentTransition(orders.StatusRefunded, actor, "refunded "+amount.String(), authorizationID)
+	if err != nil {
+		return err
+	}
+	order.Version, err = h.outbox.UpdateStatus(ctx, order.OrderID, t, order.Version, queued)
+	return err
+}
+
+// releasePromo gives back the promo redemption of an order that was not
+// accepted
+func (h *OrderHandler) releasePromo(ctx context.Context, order *orders.Order) {
+	if err := h.pricer.Release(ctx, order); err != nil {
+		log.Printf("Failed to release promo %s of order %s: %v", order.PromoCode, order.OrderID, err)
+	}
+}
+
+// setStatus records an order status change, using the order's version for
+// optimistic locking. The response does not depend on it, so failures are
+// only logged.
+func (h *OrderHandler) setStatus(ctx context.Context, order *orders.Order, status orders.Status, reason string) {
+	h.setPaymentStatus(ctx, order, status, reason, "")
+}
+
+// setPaymentStatus is setStatus for a change that captures or refunds the
+// payment authorization authorizationID
+func (h *OrderHandler) setPaymentStatus(ctx context.Context, order *orders.Order, status orders.Status, reason, authorizationID string) {
+	t, err := order.PaymentTransition(status, actor, reason, authorizationID)
+	if err != nil {
+		log.Printf("Failed to set order %s to %s: %v", order.OrderID, status, err)
+		return
+	}
+	version, err := h.repo.UpdateStatus(ctx, order.OrderID, t, order.Version)
+	if err != nil {
+		log.Printf("Failed to set order %s to %s: %v", order.OrderID, status, err)
+		return
+	}
+	order.Version = version
+}
+
 // HandleHealth returns health status
 func (h *OrderHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -232,6 +659,11 @@ func (h *OrderHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
 
 // HandleStats returns system statistics
 func (h *OrderHandler) HandleStats(w http.ResponseWriter, r *http.Request) {
+	outboxStats, err := h.relay.Stats(r.Context())
+	if err != nil {
+		log.Printf("Failed to read outbox lag: %v", err)
+	}
+
 	h.stats.mu.Lock()
 	defer h.stats.mu.Unlock()
 
@@ -243,35 +675,187 @@ func (h *OrderHandler) HandleStats(w http.ResponseWriter, r *http.Request) {
 		"async_orders":      h.stats.asyncOrders,
 		"successful_orders": h.stats.successfulOrders,
 		"failed_orders":     h.stats.failedOrders,
+		"sold_out_orders":   h.stats.soldOutOrders,
+		"cancelled_orders":  h.stats.cancelledOrders,
+		"refunded_orders":   h.stats.refundedOrders,
 		"success_rate":      float64(h.stats.successfulOrders) / float64(h.stats.totalRequests) * 100,
+		"outbox": map[string]interface{}{
+			"pending":          outboxStats.Pending,
+			"lag_seconds":      outboxStats.Age(time.Now()).Seconds(),
+			"published":        outboxStats.Published,
+			"publish_failures": outboxStats.PublishFailures,
+		},
 	})
 }
 
+// startLocalProcessor subscribes a queue to the broker's orders topic and
+// processes it in the background with the receiver's order, inventory and
+// promo stores, which live in this process too. Messages that fail for
+// good, or run out of attempts, end up in the broker's orders-dlq queue.
+// The processor settings are read from the environment as in the order
+// processor service.
+func startLocalProcessor(ctx context.Context, broker *messaging.Broker, repo repository.OrderRepository,
+	inventoryStore inventory.Store, reservationTTL time.Duration, pricer *pricing.Engine) error {
+	cfg, err := processor.ConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	if cfg.Queue, err = messaging.QueueConfigFromEnv(); err != nil {
+		return err
+	}
+	ackConfig, err := messaging.AckConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	if cfg.Unwrapper, err = messaging.UnwrapperFromEnv(); err != nil {
+		return err
+	}
+	if cfg.Retry, err = retry.PolicyFromEnv(); err != nil {
+		return err
+	}
+	if cfg.Payment, err = payment.NewGatewayFromEnv(payment.DefaultSimulatedConfig()); err != nil {
+		return err
+	}
+	if cfg.Ledger, err = ledger.NewStoreFromEnv(ctx); err != nil {
+		return err
+	}
+	if cfg.LedgerConfig, err = ledger.ConfigFromEnv(); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+
+	queue := broker.Queue("orders", cfg.Queue)
+	broker.Topic("orders").Subscribe(queue, false)
+	deadLetters := broker.Queue("orders-dlq", cfg.Queue)
+	queue.SetRedrive(deadLetters, cfg.Retry.MaxAttempts)
+	broker.Topic("orders-dlq").Subscribe(deadLetters, true)
+
+	cfg.Consumer = queue
+	cfg.Acker = messaging.NewAcker(queue, ackConfig)
+	cfg.DeadLetters = broker.Topic("orders-dlq")
+	cfg.Orders = repo
+	cfg.Inventory = inventoryStore
+	cfg.ReservationTTL = reservationTTL
+	cfg.Pricer = pricer
+
+	go processor.New(cfg).Start(ctx)
+	return nil
+}
+
 func main() {
-	// Get SNS topic ARN from environment
+	// Select the message bus; MESSAGE_BROKER=memory runs without AWS
+	var publisher messaging.EventPublisher
+	// broker is the in-process message bus, nil with AWS
+	var broker *messaging.Broker
 	topicArn := os.Getenv("SNS_TOPIC_ARN")
-	if topicArn == "" {
-		log.Fatal("SNS_TOPIC_ARN environment variable not set")
+	switch kind := os.Getenv("MESSAGE_BROKER"); kind {
+	case "", "aws":
+		if topicArn == "" {
+			log.Fatal("SNS_TOPIC_ARN environment variable not set")
+		}
+
+		// Initialize AWS SDK
+		cfg, err := config.LoadDefaultConfig(context.TODO())
+		if err != nil {
+			log.Fatal("Unable to load AWS SDK config:", err)
+		}
+		publisher = messaging.NewSNSPublisher(sns.NewFromConfig(cfg), topicArn)
+
+	case "memory":
+		// The order processor runs in this process and consumes the topic
+		topicArn = "memory:orders"
+		broker = messaging.NewBroker()
+		publisher = broker.Topic("orders")
+
+	default:
+		log.Fatalf("Unknown MESSAGE_BROKER %q", kind)
+	}
+
+	// Create payment gateway with bottleneck (one payment at a time by default)
+	defaults := payment.DefaultSimulatedConfig()
+	defaults.MaxConcurrent = 1
+	paymentGateway, err := payment.NewGatewayFromEnv(defaults)
+	if err != nil {
+		log.Fatal("Invalid payment configuration:", err)
+	}
+
+	// Create order store shared with the processor
+	orderRepo, err := repository.NewFromEnv(context.TODO())
+	if err != nil {
+		log.Fatal("Invalid order store configuration:", err)
+	}
+
+	// Outbox for async orders, kept in the order store so an order and its
+	// event are written together
+	outboxStore, err := outbox.NewStoreFromEnv(context.TODO(), orderRepo)
+	if err != nil {
+		log.Fatal("Invalid outbox configuration:", err)
+	}
+	relayConfig, err := outbox.ConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid outbox configuration:", err)
 	}
 
-	// Initialize AWS SDK
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	// Promo codes and tax rules (PRICING_CONFIG), with redemption counts
+	// kept next to the orders
+	pricer, err := pricing.NewEngineFromEnv(context.TODO())
 	if err != nil {
-		log.Fatal("Unable to load AWS SDK config:", err)
+		log.Fatal("Invalid pricing configuration:", err)
 	}
 
-	snsClient := sns.NewFromConfig(cfg)
+	// Stock levels (seeded from INVENTORY_STOCK) and order reservations
+	inventoryStore, err := inventory.NewStoreFromEnv(context.TODO())
+	if err != nil {
+		log.Fatal("Invalid inventory configuration:", err)
+	}
+	inventoryConfig, err := inventory.ConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid inventory configuration:", err)
+	}
 
-	// Create payment processor with bottleneck
-	paymentProcessor := NewPaymentProcessor()
+	// Envelope, CloudEvents structured or binary messages, per topic
+	encodings, err := event.EncodingsFromEnv()
+	if err != nil {
+		log.Fatal("Invalid event encoding configuration:", err)
+	}
+	codec := event.Codec{Encoding: encodings.For(topicArn), Source: "/" + actor}
+	log.Printf("Publishing %s events", codec.Encoding)
 
 	// Create order handler
-	orderHandler := NewOrderHandler(paymentProcessor, snsClient, topicArn)
+	orderHandler := NewOrderHandler(paymentGateway, publisher, orderRepo, outboxStore, relayConfig, pricer,
+		inventoryStore, inventoryConfig.ReservationTTL, codec)
+
+	// Return stock held by orders that were never processed
+	go inventory.Sweep(context.Background(), inventoryStore, inventoryConfig.SweepInterval)
+
+	// Process async orders in this process when the broker is in memory;
+	// the processor subscribes before the relay publishes anything
+	if broker != nil {
+		if err := startLocalProcessor(context.Background(), broker, orderRepo, inventoryStore,
+			inventoryConfig.ReservationTTL, pricer); err != nil {
+			log.Fatal("Invalid processor configuration:", err)
+		}
+	}
+
+	// Publish pending events to SNS in the background
+	go orderHandler.relay.Run(context.Background())
+
+	// Idempotency-Key support for order creation
+	idempotencyStore, err := idempotency.NewStoreFromEnv(context.TODO())
+	if err != nil {
+		log.Fatal("Invalid idempotency store configuration:", err)
+	}
+	idempotent := idempotency.Middleware(idempotencyStore, idempotency.DefaultConfig())
 
 	// Setup routes
 	router := mux.NewRouter()
-	router.HandleFunc("/orders/sync", orderHandler.HandleSyncOrder).Methods("POST")
-	router.HandleFunc("/orders/async", orderHandler.HandleAsyncOrder).Methods("POST")
+	router.HandleFunc("/orders/sync", idempotent(orderHandler.HandleSyncOrder)).Methods("POST")
+	router.HandleFunc("/orders/async", idempotent(orderHandler.HandleAsyncOrder)).Methods("POST")
+	router.HandleFunc("/orders", orderHandler.HandleListOrders).Methods("GET").Queries("customer_id", "{customer_id}")
+	router.HandleFunc("/orders/{id}", orderHandler.HandleGetOrder).Methods("GET")
+	router.HandleFunc("/orders/{id}/cancel", orderHandler.HandleCancelOrder).Methods("POST")
 	router.HandleFunc("/health", orderHandler.HandleHealth).Methods("GET")
 	router.HandleFunc("/stats", orderHandler.HandleStats).Methods("GET")
 
@@ -279,7 +863,10 @@ func main() {
 	port := ":8080"
 	log.Printf("Starting order receiver service on port %s", port)
 	log.Printf("SNS Topic: %s", topicArn)
-	log.Printf("Endpoints: /orders/sync (3s delay) and /orders/async (<100ms)")
+	log.Printf("Endpoints: /orders/sync, /orders/async (<100ms), /orders/{id} and /orders/{id}/cancel")
+	if d, ok := payment.ExpectedChargeTime(paymentGateway); ok {
+		log.Printf("/orders/sync waits about %s for the payment", d.Round(time.Millisecond))
+	}
 
 	if err := http.ListenAndServe(port, router); err != nil {
 		log.Fatal("Server failed to start:", err)
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/event"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/inventory"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/messaging"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/outbox"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/payment"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/pricing"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+)
+
+// TestAsyncOrderInMemory runs the receiver and the processor in one
+// process on the in-memory broker, as MESSAGE_BROKER=memory does, and
+// follows an async order until it is charged
+func TestAsyncOrderInMemory(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding event.Encoding
+	}{
+		{name: "envelope", encoding: event.EncodingEnvelope},
+		{name: "structured", encoding: event.EncodingStructured},
+		{name: "binary", encoding: event.EncodingBinary},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Signature verification stays on: the broker's envelopes
+			// must pass without one
+			t.Setenv("PAYMENT_DELAY", "0s")
+			t.Setenv("ACK_MAX_DELAY", "10ms")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			broker := messaging.NewBroker()
+			repo := repository.NewMemory()
+			inventoryStore := inventory.NewMemoryStore()
+			if err := inventoryStore.Seed(ctx, map[string]int{"p-1": 5}); err != nil {
+				t.Fatal(err)
+			}
+			pricer, err := pricing.NewEngine(pricing.Config{}, pricing.NewMemoryRedemptions())
+			if err != nil {
+				t.Fatal(err)
+			}
+			relayConfig, err := outbox.ConfigFromEnv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			relayConfig.PollInterval = 10 * time.Millisecond
+
+			handler := NewOrderHandler(payment.NewSimulatedGateway(payment.SimulatedConfig{}), broker.Topic("orders"),
+				repo, outbox.NewMemoryStore(repo), relayConfig, pricer, inventoryStore, time.Minute,
+				event.Codec{Encoding: tt.encoding, Source: "/" + actor})
+			if err := startLocalProcessor(ctx, broker, repo, inventoryStore, time.Minute, pricer); err != nil {
+				t.Fatalf("startLocalProcessor: %v", err)
+			}
+			go handler.relay.Run(ctx)
+
+			body := `{"customer_id": 1, "items": [{"product_id": "p-1", "quantity": 2, "price": 10.50}]}`
+			req := httptest.NewRequest(http.MethodPost, "/orders/async", strings.NewReader(body))
+			rec := httptest.NewRecorder()
+			handler.HandleAsyncOrder(rec, req)
+			if rec.Code != http.StatusAccepted {
+				t.Fatalf("POST /orders/async = %d: %s", rec.Code, rec.Body)
+			}
+			var accepted struct {
+				OrderID string `json:"order_id"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&accepted); err != nil {
+				t.Fatal(err)
+			}
+
+			deadline := time.Now().Add(10 * time.Second)
+			for {
+				order, err := repo.Get(ctx, accepted.OrderID)
+				if err != nil {
+					t.Fatalf("Get: %v", err)
+				}
+				if order.Status == orders.StatusCompleted {
+					break
+				}
+				if order.Status == orders.StatusFailed || time.Now().After(deadline) {
+					t.Fatalf("order %s is %s, want %s", order.OrderID, order.Status, orders.StatusCompleted)
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+
+			// The message is acknowledged, not dead-lettered
+			for broker.Queue("orders", messaging.QueueConfig{}).Len() > 0 {
+				if time.Now().After(deadline) {
+					t.Fatal("message was not deleted")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if n := broker.Queue("orders-dlq", messaging.QueueConfig{}).Len(); n != 0 {
+				t.Errorf("dead-letter queue holds %d messages", n)
+			}
+		})
+	}
+}
@@ -25,6 +25,75 @@ module "sns" {
   topic_name = var.sns_topic_name
 }
 
+# DynamoDB table for order status lookups
+module "orders_table" {
+  source     = "./modules/dynamodb"
+  table_name = var.orders_table_name
+  hash_key   = "order_id"
+
+  # Used by ListByCustomer (GET /orders?customer_id=...)
+  global_secondary_indexes = [{
+    name           = "customer_id-created_at-index"
+    hash_key       = "customer_id"
+    hash_key_type  = "N"
+    range_key      = "created_at"
+    range_key_type = "S"
+  }]
+}
+
+# DynamoDB outbox of SNS events written together with async orders
+module "outbox_table" {
+  source        = "./modules/dynamodb"
+  table_name    = var.outbox_table_name
+  hash_key      = "event_id"
+  ttl_attribute = "expires_at"
+
+  # Sparse index of unsent events, read by the relay
+  global_secondary_indexes = [{
+    name           = "pending-created_at-index"
+    hash_key       = "pending"
+    hash_key_type  = "S"
+    range_key      = "created_at"
+    range_key_type = "S"
+  }]
+}
+
+# DynamoDB table for Idempotency-Key replay on POST /orders/*
+module "idempotency_table" {
+  source        = "./modules/dynamodb"
+  table_name    = var.idempotency_table_name
+  hash_key      = "idempotency_key"
+  ttl_attribute = "expires_at"
+}
+
+# DynamoDB stock levels and per-order reservations for oversell protection
+module "inventory_table" {
+  source     = "./modules/dynamodb"
+  table_name = var.inventory_table_name
+  hash_key   = "product_id"
+}
+
+module "reservations_table" {
+  source     = "./modules/dynamodb"
+  table_name = var.reservations_table_name
+  hash_key   = "order_id"
+}
+
+# DynamoDB counters enforcing promo code redemption limits
+module "promo_redemptions_table" {
+  source     = "./modules/dynamodb"
+  table_name = var.promo_table_name
+  hash_key   = "code"
+}
+
+# DynamoDB ledger of charged orders so redelivered events are not charged twice
+module "processed_orders_table" {
+  source        = "./modules/dynamodb"
+  table_name    = var.ledger_table_name
+  hash_key      = "order_id"
+  ttl_attribute = "expires_at"
+}
+
 # REMOVED: SQS module - not needed for Lambda
 # REMOVED: ECR for processor - Lambda doesn't use ECR
 
@@ -57,7 +126,16 @@ module "ecs_receiver" {
 
   # Environment variables for receiver
   environment_variables = {
-    SNS_TOPIC_ARN = module.sns.topic_arn
+    SNS_TOPIC_ARN      = module.sns.topic_arn
+    ORDER_STORE        = "dynamodb"
+    ORDERS_TABLE       = module.orders_table.table_name
+    IDEMPOTENCY_TABLE  = module.idempotency_table.table_name
+    OUTBOX_TABLE       = module.outbox_table.table_name
+    PROMO_TABLE        = module.promo_redemptions_table.table_name
+    INVENTORY_TABLE    = module.inventory_table.table_name
+    RESERVATIONS_TABLE = module.reservations_table.table_name
+    INVENTORY_STOCK    = var.inventory_stock
+    EVENT_ENCODING     = var.event_encoding
   }
 }
 
@@ -66,13 +144,14 @@ module "ecs_receiver" {
 # Build Lambda deployment package
 resource "null_resource" "lambda_build" {
   triggers = {
-    source_hash = filemd5("../src/order-processor-lambda/main.go")
+    source_hash = sha1(join("", [for f in fileset("../src/order-processor-lambda", "*.go") : filemd5("../src/order-processor-lambda/${f}")]))
+    shared_hash = sha1(join("", [for f in fileset("../../shared", "**/*.go") : filemd5("../../shared/${f}")]))
   }
 
   provisioner "local-exec" {
     command = <<EOT
       cd ../src/order-processor-lambda
-      GOOS=linux GOARCH=amd64 go build -o bootstrap main.go
+      GOOS=linux GOARCH=amd64 go build -o bootstrap .
       zip -j function.zip bootstrap
     EOT
   }
@@ -84,6 +163,12 @@ data "local_file" "lambda_zip" {
   depends_on = [null_resource.lambda_build]
 }
 
+# Failure destination for SNS records the Lambda cannot process
+resource "aws_sqs_queue" "lambda_failures" {
+  name                      = "${var.service_name}-processor-failures"
+  message_retention_seconds = 1209600 # 14 days
+}
+
 # Lambda function for order processing
 module "lambda_processor" {
   source = "./modules/lambda"
@@ -98,18 +183,40 @@ module "lambda_processor" {
   log_retention_days = var.log_retention_days
 
   environment_variables = {
-    LOG_LEVEL = "INFO"
+    LOG_LEVEL    = "INFO"
+    ORDER_STORE  = "dynamodb"
+    ORDERS_TABLE = module.orders_table.table_name
+    LEDGER_TABLE = module.processed_orders_table.table_name
+
+    INVENTORY_TABLE    = module.inventory_table.table_name
+    RESERVATIONS_TABLE = module.reservations_table.table_name
+    PROMO_TABLE        = module.promo_redemptions_table.table_name
+
+    FAILURE_DESTINATION = aws_sqs_queue.lambda_failures.url
   }
 
   depends_on = [null_resource.lambda_build]
 }
 
+# SNS invocations that still fail after Lambda's retries, e.g. a payment
+# gateway outage, land in the failure queue as well
+resource "aws_lambda_function_event_invoke_config" "processor" {
+  function_name = module.lambda_processor.function_name
+
+  destination_config {
+    on_failure {
+      destination = aws_sqs_queue.lambda_failures.arn
+    }
+  }
+}
+
 # Build & push Order Receiver image (keep this)
 resource "docker_image" "receiver" {
   name = "${module.ecr_receiver.repository_url}:latest"
 
   build {
-    context = "../src/order-receiver"
+    context    = "../.."
+    dockerfile = "3-ServerlessArchitecture/src/order-receiver/Dockerfile"
   }
 }
 
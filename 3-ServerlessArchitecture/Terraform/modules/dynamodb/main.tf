@@ -0,0 +1,46 @@
+locals {
+  # Every key attribute (table and indexes) must be declared once
+  index_attributes = flatten([
+    for idx in var.global_secondary_indexes : [
+      { name = idx.hash_key, type = idx.hash_key_type },
+      { name = idx.range_key, type = idx.range_key_type },
+    ]
+  ])
+  attributes = { for attr in concat([{ name = var.hash_key, type = "S" }], local.index_attributes) : attr.name => attr.type... }
+}
+
+resource "aws_dynamodb_table" "this" {
+  name         = var.table_name
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = var.hash_key
+
+  dynamic "attribute" {
+    for_each = local.attributes
+    content {
+      name = attribute.key
+      type = attribute.value[0]
+    }
+  }
+
+  dynamic "global_secondary_index" {
+    for_each = var.global_secondary_indexes
+    content {
+      name            = global_secondary_index.value.name
+      hash_key        = global_secondary_index.value.hash_key
+      range_key       = global_secondary_index.value.range_key
+      projection_type = "ALL"
+    }
+  }
+
+  dynamic "ttl" {
+    for_each = var.ttl_attribute == null ? [] : [var.ttl_attribute]
+    content {
+      attribute_name = ttl.value
+      enabled        = true
+    }
+  }
+
+  tags = {
+    Name = var.table_name
+  }
+}
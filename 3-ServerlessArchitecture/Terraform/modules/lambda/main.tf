@@ -15,8 +15,13 @@ resource "aws_lambda_function" "this" {
   timeout         = var.timeout
   memory_size     = var.memory_size
 
+  # The function changes the visibility of failed records on the queue it
+  # is fed by
   environment {
-    variables = var.environment_variables
+    variables = merge(
+      var.environment_variables,
+      var.sqs_queue_arn == null ? {} : { SQS_QUEUE_ARN = var.sqs_queue_arn },
+    )
   }
 
   depends_on = [aws_cloudwatch_log_group.lambda_logs]
@@ -36,4 +41,13 @@ resource "aws_sns_topic_subscription" "lambda_subscription" {
   topic_arn = var.sns_topic_arn
   protocol  = "lambda"
   endpoint  = aws_lambda_function.this.arn
-}
\ No newline at end of file
+}
+# Optional SQS event source; failed records are reported individually
+resource "aws_lambda_event_source_mapping" "sqs" {
+  count = var.sqs_queue_arn == null ? 0 : 1
+
+  event_source_arn        = var.sqs_queue_arn
+  function_name           = aws_lambda_function.this.arn
+  batch_size              = var.sqs_batch_size
+  function_response_types = ["ReportBatchItemFailures"]
+}
@@ -57,4 +57,16 @@ variable "log_retention_days" {
   description = "CloudWatch log retention in days"
   type        = number
   default     = 7
-}
\ No newline at end of file
+}
+
+variable "sqs_queue_arn" {
+  description = "ARN of an SQS queue to consume, null for SNS only"
+  type        = string
+  default     = null
+}
+
+variable "sqs_batch_size" {
+  description = "Records per invocation from the SQS queue"
+  type        = number
+  default     = 10
+}
@@ -83,6 +83,62 @@ variable "sns_topic_name" {
   default = "order-processing-events"
 }
 
+# DynamoDB table holding order status
+variable "orders_table_name" {
+  type    = string
+  default = "orders"
+}
+
+# DynamoDB table holding SNS events waiting to be published
+variable "outbox_table_name" {
+  type    = string
+  default = "order-outbox"
+}
+
+# DynamoDB table holding Idempotency-Key responses
+variable "idempotency_table_name" {
+  type    = string
+  default = "idempotency-keys"
+}
+
+# DynamoDB table of product stock levels
+variable "inventory_table_name" {
+  type    = string
+  default = "inventory"
+}
+
+# DynamoDB table of stock held by accepted orders
+variable "reservations_table_name" {
+  type    = string
+  default = "inventory-reservations"
+}
+
+# Initial stock of flash sale products, e.g. "PROD-101=50,PROD-102=10";
+# products without a level are not limited
+variable "inventory_stock" {
+  type    = string
+  default = ""
+}
+
+# Encoding of events published on the order topic: "envelope", or
+# CloudEvents "structured" or "binary"
+variable "event_encoding" {
+  type    = string
+  default = "envelope"
+}
+
+# DynamoDB table counting promo code redemptions
+variable "promo_table_name" {
+  type    = string
+  default = "promo-redemptions"
+}
+
+# DynamoDB table recording which orders have already been charged
+variable "ledger_table_name" {
+  type    = string
+  default = "processed-orders"
+}
+
 # REMOVED: All SQS-related variables
 # REMOVED: processor_task_count
 # REMOVED: processor_worker_count
\ No newline at end of file
@@ -3,77 +3,589 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/event"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/inventory"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/ledger"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/messaging"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/payment"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/pricing"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/retry"
 )
 
-// Order represents an e-commerce order
-type Order struct {
-	OrderID    string    `json:"order_id"`
-	CustomerID int       `json:"customer_id"`
-	Status     string    `json:"status"`
-	Items      []Item    `json:"items"`
-	CreatedAt  time.Time `json:"created_at"`
+// actor names this service in order status history
+const actor = "order-processor-lambda"
+
+// Shared by all invocations of a warm Lambda instance
+var (
+	paymentGateway payment.Gateway
+	orderRepo      repository.OrderRepository
+	orderLedger    ledger.Store
+	ledgerConfig   ledger.Config
+	stock          inventory.Store
+	stockConfig    inventory.Config
+	pricer         *pricing.Engine
+	unwrapper      *messaging.Unwrapper
+	retryPolicy    retry.Policy
+	// sourceQueue changes the visibility of failed SQS records; nil
+	// without SQS_QUEUE_ARN
+	sourceQueue *messaging.SQSConsumer
+	// deadLetters receives SQS records that can never succeed; nil
+	// leaves them to the queue's redrive policy
+	deadLetters messaging.EventPublisher
+	// failureDestination receives SNS records that could not be
+	// processed; nil means they are only logged
+	failureDestination messaging.EventPublisher
+)
+
+// failedRecord is sent to the failure destination
+type failedRecord struct {
+	Source    string    `json:"source"`
+	MessageID string    `json:"message_id"`
+	Error     string    `json:"error"`
+	Body      string    `json:"body"`
+	FailedAt  time.Time `json:"failed_at"`
+}
+
+// setStatus records an order status change, using the order's version for
+// optimistic locking. Status tracking is best effort and never blocks
+// payment processing.
+func setStatus(ctx context.Context, order *orders.Order, status orders.Status, reason string) {
+	setPaymentStatus(ctx, order, status, reason, "")
+}
+
+// setPaymentStatus is setStatus for a change that captures the payment
+// authorization authorizationID
+func setPaymentStatus(ctx context.Context, order *orders.Order, status orders.Status, reason, authorizationID string) {
+	t, err := order.PaymentTransition(status, actor, reason, authorizationID)
+	if err != nil {
+		log.Printf("Failed to set order %s to %s: %v", order.OrderID, status, err)
+		return
+	}
+	version, err := orderRepo.UpdateStatus(ctx, order.OrderID, t, order.Version)
+	if err != nil {
+		log.Printf("Failed to set order %s to %s: %v", order.OrderID, status, err)
+		return
+	}
+	order.Version = version
 }
 
-// Item represents an item in an order
-type Item struct {
-	ProductID string  `json:"product_id"`
-	Quantity  int     `json:"quantity"`
-	Price     float64 `json:"price"`
+// startPayment moves the order to processing, which keeps the receiver
+// from cancelling it. It returns false if the order may not be charged,
+// because the state machine refuses the move or the order changed since
+// it was loaded; other failures are logged and do not stop the payment.
+func startPayment(ctx context.Context, order *orders.Order) bool {
+	t, err := order.Transition(orders.StatusProcessing, actor, "payment started")
+	if err != nil {
+		log.Printf("Failed to set order %s to %s: %v", order.OrderID, orders.StatusProcessing, err)
+		return false
+	}
+	version, err := orderRepo.UpdateStatus(ctx, order.OrderID, t, order.Version)
+	if errors.Is(err, repository.ErrVersionConflict) {
+		if stored, getErr := orderRepo.Get(ctx, order.OrderID); getErr == nil && !stored.Status.Chargeable() {
+			*order = *stored
+			return false
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to set order %s to %s: %v", order.OrderID, orders.StatusProcessing, err)
+		return true
+	}
+	order.Version = version
+	return true
 }
 
-// ProcessPayment simulates payment processing with 3-second delay
-func ProcessPayment(orderID string) error {
-	log.Printf("Processing payment for order %s...", orderID)
-	time.Sleep(3 * time.Second)
-	log.Printf("Payment processed for order %s", orderID)
+// skipPayment finishes an order that can no longer be charged. A
+// cancelled order gives up its stock and promo redemption; one that was
+// already charged keeps them.
+func skipPayment(ctx context.Context, order *orders.Order) {
+	if order.Status == orders.StatusCancelled {
+		log.Printf("Order %s was cancelled, skipping payment", order.OrderID)
+		releaseStock(ctx, order)
+		releasePromo(ctx, order)
+	} else {
+		log.Printf("Order %s is %q, skipping payment", order.OrderID, order.Status)
+	}
+	if err := orderLedger.MarkDone(ctx, order.OrderID, ledgerConfig.TTL); err != nil {
+		log.Printf("Failed to record order %s in ledger: %v", order.OrderID, err)
+	}
+}
+
+// releaseStock gives back the stock held for an order that will not be
+// charged
+func releaseStock(ctx context.Context, order *orders.Order) {
+	if err := stock.Release(ctx, order.OrderID); err != nil {
+		log.Printf("Failed to release stock of order %s: %v", order.OrderID, err)
+	}
+}
+
+// releasePromo gives back the promo redemption of an order that failed for
+// good or was cancelled. A retried order keeps it, as it will be charged at
+// the discounted price.
+func releasePromo(ctx context.Context, order *orders.Order) {
+	if err := pricer.Release(ctx, order); err != nil {
+		log.Printf("Failed to release promo %s of order %s: %v", order.PromoCode, order.OrderID, err)
+	}
+}
+
+// processOrder charges the order of the OrderCreated event in body, an
+// envelope or a CloudEvent in either mode. Orders the ledger has already
+// seen succeed without charging again, and other events on the topic,
+// such as refunds, are skipped. An order another invocation is charging
+// fails with ledger.ErrInProgress.
+func processOrder(ctx context.Context, body string, attributes map[string]string) error {
+	envelope, err := event.DecodeMessage([]byte(body), attributes)
+	if errors.Is(err, event.ErrUnknownType) || (err == nil && envelope.Type != event.OrderCreated) {
+		log.Printf("Skipping %s event %s", envelope.Type, envelope.ID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode event: %w", err)
+	}
+	parsed, err := envelope.Order()
+	if err != nil {
+		return fmt.Errorf("failed to parse order: %w", err)
+	}
+	order := *parsed
+
+	log.Printf("Processing order %s from customer %d (event %s, correlation %s)",
+		order.OrderID, order.CustomerID, envelope.ID, envelope.CorrelationID)
+
+	// SNS delivers at least once; the ledger keeps a retried or
+	// duplicated event from charging the customer again
+	claim, err := orderLedger.Claim(ctx, order.OrderID, ledgerConfig.Lease)
+	if err != nil {
+		if errors.Is(err, ledger.ErrAlreadyProcessed) {
+			log.Printf("Order %s already processed, skipping duplicate event", order.OrderID)
+			return nil
+		}
+		return fmt.Errorf("failed to claim order %s: %w", order.OrderID, err)
+	}
+
+	// The stored version is authoritative; the message copy is stale
+	// after earlier delivery attempts
+	if stored, err := orderRepo.Get(ctx, order.OrderID); err == nil {
+		order.Version = stored.Version
+		order.Status = stored.Status
+		order.History = stored.History
+	}
+	if !order.Status.Chargeable() {
+		skipPayment(ctx, &order)
+		return nil
+	}
+
+	// Make sure the order still holds its stock; the receiver's hold may
+	// have expired or been released by an earlier failed attempt
+	err = stock.Reserve(ctx, order.OrderID, inventory.Lines(&order), stockConfig.ReservationTTL)
+	switch {
+	case err == nil, errors.Is(err, inventory.ErrReserved):
+	case errors.Is(err, inventory.ErrSoldOut):
+		// Retrying cannot help; the order fails without a charge
+		log.Printf("Order %s cannot be fulfilled: %v", order.OrderID, err)
+		if order.Status != orders.StatusFailed {
+			setStatus(ctx, &order, orders.StatusFailed, "sold out: "+err.Error())
+		}
+		releasePromo(ctx, &order)
+		if err := orderLedger.MarkDone(ctx, order.OrderID, ledgerConfig.TTL); err != nil {
+			log.Printf("Failed to record order %s in ledger: %v", order.OrderID, err)
+		}
+		return nil
+	default:
+		if err := orderLedger.Release(ctx, order.OrderID, claim); err != nil {
+			log.Printf("Failed to release order %s in ledger: %v", order.OrderID, err)
+		}
+		return fmt.Errorf("failed to reserve stock for order %s: %w", order.OrderID, err)
+	}
+
+	// Process payment (takes the configured payment latency, 3s by default)
+	startTime := time.Now()
+	if !startPayment(ctx, &order) {
+		skipPayment(ctx, &order)
+		return nil
+	}
+	auth, err := payment.ChargeOrder(ctx, paymentGateway, &order)
+	if err != nil {
+		setStatus(ctx, &order, orders.StatusFailed, "payment failed: "+err.Error())
+		if retry.IsTerminal(err) {
+			// A declined payment is the order's outcome; retrying the
+			// record cannot change it
+			log.Printf("Payment for order %s was refused: %v", order.OrderID, err)
+			releaseStock(ctx, &order)
+			releasePromo(ctx, &order)
+			if err := orderLedger.MarkDone(ctx, order.OrderID, ledgerConfig.TTL); err != nil {
+				log.Printf("Failed to record order %s in ledger: %v", order.OrderID, err)
+			}
+			return nil
+		}
+		// The retry charges the order, so it keeps its stock; a record
+		// that is given up returns it when the hold expires
+		if err := orderLedger.Release(ctx, order.OrderID, claim); err != nil {
+			log.Printf("Failed to release order %s in ledger: %v", order.OrderID, err)
+		}
+		return fmt.Errorf("payment processing failed for order %s: %w", order.OrderID, err)
+	}
+	if err := orderLedger.MarkDone(ctx, order.OrderID, ledgerConfig.TTL); err != nil {
+		log.Printf("Failed to record order %s in ledger: %v", order.OrderID, err)
+	}
+	if err := stock.Commit(ctx, order.OrderID); err != nil {
+		log.Printf("Failed to commit stock of order %s: %v", order.OrderID, err)
+	}
+	setPaymentStatus(ctx, &order, orders.StatusCompleted, "payment captured", auth.ID)
+
+	processingTime := time.Since(startTime)
+	log.Printf("Order %s completed in %.2f seconds", order.OrderID, processingTime.Seconds())
+
+	// Log order details for monitoring
+	total, _ := order.AmountDue()
+	log.Printf("Order summary - ID: %s, Items: %d, Total: %s",
+		order.OrderID, len(order.Items), total)
 	return nil
 }
 
-// HandleRequest processes SNS events containing orders
-func HandleRequest(ctx context.Context, snsEvent events.SNSEvent) error {
-	log.Printf("Received %d records", len(snsEvent.Records))
+// HandleSNS processes each SNS record independently. Records that can
+// never succeed go to the failure destination, so they do not make SNS
+// redeliver their siblings. Any other failure, such as a payment timeout
+// or an order another invocation is still charging, fails the invocation
+// once the batch is done, so Lambda runs it again; the ledger skips the
+// orders already charged. A record that cannot be routed fails the
+// invocation as well, so none is acknowledged without being delivered.
+func HandleSNS(ctx context.Context, snsEvent events.SNSEvent) error {
+	log.Printf("Received %d SNS records", len(snsEvent.Records))
 
+	failed := 0
+	var retryable error
 	for _, record := range snsEvent.Records {
-		// Extract the order from the SNS message
-		var order Order
-		if err := json.Unmarshal([]byte(record.SNS.Message), &order); err != nil {
-			log.Printf("Failed to parse order: %v", err)
-			return fmt.Errorf("failed to parse order: %w", err)
+		err := processOrder(ctx, record.SNS.Message, snsAttributes(record.SNS.MessageAttributes))
+		if err == nil {
+			continue
+		}
+
+		failed++
+		log.Printf("Record %s failed: %v", record.SNS.MessageID, err)
+		if !retry.IsTerminal(err) {
+			if retryable == nil {
+				retryable = fmt.Errorf("record %s failed: %w", record.SNS.MessageID, err)
+			}
+			continue
+		}
+		if err := routeFailure(ctx, "sns", record.SNS.MessageID, record.SNS.Message, err); err != nil {
+			return fmt.Errorf("failed to route record %s to failure destination: %w", record.SNS.MessageID, err)
 		}
+	}
+
+	log.Printf("Batch done: %d succeeded, %d failed", len(snsEvent.Records)-failed, failed)
+	return retryable
+}
 
-		log.Printf("Processing order %s from customer %d", order.OrderID, order.CustomerID)
+// HandleSQS processes each SQS record independently and reports the failed
+// ones as batch item failures, so only they return to the queue. Records
+// whose order another invocation is charging are reported too, and return
+// once its ledger claim lapses. The event source mapping must enable
+// ReportBatchItemFailures.
+func HandleSQS(ctx context.Context, sqsEvent events.SQSEvent) (events.SQSEventResponse, error) {
+	log.Printf("Received %d SQS records", len(sqsEvent.Records))
 
-		// Process payment (3-second delay)
-		startTime := time.Now()
-		if err := ProcessPayment(order.OrderID); err != nil {
-			log.Printf("Payment processing failed for order %s: %v", order.OrderID, err)
-			return fmt.Errorf("payment processing failed: %w", err)
+	var response events.SQSEventResponse
+	for _, record := range sqsEvent.Records {
+		// The queue may be subscribed with or without raw delivery, or be
+		// fed directly by a producer
+		message := sqsMessage(record)
+		delivery, err := unwrapper.Unwrap(ctx, message)
+		if err == nil {
+			err = processOrder(ctx, delivery.Body, delivery.Attributes)
+		}
+		if errors.Is(err, ledger.ErrInProgress) {
+			awaitClaim(ctx, message, err)
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: record.MessageId,
+			})
+			continue
 		}
+		if err != nil {
+			log.Printf("Record %s failed: %v", record.MessageId, err)
+			if deadLetter(ctx, message, err) {
+				// Reported as processed, so Lambda deletes it
+				continue
+			}
+			retryLater(ctx, message, err)
+			response.BatchItemFailures = append(response.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: record.MessageId,
+			})
+		}
+	}
+
+	log.Printf("Batch done: %d succeeded, %d failed",
+		len(sqsEvent.Records)-len(response.BatchItemFailures), len(response.BatchItemFailures))
+	return response, nil
+}
 
-		processingTime := time.Since(startTime)
-		log.Printf("Order %s completed in %.2f seconds", order.OrderID, processingTime.Seconds())
+// HandleRequest dispatches on the event source of the records, so the same
+// function can be subscribed to the SNS topic or fed by an SQS queue
+func HandleRequest(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	// SNS records carry EventSource and SQS records eventSource; field
+	// matching is case-insensitive
+	var probe struct {
+		Records []struct {
+			EventSource string `json:"EventSource"`
+		} `json:"Records"`
+	}
+	if err := json.Unmarshal(payload, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse event: %w", err)
+	}
+	if len(probe.Records) == 0 {
+		return nil, nil
+	}
 
-		// Log order details for monitoring
-		itemCount := len(order.Items)
-		var totalValue float64
-		for _, item := range order.Items {
-			totalValue += item.Price * float64(item.Quantity)
+	switch source := probe.Records[0].EventSource; source {
+	case "aws:sns":
+		var snsEvent events.SNSEvent
+		if err := json.Unmarshal(payload, &snsEvent); err != nil {
+			return nil, fmt.Errorf("failed to parse SNS event: %w", err)
 		}
+		return nil, HandleSNS(ctx, snsEvent)
 
-		log.Printf("Order summary - ID: %s, Items: %d, Total: $%.2f",
-			order.OrderID, itemCount, totalValue)
+	case "aws:sqs":
+		var sqsEvent events.SQSEvent
+		if err := json.Unmarshal(payload, &sqsEvent); err != nil {
+			return nil, fmt.Errorf("failed to parse SQS event: %w", err)
+		}
+		return HandleSQS(ctx, sqsEvent)
+
+	default:
+		return nil, fmt.Errorf("unsupported event source %q", source)
 	}
+}
 
-	return nil
+// retryLater hides a failed SQS record for the retry policy's backoff
+// before it returns to the queue. A record that ran out of attempts is made
+// visible at once; its receive count has reached the queue's
+// maxReceiveCount, so the redrive policy moves it on the next receive.
+func retryLater(ctx context.Context, message messaging.Message, cause error) {
+	decision := retryPolicy.Decide(cause, message.ReceiveCount)
+	switch {
+	case decision.Retry:
+		log.Printf("Retrying record %s (attempt %d/%d) in %s", message.ID, message.ReceiveCount,
+			retryPolicy.MaxAttempts, decision.Delay.Round(time.Second))
+	case decision.Terminal:
+		// SQS dead-letters a record only once its receive count exceeds
+		// maxReceiveCount, so it is tried again until then
+		log.Printf("Record %s cannot succeed and was not dead-lettered, releasing it", message.ID)
+	default:
+		log.Printf("Record %s failed %d times, sending it to the dead-letter queue", message.ID, message.ReceiveCount)
+	}
+	setVisibility(ctx, message, decision.Delay)
+}
+
+// setVisibility hides an SQS record for timeout from now
+func setVisibility(ctx context.Context, message messaging.Message, timeout time.Duration) {
+	if sourceQueue == nil {
+		log.Printf("No SQS_QUEUE_ARN configured, record %s returns after the visibility timeout", message.ID)
+		return
+	}
+	if err := sourceQueue.ChangeVisibility(ctx, message.ReceiptHandle, timeout); err != nil {
+		log.Printf("Failed to change visibility of record %s: %v", message.ID, err)
+	}
+}
+
+// awaitClaim hides an SQS record whose order another invocation has
+// claimed until the claim lapses. The claimant may have crashed mid-charge;
+// every receive during its lease would otherwise count towards
+// maxReceiveCount and dead-letter the record while the order is still
+// processing.
+func awaitClaim(ctx context.Context, message messaging.Message, claimErr error) {
+	wait, ok := ledger.LeaseRemaining(claimErr)
+	if !ok {
+		wait = ledgerConfig.Lease
+	}
+	log.Printf("Record %s is being processed by another invocation, retrying it in %s: %v",
+		message.ID, wait.Round(time.Second), claimErr)
+	setVisibility(ctx, message, wait)
+}
+
+// deadLetter sends a record that can never succeed, unchanged, to the
+// dead-letter queue. It reports false for other failures, without a
+// dead-letter queue or if the send failed.
+func deadLetter(ctx context.Context, message messaging.Message, cause error) bool {
+	if deadLetters == nil || !retry.IsTerminal(cause) {
+		return false
+	}
+	if err := deadLetters.Publish(ctx, message.Body, message.Attributes); err != nil {
+		log.Printf("Failed to send record %s to the dead-letter queue: %v", message.ID, err)
+		return false
+	}
+	log.Printf("Record %s cannot succeed, sent it to the dead-letter queue: %v", message.ID, cause)
+	return true
+}
+
+// sqsMessage converts an SQS record to a received message
+func sqsMessage(record events.SQSMessage) messaging.Message {
+	message := messaging.Message{
+		ID:            record.MessageId,
+		Body:          record.Body,
+		Attributes:    make(map[string]string, len(record.MessageAttributes)),
+		ReceiptHandle: record.ReceiptHandle,
+		SenderID:      record.Attributes["SenderId"],
+	}
+	for name, attribute := range record.MessageAttributes {
+		if attribute.StringValue != nil {
+			message.Attributes[name] = *attribute.StringValue
+		}
+	}
+	message.ReceiveCount, _ = strconv.Atoi(record.Attributes["ApproximateReceiveCount"])
+	if ms, err := strconv.ParseInt(record.Attributes["SentTimestamp"], 10, 64); err == nil {
+		message.SentAt = time.UnixMilli(ms)
+	}
+	return message
+}
+
+// snsAttributes returns the string values of SNS record attributes
+func snsAttributes(messageAttributes map[string]interface{}) map[string]string {
+	attributes := make(map[string]string, len(messageAttributes))
+	for name, attribute := range messageAttributes {
+		if a, ok := attribute.(map[string]interface{}); ok {
+			if value, ok := a["Value"].(string); ok {
+				attributes[name] = value
+			}
+		}
+	}
+	return attributes
+}
+
+// errNoFailureDestination is returned for a failed record when
+// FAILURE_DESTINATION is not set
+var errNoFailureDestination = errors.New("no FAILURE_DESTINATION configured")
+
+// routeFailure sends a failed record to the failure destination
+func routeFailure(ctx context.Context, source, messageID, body string, cause error) error {
+	if failureDestination == nil {
+		return errNoFailureDestination
+	}
+
+	data, err := json.Marshal(failedRecord{
+		Source:    source,
+		MessageID: messageID,
+		Error:     cause.Error(),
+		Body:      body,
+		FailedAt:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return failureDestination.Publish(ctx, string(data), map[string]string{
+		"source":     source,
+		"message_id": messageID,
+	})
+}
+
+// newFailureDestination creates the publisher for an SNS topic ARN or an
+// SQS queue URL
+func newFailureDestination(ctx context.Context, destination string) (messaging.EventPublisher, error) {
+	awsCfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("load AWS config: %w", err)
+	}
+
+	switch {
+	case strings.HasPrefix(destination, "arn:aws:sns:"):
+		return messaging.NewSNSPublisher(sns.NewFromConfig(awsCfg), destination), nil
+	case strings.HasPrefix(destination, "https://"):
+		return messaging.NewSQSPublisher(sqs.NewFromConfig(awsCfg), destination), nil
+	default:
+		return nil, fmt.Errorf("FAILURE_DESTINATION must be an SNS topic ARN or SQS queue URL, got %q", destination)
+	}
 }
 
 func main() {
+	var err error
+	paymentGateway, err = payment.NewGatewayFromEnv(payment.DefaultSimulatedConfig())
+	if err != nil {
+		log.Fatal("Invalid payment configuration:", err)
+	}
+
+	// Order store shared with the receiver
+	orderRepo, err = repository.NewFromEnv(context.TODO())
+	if err != nil {
+		log.Fatal("Invalid order store configuration:", err)
+	}
+
+	// Processed-order ledger shared by all concurrent invocations
+	orderLedger, err = ledger.NewStoreFromEnv(context.TODO())
+	if err != nil {
+		log.Fatal("Invalid ledger configuration:", err)
+	}
+	ledgerConfig, err = ledger.ConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid ledger configuration:", err)
+	}
+
+	// Stock reservations made by the receiver
+	stock, err = inventory.NewStoreFromEnv(context.TODO())
+	if err != nil {
+		log.Fatal("Invalid inventory configuration:", err)
+	}
+	stockConfig, err = inventory.ConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid inventory configuration:", err)
+	}
+
+	// Promo redemptions counted by the receiver, given back for orders
+	// that fail for good
+	pricer, err = pricing.NewEngineFromEnv(context.TODO())
+	if err != nil {
+		log.Fatal("Invalid pricing configuration:", err)
+	}
+
+	// SNS envelopes on SQS records are verified; SNS invokes the function
+	// directly otherwise
+	unwrapper, err = messaging.UnwrapperFromEnv()
+	if err != nil {
+		log.Fatal("Invalid SNS delivery configuration:", err)
+	}
+
+	// Failed SQS records are retried with backoff up to
+	// RETRY_MAX_ATTEMPTS
+	retryPolicy, err = retry.PolicyFromEnv()
+	if err != nil {
+		log.Fatal("Invalid retry configuration:", err)
+	}
+	awsCfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		log.Fatal("Unable to load AWS SDK config:", err)
+	}
+	queueClient := sqs.NewFromConfig(awsCfg)
+	if queueARN := os.Getenv("SQS_QUEUE_ARN"); queueARN != "" {
+		queueURL, err := messaging.QueueURL(queueARN)
+		if err != nil {
+			log.Fatal("Invalid SQS_QUEUE_ARN:", err)
+		}
+		sourceQueue = messaging.NewSQSConsumer(queueClient, queueURL, messaging.DefaultQueueConfig())
+	}
+	if dlqURL := os.Getenv("DLQ_URL"); dlqURL != "" {
+		deadLetters = messaging.NewSQSPublisher(queueClient, dlqURL)
+	}
+
+	// Where SNS records that fail are sent
+	if destination := os.Getenv("FAILURE_DESTINATION"); destination != "" {
+		failureDestination, err = newFailureDestination(context.TODO(), destination)
+		if err != nil {
+			log.Fatal("Invalid failure destination:", err)
+		}
+	}
+
 	// Start the Lambda handler
 	lambda.Start(HandleRequest)
 }
@@ -0,0 +1,265 @@
+// Command dlq works on the order processor's dead-letter queue:
+//
+//	dlq list     [-json] [-class poison|retryable]
+//	dlq redrive  (-all | -ids ID,...) [-class ...] [-rate 10] [-dry-run]
+//	dlq archive  [-dir dlq-archive] [-follow] [-interval 1m]
+//
+// list prints the messages with their decoded orders and why they
+// probably failed; redrive sends selected messages back to the main queue
+// at most -rate per second; archive moves poison messages to JSON files,
+// once or, with -follow, every -interval. The queues default to DLQ_URL
+// and SQS_QUEUE_URL. With ORDER_STORE set the order store is consulted
+// for the status of each order, and SNS_VERIFY_SIGNATURES applies as in
+// the processor.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/dlq"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/messaging"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+)
+
+// queueConfig hides received messages long enough for most command runs
+// and polls briefly, so Drain ends soon after the queue is empty. Longer
+// runs, e.g. a slow redrive, see held messages again, where Drain stops.
+var queueConfig = messaging.QueueConfig{
+	VisibilityTimeout: 5 * time.Minute,
+	WaitTime:          time.Second,
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: dlq list|redrive|archive [flags]; dlq COMMAND -h for flags")
+	os.Exit(2)
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	command, args := os.Args[1], os.Args[2:]
+
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	dlqURL := flags.String("dlq", os.Getenv("DLQ_URL"), "dead-letter queue URL")
+	class := flags.String("class", "", "only messages of this class (poison or retryable)")
+
+	var (
+		asJSON   *bool
+		all      *bool
+		ids      *string
+		queueURL *string
+		rate     *float64
+		dryRun   *bool
+		dir      *string
+		follow   *bool
+		interval *time.Duration
+	)
+	switch command {
+	case "list":
+		asJSON = flags.Bool("json", false, "print one JSON entry per line")
+	case "redrive":
+		queueURL = flags.String("queue", os.Getenv("SQS_QUEUE_URL"), "queue to send messages back to")
+		all = flags.Bool("all", false, "redrive every message")
+		ids = flags.String("ids", "", "comma-separated message IDs to redrive")
+		rate = flags.Float64("rate", 10, "messages per second")
+		dryRun = flags.Bool("dry-run", false, "print the selected messages without sending them")
+	case "archive":
+		dir = flags.String("dir", "dlq-archive", "archive directory")
+		follow = flags.Bool("follow", false, "keep archiving every -interval until interrupted")
+		interval = flags.Duration("interval", time.Minute, "time between passes with -follow")
+	default:
+		usage()
+	}
+	flags.Parse(args)
+
+	if *dlqURL == "" {
+		log.Fatal("No dead-letter queue: set DLQ_URL or -dlq")
+	}
+	if *class != "" && dlq.Class(*class) != dlq.ClassPoison && dlq.Class(*class) != dlq.ClassRetryable {
+		log.Fatalf("Invalid -class %q, want poison or retryable", *class)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	awsCfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatal("Unable to load AWS SDK config:", err)
+	}
+	client := sqs.NewFromConfig(awsCfg)
+	queue := messaging.NewSQSConsumer(client, *dlqURL, queueConfig)
+
+	unwrapper, err := messaging.UnwrapperFromEnv()
+	if err != nil {
+		log.Fatal("Invalid SNS delivery configuration:", err)
+	}
+	// An in-memory store would know no orders
+	var repo repository.OrderRepository
+	if kind := os.Getenv("ORDER_STORE"); kind != "" && kind != "memory" {
+		repo, err = repository.NewFromEnv(ctx)
+		if err != nil {
+			log.Fatal("Invalid order store configuration:", err)
+		}
+	}
+	inspector := dlq.NewInspector(unwrapper, repo)
+
+	selected := func(entry dlq.Entry) bool {
+		return *class == "" || entry.Class == dlq.Class(*class)
+	}
+
+	switch command {
+	case "list":
+		err = list(ctx, queue, inspector, selected, *asJSON)
+
+	case "redrive":
+		if *queueURL == "" {
+			log.Fatal("No queue to redrive to: set SQS_QUEUE_URL or -queue")
+		}
+		if !*all && *ids == "" {
+			log.Fatal("Select messages with -all or -ids")
+		}
+		if *rate <= 0 {
+			log.Fatal("-rate must be positive")
+		}
+		wanted := make(map[string]bool)
+		for _, id := range strings.Split(*ids, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				wanted[id] = true
+			}
+		}
+		target := messaging.NewSQSPublisher(client, *queueURL)
+		err = redrive(ctx, queue, target, inspector, func(entry dlq.Entry) bool {
+			return selected(entry) && (*all || wanted[entry.MessageID])
+		}, *rate, *dryRun)
+
+	case "archive":
+		var archive *dlq.FileArchive
+		archive, err = dlq.NewFileArchive(*dir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for {
+			err = archivePoison(ctx, queue, archive, inspector)
+			if err != nil || !*follow {
+				break
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(*interval):
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+
+	if err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatal(err)
+	}
+}
+
+// list prints the selected messages, leaving all of them in the queue
+func list(ctx context.Context, queue messaging.MessageConsumer, inspector *dlq.Inspector, selected func(dlq.Entry) bool, asJSON bool) error {
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if !asJSON {
+		fmt.Fprintln(out, "MESSAGE ID\tRECEIVES\tCLASS\tEVENT\tORDER\tCUSTOMER\tSTATUS\tREASON")
+	}
+	encoder := json.NewEncoder(os.Stdout)
+
+	count := 0
+	err := dlq.Drain(ctx, queue, func(m messaging.Message) (bool, error) {
+		entry := inspector.Inspect(ctx, m)
+		if !selected(entry) {
+			return false, nil
+		}
+		count++
+		if asJSON {
+			return false, encoder.Encode(entry)
+		}
+		fmt.Fprintf(out, "%s\t%d\t%s\t%s\t%s\t%d\t%s\t%s\n", entry.MessageID, entry.ReceiveCount, entry.Class,
+			entry.EventType, entry.OrderID, entry.CustomerID, entry.Status, entry.Reason)
+		return false, nil
+	})
+	if !asJSON {
+		out.Flush()
+		fmt.Fprintf(os.Stderr, "%d messages\n", count)
+	}
+	return err
+}
+
+// redrive sends the selected messages back to target, at most rate per
+// second
+func redrive(ctx context.Context, queue messaging.MessageConsumer, target messaging.EventPublisher, inspector *dlq.Inspector, selected func(dlq.Entry) bool, rate float64, dryRun bool) error {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / rate))
+	defer ticker.Stop()
+
+	redriven, failed := 0, 0
+	err := dlq.Drain(ctx, queue, func(m messaging.Message) (bool, error) {
+		entry := inspector.Inspect(ctx, m)
+		if !selected(entry) {
+			return false, nil
+		}
+		if dryRun {
+			log.Printf("Would redrive message %s (%s, order %s): %s", m.ID, entry.Class, entry.OrderID, entry.Reason)
+			redriven++
+			return false, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-ticker.C:
+		}
+		if err := dlq.Redrive(ctx, queue, target, m); err != nil {
+			log.Printf("Failed to redrive: %v", err)
+			failed++
+			return false, nil
+		}
+		log.Printf("Redrove message %s (order %s)", m.ID, entry.OrderID)
+		redriven++
+		return true, nil
+	})
+	log.Printf("Redrove %d messages, %d failed", redriven, failed)
+	return err
+}
+
+// archivePoison moves the poison messages of the queue to the archive;
+// retryable ones stay for redrive
+func archivePoison(ctx context.Context, queue messaging.MessageConsumer, archive *dlq.FileArchive, inspector *dlq.Inspector) error {
+	archived, kept := 0, 0
+	err := dlq.Drain(ctx, queue, func(m messaging.Message) (bool, error) {
+		entry := inspector.Inspect(ctx, m)
+		if entry.Class != dlq.ClassPoison {
+			kept++
+			return false, nil
+		}
+		path, err := archive.Put(dlq.NewRecord(entry, m))
+		if err != nil {
+			return false, fmt.Errorf("archive message %s: %w", m.ID, err)
+		}
+		if err := queue.Delete(ctx, m.ReceiptHandle); err != nil {
+			// Archived again on the next pass, replacing the file
+			log.Printf("Failed to delete archived message %s: %v", m.ID, err)
+			return false, nil
+		}
+		log.Printf("Archived poison message %s to %s: %s", m.ID, path, entry.Reason)
+		archived++
+		return true, nil
+	})
+	log.Printf("Archived %d poison messages, kept %d retryable", archived, kept)
+	return err
+}
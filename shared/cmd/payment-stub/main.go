@@ -0,0 +1,134 @@
+// Command payment-stub serves the HTTPGateway API backed by the simulated
+// gateway, so services can run with PAYMENT_GATEWAY=http locally. It reads
+// the same PAYMENT_* simulation variables as the services.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/money"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/payment"
+)
+
+// Stub keeps authorizations in memory and delegates to a gateway
+type Stub struct {
+	gateway payment.Gateway
+	mu      sync.Mutex
+	auths   map[string]payment.Authorization
+}
+
+// NewStub creates a stub backed by gateway
+func NewStub(gateway payment.Gateway) *Stub {
+	return &Stub{
+		gateway: gateway,
+		auths:   make(map[string]payment.Authorization),
+	}
+}
+
+// HandleAuthorize handles POST /authorizations
+func (s *Stub) HandleAuthorize(w http.ResponseWriter, r *http.Request) {
+	var req payment.Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid payment request", http.StatusBadRequest)
+		return
+	}
+
+	auth, err := s.gateway.Authorize(r.Context(), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	s.auths[auth.ID] = auth
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(auth)
+}
+
+// HandleAction handles POST /authorizations/{id}/{capture,void,refund}
+func (s *Stub) HandleAction(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/authorizations/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.mu.Lock()
+	auth, ok := s.auths[parts[0]]
+	s.mu.Unlock()
+	if !ok {
+		http.Error(w, "Unknown authorization", http.StatusNotFound)
+		return
+	}
+
+	var err error
+	switch parts[1] {
+	case "capture":
+		err = s.gateway.Capture(r.Context(), auth)
+	case "void":
+		err = s.gateway.Void(r.Context(), auth)
+	case "refund":
+		var body struct {
+			Amount money.Money `json:"amount"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid refund request", http.StatusBadRequest)
+			return
+		}
+		err = s.gateway.Refund(r.Context(), auth, body.Amount, r.Header.Get("Idempotency-Key"))
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, payment.ErrDeclined):
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+	case errors.Is(err, payment.ErrUnavailable):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	case errors.Is(err, payment.ErrTimeout):
+		http.Error(w, err.Error(), http.StatusGatewayTimeout)
+	default:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
+
+func main() {
+	cfg, err := payment.SimulatedConfigFromEnv(payment.DefaultSimulatedConfig())
+	if err != nil {
+		log.Fatal("Invalid payment configuration:", err)
+	}
+
+	stub := NewStub(payment.NewSimulatedGateway(cfg))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /authorizations", stub.HandleAuthorize)
+	mux.HandleFunc("POST /authorizations/", stub.HandleAction)
+
+	port := ":9090"
+	if p := os.Getenv("PORT"); p != "" {
+		port = ":" + p
+	}
+	log.Printf("Starting payment stub on port %s", port)
+
+	if err := http.ListenAndServe(port, mux); err != nil {
+		log.Fatal("Server failed to start:", err)
+	}
+}
@@ -0,0 +1,82 @@
+package dlq
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/messaging"
+)
+
+// Record is an archived message: its entry plus what is needed to replay
+// it
+type Record struct {
+	Entry
+	Body       string            `json:"body"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	ArchivedAt time.Time         `json:"archived_at"`
+}
+
+// NewRecord archives m as described by entry
+func NewRecord(entry Entry, m messaging.Message) Record {
+	return Record{Entry: entry, Body: m.Body, Attributes: m.Attributes, ArchivedAt: time.Now().UTC()}
+}
+
+// FileArchive stores records as JSON files, one per message, under a
+// directory per archive day
+type FileArchive struct {
+	dir string
+}
+
+// NewFileArchive creates (if needed) an archive in dir
+func NewFileArchive(dir string) (*FileArchive, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create archive: %w", err)
+	}
+	return &FileArchive{dir: dir}, nil
+}
+
+// Put writes r to DIR/YYYY-MM-DD/MESSAGE_ID.json and returns the path. The
+// file is written under a temporary name and renamed, so a crash never
+// leaves a partial record; archiving the same message again replaces it.
+func (a *FileArchive) Put(r Record) (string, error) {
+	day := filepath.Join(a.dir, r.ArchivedAt.Format("2006-01-02"))
+	if err := os.MkdirAll(day, 0o755); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	// Message IDs are UUIDs; anything else is kept out of the path
+	name := strings.Map(func(c rune) rune {
+		if c == '-' || c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9') {
+			return c
+		}
+		return '_'
+	}, r.MessageID)
+	path := filepath.Join(day, name+".json")
+
+	tmp, err := os.CreateTemp(day, name+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,195 @@
+// Package dlq inspects, redrives and archives the messages of a
+// dead-letter queue. Messages are classified with the same rules as the
+// processors' retry policy: poison messages can never be processed, the
+// others failed for reasons that may since have passed.
+package dlq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/event"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/messaging"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/retry"
+)
+
+// Class tells whether redriving a message can help
+type Class string
+
+// Classes
+const (
+	// ClassPoison messages are malformed, forged, of an unknown type or of
+	// a type the processors do not consume
+	ClassPoison Class = "poison"
+	// ClassRetryable messages decode to an order and ran out of attempts,
+	// e.g. while the payment gateway was down
+	ClassRetryable Class = "retryable"
+)
+
+// Entry describes a dead-lettered message
+type Entry struct {
+	MessageID    string                 `json:"message_id"`
+	ReceiveCount int                    `json:"receive_count"`
+	SentAt       time.Time              `json:"sent_at"`
+	Delivery     messaging.DeliveryMode `json:"delivery,omitempty"`
+
+	EventType     string `json:"event_type,omitempty"`
+	EventID       string `json:"event_id,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
+
+	OrderID    string `json:"order_id,omitempty"`
+	CustomerID int    `json:"customer_id,omitempty"`
+	Items      int    `json:"items,omitempty"`
+	// Status is the stored status of the order, if the order store was
+	// consulted
+	Status orders.Status `json:"status,omitempty"`
+
+	Class  Class  `json:"class"`
+	Reason string `json:"reason"`
+}
+
+// Inspector decodes dead-lettered messages
+type Inspector struct {
+	unwrapper *messaging.Unwrapper
+	// repo, if set, supplies the stored status and last status change of
+	// the order as the failure reason
+	repo repository.OrderRepository
+}
+
+// NewInspector creates an inspector; repo may be nil
+func NewInspector(unwrapper *messaging.Unwrapper, repo repository.OrderRepository) *Inspector {
+	return &Inspector{unwrapper: unwrapper, repo: repo}
+}
+
+// Inspect decodes m as the processors would and explains why it is
+// probably in the dead-letter queue
+func (i *Inspector) Inspect(ctx context.Context, m messaging.Message) Entry {
+	entry := Entry{MessageID: m.ID, ReceiveCount: m.ReceiveCount, SentAt: m.SentAt}
+
+	delivery, err := i.unwrapper.Unwrap(ctx, m)
+	if err != nil {
+		return classify(entry, err)
+	}
+	entry.Delivery = delivery.Mode
+
+	envelope, err := event.DecodeMessage([]byte(delivery.Body), delivery.Attributes)
+	if envelope != nil {
+		entry.EventType = envelope.Type
+		entry.EventID = envelope.ID
+		entry.CorrelationID = envelope.CorrelationID
+		entry.SchemaVersion = envelope.SchemaVersion
+	}
+	if err != nil {
+		return classify(entry, err)
+	}
+	if envelope.Type != event.OrderCreated {
+		// The processors only charge new orders; a redrive would hand
+		// them an event they cannot use
+		entry.Class = ClassPoison
+		entry.Reason = fmt.Sprintf("%s events are not consumed by the processors", envelope.Type)
+		return entry
+	}
+	order, err := envelope.Order()
+	if err != nil {
+		return classify(entry, err)
+	}
+	entry.OrderID = order.OrderID
+	entry.CustomerID = order.CustomerID
+	entry.Items = len(order.Items)
+
+	entry.Class = ClassRetryable
+	entry.Reason = fmt.Sprintf("received %d times", m.ReceiveCount)
+	if i.repo == nil {
+		return entry
+	}
+	stored, err := i.repo.Get(ctx, order.OrderID)
+	if errors.Is(err, repository.ErrNotFound) {
+		entry.Reason += "; order not stored"
+		return entry
+	}
+	if err != nil {
+		entry.Reason += fmt.Sprintf("; order lookup failed: %v", err)
+		return entry
+	}
+	entry.Status = stored.Status
+	if n := len(stored.History); n > 0 && stored.History[n-1].Reason != "" {
+		entry.Reason += fmt.Sprintf("; order %s: %s", stored.Status, stored.History[n-1].Reason)
+	}
+	return entry
+}
+
+// classify sets the class and reason of a message that did not decode
+func classify(entry Entry, err error) Entry {
+	entry.Reason = err.Error()
+	entry.Class = ClassRetryable
+	if retry.IsTerminal(err) || errors.Is(err, event.ErrUnknownType) || errors.Is(err, event.ErrMalformed) {
+		entry.Class = ClassPoison
+	}
+	return entry
+}
+
+// Drain receives every visible message of queue once and calls fn for
+// it. fn reports whether it deleted the message; the others are made
+// visible again before Drain returns, also when fn fails. A pass that
+// outlasts the queue's visibility timeout receives held messages again;
+// Drain stops at the first message it has already seen.
+func Drain(ctx context.Context, queue messaging.MessageConsumer, fn func(messaging.Message) (bool, error)) error {
+	var held []messaging.Message
+	seen := make(map[string]bool)
+	defer func() {
+		// Use a fresh context so the messages are released even after
+		// ctx is cancelled
+		releaseCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		for _, m := range held {
+			_ = queue.ChangeVisibility(releaseCtx, m.ReceiptHandle, 0)
+		}
+	}()
+
+	for {
+		messages, err := queue.Receive(ctx, 10)
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+		for i, m := range messages {
+			if seen[m.ID] {
+				// Every message has been looked at; release this receipt
+				// and the rest of the batch too
+				held = append(held, messages[i:]...)
+				return nil
+			}
+			seen[m.ID] = true
+
+			deleted, err := fn(m)
+			if !deleted {
+				held = append(held, m)
+			}
+			if err != nil {
+				// The rest of the batch was received but not looked at
+				held = append(held, messages[i+1:]...)
+				return err
+			}
+		}
+	}
+}
+
+// Redrive sends m back to target, the queue it was dead-lettered from,
+// with its body and attributes unchanged, then deletes it from queue
+func Redrive(ctx context.Context, queue messaging.MessageConsumer, target messaging.EventPublisher, m messaging.Message) error {
+	if err := target.Publish(ctx, m.Body, m.Attributes); err != nil {
+		return fmt.Errorf("send message %s: %w", m.ID, err)
+	}
+	if err := queue.Delete(ctx, m.ReceiptHandle); err != nil {
+		// The message is now in both queues; the ledger absorbs the copy
+		return fmt.Errorf("delete redriven message %s: %w", m.ID, err)
+	}
+	return nil
+}
@@ -0,0 +1,268 @@
+package event
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Encoding is how events are written to a topic
+type Encoding string
+
+// Encodings
+const (
+	// EncodingEnvelope writes the Envelope as the message body
+	EncodingEnvelope Encoding = "envelope"
+	// EncodingStructured writes a CloudEvents 1.0 JSON event as the body
+	EncodingStructured Encoding = "structured"
+	// EncodingBinary writes the payload as the body and the CloudEvents
+	// attributes as message attributes
+	EncodingBinary Encoding = "binary"
+)
+
+// CloudEvents constants
+const (
+	SpecVersion = "1.0"
+	// TypePrefix turns an event type into a CloudEvents type, e.g.
+	// "com.github.shivlal1.orders.OrderCreated"
+	TypePrefix = "com.github.shivlal1.orders."
+	// StructuredContentType marks a structured-mode message
+	StructuredContentType = "application/cloudevents+json"
+	// binaryPrefix precedes CloudEvents attributes in binary mode
+	binaryPrefix = "ce_"
+	// contentTypeAttribute carries datacontenttype in binary mode and the
+	// structured content type in structured mode
+	contentTypeAttribute = "content-type"
+)
+
+// cloudEvent is a structured-mode CloudEvent. schemaversion and
+// correlationid are extension attributes.
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            *time.Time      `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	SchemaVersion   json.RawMessage `json:"schemaversion,omitempty"`
+	CorrelationID   string          `json:"correlationid,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      string          `json:"data_base64,omitempty"`
+}
+
+// Codec writes envelopes in one encoding
+type Codec struct {
+	Encoding Encoding
+	// Source is the CloudEvents source, e.g. "/order-receiver"
+	Source string
+}
+
+// Encode returns the message body and attributes of e. subject is the
+// CloudEvents subject, the order ID for order events.
+func (c Codec) Encode(e *Envelope, subject string) (string, map[string]string, error) {
+	switch c.Encoding {
+	case "", EncodingEnvelope:
+		body, err := json.Marshal(e)
+		if err != nil {
+			return "", nil, err
+		}
+		return string(body), e.Attributes(), nil
+
+	case EncodingStructured:
+		occurredAt := e.OccurredAt
+		body, err := json.Marshal(cloudEvent{
+			SpecVersion:     SpecVersion,
+			ID:              e.ID,
+			Source:          c.Source,
+			Type:            TypePrefix + e.Type,
+			Subject:         subject,
+			Time:            &occurredAt,
+			DataContentType: "application/json",
+			SchemaVersion:   json.RawMessage(strconv.Itoa(e.SchemaVersion)),
+			CorrelationID:   e.CorrelationID,
+			Data:            e.Payload,
+		})
+		if err != nil {
+			return "", nil, err
+		}
+		attributes := e.Attributes()
+		attributes[contentTypeAttribute] = StructuredContentType
+		return string(body), attributes, nil
+
+	case EncodingBinary:
+		// SNS allows 10 message attributes; event_type is kept for
+		// subscription filter policies
+		attributes := map[string]string{
+			binaryPrefix + "specversion":   SpecVersion,
+			binaryPrefix + "id":            e.ID,
+			binaryPrefix + "source":        c.Source,
+			binaryPrefix + "type":          TypePrefix + e.Type,
+			binaryPrefix + "time":          e.OccurredAt.UTC().Format(time.RFC3339Nano),
+			binaryPrefix + "schemaversion": strconv.Itoa(e.SchemaVersion),
+			contentTypeAttribute:           "application/json",
+			TypeAttribute:                  e.Type,
+		}
+		if subject != "" {
+			attributes[binaryPrefix+"subject"] = subject
+		}
+		if e.CorrelationID != "" {
+			attributes[binaryPrefix+"correlationid"] = e.CorrelationID
+		}
+		return string(e.Payload), attributes, nil
+
+	default:
+		return "", nil, fmt.Errorf("unknown event encoding %q", c.Encoding)
+	}
+}
+
+// DecodeMessage reads a message in any encoding: CloudEvents binary mode
+// (ce_ attributes), CloudEvents structured mode, an Envelope or a bare
+// order. The payload is upcast as by Decode. In binary mode the type, ID
+// and schema version come from the attributes, which SNS does not sign;
+// use the envelope or structured encoding where that matters.
+func DecodeMessage(body []byte, attributes map[string]string) (*Envelope, error) {
+	if attributes[binaryPrefix+"specversion"] != "" {
+		return decodeBinary(body, attributes)
+	}
+
+	var probe struct {
+		SpecVersion string `json:"specversion"`
+	}
+	if attributes[contentTypeAttribute] == StructuredContentType ||
+		(json.Unmarshal(body, &probe) == nil && probe.SpecVersion != "") {
+		return decodeStructured(body)
+	}
+	return Decode(body)
+}
+
+func decodeBinary(body []byte, attributes map[string]string) (*Envelope, error) {
+	e := &Envelope{
+		ID:            attributes[binaryPrefix+"id"],
+		CorrelationID: attributes[binaryPrefix+"correlationid"],
+		Payload:       body,
+	}
+	if err := setCloudAttributes(e, attributes[binaryPrefix+"specversion"], attributes[binaryPrefix+"type"], attributes[binaryPrefix+"schemaversion"], attributes[binaryPrefix+"time"]); err != nil {
+		return e, err
+	}
+	return e, finish(e)
+}
+
+func decodeStructured(body []byte) (*Envelope, error) {
+	var ce cloudEvent
+	if err := json.Unmarshal(body, &ce); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+
+	e := &Envelope{ID: ce.ID, CorrelationID: ce.CorrelationID, Payload: ce.Data}
+	if ce.DataBase64 != "" {
+		data, err := base64.StdEncoding.DecodeString(ce.DataBase64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: data_base64: %v", ErrMalformed, err)
+		}
+		e.Payload = data
+	}
+	// schemaversion may be a JSON number or a string
+	version := strings.Trim(string(ce.SchemaVersion), `"`)
+	var occurredAt string
+	if ce.Time != nil {
+		occurredAt = ce.Time.Format(time.RFC3339Nano)
+	}
+	if err := setCloudAttributes(e, ce.SpecVersion, ce.Type, version, occurredAt); err != nil {
+		return e, err
+	}
+	return e, finish(e)
+}
+
+// setCloudAttributes fills the envelope fields carried by CloudEvents
+// attributes. Events without a schemaversion are read as version 1.
+func setCloudAttributes(e *Envelope, specVersion, ceType, schemaVersion, occurredAt string) error {
+	if specVersion != SpecVersion {
+		return fmt.Errorf("%w: CloudEvents specversion %q", ErrMalformed, specVersion)
+	}
+	e.Type = strings.TrimPrefix(ceType, TypePrefix)
+
+	e.SchemaVersion = 1
+	if schemaVersion != "" {
+		n, err := strconv.Atoi(schemaVersion)
+		if err != nil {
+			return fmt.Errorf("%w: schemaversion %q", ErrMalformed, schemaVersion)
+		}
+		e.SchemaVersion = n
+	}
+	if occurredAt != "" {
+		t, err := time.Parse(time.RFC3339Nano, occurredAt)
+		if err != nil {
+			return fmt.Errorf("%w: time %q", ErrMalformed, occurredAt)
+		}
+		e.OccurredAt = t
+	}
+	return nil
+}
+
+// Encodings selects the encoding of each topic
+type Encodings struct {
+	Default Encoding
+	// Topics maps topic names or ARNs to their encoding
+	Topics map[string]Encoding
+}
+
+// For returns the encoding of a topic, matched by ARN or by name (the
+// part after the last ':')
+func (e Encodings) For(topic string) Encoding {
+	if enc, ok := e.Topics[topic]; ok {
+		return enc
+	}
+	if enc, ok := e.Topics[topic[strings.LastIndex(topic, ":")+1:]]; ok {
+		return enc
+	}
+	if e.Default == "" {
+		return EncodingEnvelope
+	}
+	return e.Default
+}
+
+// EncodingsFromEnv reads EVENT_ENCODING, the encoding of all topics
+// (default "envelope"), and EVENT_ENCODINGS, per-topic overrides written
+// as "order-events=binary,audit=structured"
+func EncodingsFromEnv() (Encodings, error) {
+	encodings := Encodings{Default: EncodingEnvelope, Topics: make(map[string]Encoding)}
+	if v := os.Getenv("EVENT_ENCODING"); v != "" {
+		enc, err := ParseEncoding(v)
+		if err != nil {
+			return encodings, fmt.Errorf("invalid EVENT_ENCODING: %w", err)
+		}
+		encodings.Default = enc
+	}
+	for _, entry := range strings.Split(os.Getenv("EVENT_ENCODINGS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		// Split at the last '=' so topic ARNs stay whole
+		i := strings.LastIndex(entry, "=")
+		if i <= 0 {
+			return encodings, fmt.Errorf("invalid EVENT_ENCODINGS entry %q, want TOPIC=ENCODING", entry)
+		}
+		enc, err := ParseEncoding(entry[i+1:])
+		if err != nil {
+			return encodings, fmt.Errorf("invalid EVENT_ENCODINGS entry %q: %w", entry, err)
+		}
+		encodings.Topics[strings.TrimSpace(entry[:i])] = enc
+	}
+	return encodings, nil
+}
+
+// ParseEncoding checks an encoding name
+func ParseEncoding(s string) (Encoding, error) {
+	switch enc := Encoding(strings.ToLower(strings.TrimSpace(s))); enc {
+	case EncodingEnvelope, EncodingStructured, EncodingBinary:
+		return enc, nil
+	default:
+		return "", fmt.Errorf("unknown encoding %q, want envelope, structured or binary", s)
+	}
+}
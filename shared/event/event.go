@@ -0,0 +1,160 @@
+// Package event defines the envelope wrapped around every message on the
+// order topic. The envelope names the event type and the schema version of
+// its payload, so consumers can upcast payloads published by older
+// producers instead of misreading them.
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/money"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+)
+
+// Event types published on the order topic
+const (
+	// OrderCreated carries an accepted order to the processor
+	OrderCreated = "OrderCreated"
+	// OrderRefunded reports the refund of a cancelled, captured order
+	OrderRefunded = "OrderRefunded"
+)
+
+// Message attributes set on every event, so subscriptions can filter
+// without parsing the body
+const (
+	TypeAttribute    = "event_type"
+	VersionAttribute = "schema_version"
+)
+
+// Errors returned by Decode
+var (
+	ErrMalformed          = errors.New("malformed event")
+	ErrUnknownType        = errors.New("unknown event type")
+	ErrUnsupportedVersion = errors.New("unsupported schema version")
+)
+
+// Envelope wraps the payload of an event
+type Envelope struct {
+	Type string `json:"type"`
+	// SchemaVersion is the version of the payload schema of Type
+	SchemaVersion int    `json:"schema_version"`
+	ID            string `json:"id"`
+	// OccurredAt is when the producer recorded the event
+	OccurredAt time.Time `json:"occurred_at"`
+	// CorrelationID ties the event to the request that caused it
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// OrderCreatedPayload is the payload of OrderCreated, schema version 2.
+// Version 1 was the bare order, as published before the envelope existed.
+type OrderCreatedPayload struct {
+	Order orders.Order `json:"order"`
+}
+
+// OrderRefundedPayload is the payload of OrderRefunded, schema version 1
+type OrderRefundedPayload struct {
+	OrderID         string      `json:"order_id"`
+	CustomerID      int         `json:"customer_id"`
+	AuthorizationID string      `json:"authorization_id"`
+	Amount          money.Money `json:"amount"`
+	RefundedAt      time.Time   `json:"refunded_at"`
+}
+
+// versions holds the schema version producers write for each type
+var versions = map[string]int{
+	OrderCreated:  2,
+	OrderRefunded: 1,
+}
+
+// New wraps payload in an envelope of the current schema version of
+// eventType
+func New(eventType, correlationID string, payload interface{}) (*Envelope, error) {
+	version, ok := versions[eventType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownType, eventType)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal %s payload: %w", eventType, err)
+	}
+	return &Envelope{
+		Type:          eventType,
+		SchemaVersion: version,
+		ID:            uuid.New().String(),
+		OccurredAt:    time.Now().UTC(),
+		CorrelationID: correlationID,
+		Payload:       data,
+	}, nil
+}
+
+// NewOrderCreated wraps an accepted order
+func NewOrderCreated(order *orders.Order, correlationID string) (*Envelope, error) {
+	return New(OrderCreated, correlationID, OrderCreatedPayload{Order: *order})
+}
+
+// Attributes returns the message attributes of the envelope
+func (e *Envelope) Attributes() map[string]string {
+	return map[string]string{
+		TypeAttribute:    e.Type,
+		VersionAttribute: strconv.Itoa(e.SchemaVersion),
+	}
+}
+
+// Decode parses a message body and upcasts its payload to the current
+// schema version. A body without an envelope is taken to be a bare order,
+// OrderCreated version 1. Unknown types fail with ErrUnknownType, so
+// consumers can skip events they do not handle; versions newer than this
+// build knows fail with ErrUnsupportedVersion.
+func Decode(body []byte) (*Envelope, error) {
+	var e Envelope
+	if err := json.Unmarshal(body, &e); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if e.Type == "" && e.Payload == nil {
+		e = Envelope{Type: OrderCreated, SchemaVersion: 1, Payload: body}
+	}
+	return &e, finish(&e)
+}
+
+// finish checks the type and version of a decoded envelope and upcasts its
+// payload
+func finish(e *Envelope) error {
+	current, ok := versions[e.Type]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownType, e.Type)
+	}
+	if e.SchemaVersion < 1 || e.SchemaVersion > current {
+		return fmt.Errorf("%w: %s version %d, this build reads up to %d", ErrUnsupportedVersion, e.Type, e.SchemaVersion, current)
+	}
+	return upcast(e, current)
+}
+
+// Order returns the order of an OrderCreated event
+func (e *Envelope) Order() (*orders.Order, error) {
+	if e.Type != OrderCreated {
+		return nil, fmt.Errorf("%s event has no order", e.Type)
+	}
+	var payload OrderCreatedPayload
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %s payload: %v", ErrMalformed, e.Type, err)
+	}
+	return &payload.Order, nil
+}
+
+// Refund returns the payload of an OrderRefunded event
+func (e *Envelope) Refund() (*OrderRefundedPayload, error) {
+	if e.Type != OrderRefunded {
+		return nil, fmt.Errorf("%s event has no refund", e.Type)
+	}
+	var payload OrderRefundedPayload
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %s payload: %v", ErrMalformed, e.Type, err)
+	}
+	return &payload, nil
+}
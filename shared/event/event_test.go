@@ -0,0 +1,186 @@
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/money"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+)
+
+func testOrder() orders.Order {
+	return orders.Order{
+		OrderID:    "o-1",
+		CustomerID: 7,
+		Status:     orders.StatusAccepted,
+		Items:      []orders.Item{{ProductID: "p-1", Quantity: 2, Price: money.New(1050, "USD")}},
+	}
+}
+
+// envelopeAt returns an OrderCreated envelope of the given schema version
+func envelopeAt(t *testing.T, version int) *Envelope {
+	t.Helper()
+	order := testOrder()
+	var payload interface{} = OrderCreatedPayload{Order: order}
+	if version == 1 {
+		payload = order
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Envelope{
+		Type:          OrderCreated,
+		SchemaVersion: version,
+		ID:            "e-1",
+		OccurredAt:    time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC),
+		CorrelationID: "c-1",
+		Payload:       data,
+	}
+}
+
+func TestDecodeMessageUpcasts(t *testing.T) {
+	tests := []struct {
+		name     string
+		encoding Encoding
+		version  int
+	}{
+		{name: "envelope v1", encoding: EncodingEnvelope, version: 1},
+		{name: "envelope v2", encoding: EncodingEnvelope, version: 2},
+		{name: "structured v1", encoding: EncodingStructured, version: 1},
+		{name: "structured v2", encoding: EncodingStructured, version: 2},
+		{name: "binary v1", encoding: EncodingBinary, version: 1},
+		{name: "binary v2", encoding: EncodingBinary, version: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codec := Codec{Encoding: tt.encoding, Source: "/order-receiver"}
+			body, attributes, err := codec.Encode(envelopeAt(t, tt.version), "o-1")
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+
+			e, err := DecodeMessage([]byte(body), attributes)
+			if err != nil {
+				t.Fatalf("DecodeMessage: %v", err)
+			}
+			if e.Type != OrderCreated || e.SchemaVersion != 2 || e.ID != "e-1" || e.CorrelationID != "c-1" {
+				t.Errorf("envelope = type %q, version %d, id %q, correlation %q",
+					e.Type, e.SchemaVersion, e.ID, e.CorrelationID)
+			}
+			if !e.OccurredAt.Equal(time.Date(2026, 1, 5, 10, 0, 0, 0, time.UTC)) {
+				t.Errorf("OccurredAt = %s", e.OccurredAt)
+			}
+			order, err := e.Order()
+			if err != nil {
+				t.Fatalf("Order: %v", err)
+			}
+			if order.OrderID != "o-1" || len(order.Items) != 1 || order.Items[0].Price != money.New(1050, "USD") {
+				t.Errorf("Order = %+v", order)
+			}
+		})
+	}
+}
+
+func TestDecodeBareOrder(t *testing.T) {
+	// Published before envelopes existed
+	body, err := json.Marshal(testOrder())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, decode := range map[string]func([]byte) (*Envelope, error){
+		"Decode":        Decode,
+		"DecodeMessage": func(body []byte) (*Envelope, error) { return DecodeMessage(body, nil) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			e, err := decode(body)
+			if err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if e.Type != OrderCreated || e.SchemaVersion != 2 {
+				t.Errorf("envelope = type %q, version %d", e.Type, e.SchemaVersion)
+			}
+			if order, err := e.Order(); err != nil || order.OrderID != "o-1" {
+				t.Errorf("Order = %+v, %v", order, err)
+			}
+		})
+	}
+}
+
+func TestDecodeMessageRejects(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		attributes map[string]string
+		want       error
+	}{
+		{name: "not JSON", body: `{"type":`, want: ErrMalformed},
+		{name: "unknown type", body: `{"type":"OrderShipped","schema_version":1,"payload":{}}`, want: ErrUnknownType},
+		{name: "newer version", body: `{"type":"OrderCreated","schema_version":3,"payload":{}}`, want: ErrUnsupportedVersion},
+		{name: "version zero", body: `{"type":"OrderCreated","schema_version":0,"payload":{}}`, want: ErrUnsupportedVersion},
+		{
+			name: "structured newer version",
+			body: `{"specversion":"1.0","id":"e-1","source":"/x","type":"com.github.shivlal1.orders.OrderCreated","schemaversion":9,"data":{}}`,
+			want: ErrUnsupportedVersion,
+		},
+		{
+			name: "structured bad specversion",
+			body: `{"specversion":"0.3","id":"e-1","source":"/x","type":"com.github.shivlal1.orders.OrderCreated","data":{}}`,
+			want: ErrMalformed,
+		},
+		{
+			name: "structured bad data_base64",
+			body: `{"specversion":"1.0","id":"e-1","source":"/x","type":"com.github.shivlal1.orders.OrderCreated","data_base64":"%%%"}`,
+			want: ErrMalformed,
+		},
+		{
+			name:       "binary bad schemaversion",
+			body:       `{}`,
+			attributes: map[string]string{"ce_specversion": "1.0", "ce_type": TypePrefix + OrderCreated, "ce_schemaversion": "two"},
+			want:       ErrMalformed,
+		},
+		{
+			name:       "binary v1 not JSON",
+			body:       `not json`,
+			attributes: map[string]string{"ce_specversion": "1.0", "ce_type": TypePrefix + OrderCreated, "ce_schemaversion": "1"},
+			want:       ErrMalformed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeMessage([]byte(tt.body), tt.attributes)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("DecodeMessage = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeRefund(t *testing.T) {
+	refund := OrderRefundedPayload{OrderID: "o-1", CustomerID: 7, AuthorizationID: "a-1", Amount: money.New(2100, "USD")}
+	e, err := New(OrderRefunded, "c-1", refund)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, attributes, err := Codec{Encoding: EncodingBinary, Source: "/order-receiver"}.Encode(e, "o-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := DecodeMessage([]byte(body), attributes)
+	if err != nil {
+		t.Fatalf("DecodeMessage: %v", err)
+	}
+	got, err := decoded.Refund()
+	if err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if got.OrderID != "o-1" || got.Amount != refund.Amount {
+		t.Errorf("Refund = %+v", got)
+	}
+	if _, err := decoded.Order(); err == nil {
+		t.Error("Order of an OrderRefunded event did not fail")
+	}
+}
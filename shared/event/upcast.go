@@ -0,0 +1,43 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Upcaster converts a payload from one schema version to the next
+type Upcaster func(payload json.RawMessage) (json.RawMessage, error)
+
+// upcasters holds, per event type, the upcaster from each old version to
+// the one after it. Changing a payload schema means bumping its entry in
+// versions and adding the upcaster from the previous version here.
+var upcasters = map[string]map[int]Upcaster{
+	OrderCreated: {
+		1: wrapOrder,
+	},
+}
+
+// upcast applies upcasters until the payload reaches version current
+func upcast(e *Envelope, current int) error {
+	for e.SchemaVersion < current {
+		up, ok := upcasters[e.Type][e.SchemaVersion]
+		if !ok {
+			return fmt.Errorf("%w: no upcaster for %s version %d", ErrUnsupportedVersion, e.Type, e.SchemaVersion)
+		}
+		payload, err := up(e.Payload)
+		if err != nil {
+			return fmt.Errorf("%w: upcast %s version %d: %v", ErrMalformed, e.Type, e.SchemaVersion, err)
+		}
+		e.Payload = payload
+		e.SchemaVersion++
+	}
+	return nil
+}
+
+// wrapOrder upcasts OrderCreated 1 (the bare order) to 2 ({"order": ...})
+func wrapOrder(payload json.RawMessage) (json.RawMessage, error) {
+	if !json.Valid(payload) {
+		return nil, fmt.Errorf("invalid JSON")
+	}
+	return json.Marshal(map[string]json.RawMessage{"order": payload})
+}
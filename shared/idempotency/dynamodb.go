@@ -0,0 +1,126 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoDBStore keeps records in a table keyed by idempotency_key. The
+// table should have TTL enabled on expires_at (epoch seconds); expiry is
+// also checked on read since TTL deletion is lazy.
+type DynamoDBStore struct {
+	client *dynamodb.Client
+	table  string
+}
+
+// NewDynamoDBStore creates a store on an existing table
+func NewDynamoDBStore(client *dynamodb.Client, table string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, table: table}
+}
+
+// Begin claims key with a conditional put, returning the live record when
+// the put loses
+func (d *DynamoDBStore) Begin(ctx context.Context, key, hash string, lockTimeout time.Duration) (*Record, error) {
+	now := time.Now()
+	_, err := d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item: map[string]types.AttributeValue{
+			"idempotency_key": &types.AttributeValueMemberS{Value: key},
+			"request_hash":    &types.AttributeValueMemberS{Value: hash},
+			"completed":       &types.AttributeValueMemberBOOL{Value: false},
+			"expires_at":      epoch(now.Add(lockTimeout)),
+		},
+		ConditionExpression: aws.String("attribute_not_exists(idempotency_key) OR expires_at < :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": epoch(now),
+		},
+	})
+	if err == nil {
+		return nil, nil
+	}
+	var ccf *types.ConditionalCheckFailedException
+	if !errors.As(err, &ccf) {
+		return nil, err
+	}
+
+	out, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            recordKey(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		// Released between the put and the read; let the client retry
+		return nil, errors.New("idempotency key changed concurrently")
+	}
+	return decodeRecord(key, out.Item), nil
+}
+
+// Complete stores the response for key
+func (d *DynamoDBStore) Complete(ctx context.Context, key string, resp Response, ttl time.Duration) error {
+	_, err := d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(d.table),
+		Key:              recordKey(key),
+		UpdateExpression: aws.String("SET completed = :true, status_code = :status, content_type = :type, body = :body, expires_at = :expires"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":true":    &types.AttributeValueMemberBOOL{Value: true},
+			":status":  &types.AttributeValueMemberN{Value: strconv.Itoa(resp.StatusCode)},
+			":type":    &types.AttributeValueMemberS{Value: resp.ContentType},
+			":body":    &types.AttributeValueMemberB{Value: resp.Body},
+			":expires": epoch(time.Now().Add(ttl)),
+		},
+	})
+	return err
+}
+
+// Release deletes key
+func (d *DynamoDBStore) Release(ctx context.Context, key string) error {
+	_, err := d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.table),
+		Key:       recordKey(key),
+	})
+	return err
+}
+
+func decodeRecord(key string, item map[string]types.AttributeValue) *Record {
+	rec := &Record{Key: key}
+	if v, ok := item["request_hash"].(*types.AttributeValueMemberS); ok {
+		rec.RequestHash = v.Value
+	}
+	if v, ok := item["completed"].(*types.AttributeValueMemberBOOL); ok {
+		rec.Completed = v.Value
+	}
+	if v, ok := item["status_code"].(*types.AttributeValueMemberN); ok {
+		rec.Response.StatusCode, _ = strconv.Atoi(v.Value)
+	}
+	if v, ok := item["content_type"].(*types.AttributeValueMemberS); ok {
+		rec.Response.ContentType = v.Value
+	}
+	if v, ok := item["body"].(*types.AttributeValueMemberB); ok {
+		rec.Response.Body = v.Value
+	}
+	if v, ok := item["expires_at"].(*types.AttributeValueMemberN); ok {
+		secs, _ := strconv.ParseInt(v.Value, 10, 64)
+		rec.ExpiresAt = time.Unix(secs, 0)
+	}
+	return rec
+}
+
+func recordKey(key string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"idempotency_key": &types.AttributeValueMemberS{Value: key},
+	}
+}
+
+// epoch encodes t as a DynamoDB TTL value
+func epoch(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.Unix(), 10)}
+}
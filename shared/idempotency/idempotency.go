@@ -0,0 +1,89 @@
+// Package idempotency lets clients retry POST requests safely with an
+// Idempotency-Key header: the first response is stored and replayed for
+// retries carrying the same key and body.
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+)
+
+// Response is the stored first response for a key
+type Response struct {
+	StatusCode  int    `json:"status_code"`
+	ContentType string `json:"content_type"`
+	Body        []byte `json:"body"`
+}
+
+// Record is the state of an idempotency key
+type Record struct {
+	Key         string
+	RequestHash string
+	// Completed is false while the first request is still running
+	Completed bool
+	Response  Response
+	ExpiresAt time.Time
+}
+
+// Store keeps idempotency records
+type Store interface {
+	// Begin claims key for a request with hash until lockTimeout passes.
+	// It returns nil when the caller now owns the key, or the live
+	// existing record otherwise.
+	Begin(ctx context.Context, key, hash string, lockTimeout time.Duration) (*Record, error)
+	// Complete stores the response for key and keeps it for ttl
+	Complete(ctx context.Context, key string, resp Response, ttl time.Duration) error
+	// Release drops a claimed key so the request can be retried
+	Release(ctx context.Context, key string) error
+}
+
+// ErrKeyTooLong is returned for keys over MaxKeyLength
+var ErrKeyTooLong = errors.New("idempotency key too long")
+
+// MaxKeyLength bounds the Idempotency-Key header
+const MaxKeyLength = 255
+
+// NewStoreFromEnv selects the store with IDEMPOTENCY_STORE, defaulting to
+// the ORDER_STORE backend so keys live next to the orders they created:
+//
+//	memory    in-process (default)
+//	sqlite    SQLITE_PATH (default "orders.db")
+//	dynamodb  table IDEMPOTENCY_TABLE (default "idempotency-keys")
+func NewStoreFromEnv(ctx context.Context) (Store, error) {
+	kind := os.Getenv("IDEMPOTENCY_STORE")
+	if kind == "" {
+		kind = os.Getenv("ORDER_STORE")
+	}
+
+	switch kind {
+	case "", "memory":
+		return NewMemoryStore(), nil
+
+	case "sqlite":
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "orders.db"
+		}
+		return OpenSQLiteStore(ctx, path)
+
+	case "dynamodb":
+		table := os.Getenv("IDEMPOTENCY_TABLE")
+		if table == "" {
+			table = "idempotency-keys"
+		}
+		awsCfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("load AWS config: %w", err)
+		}
+		return NewDynamoDBStore(repository.NewDynamoDBClient(awsCfg), table), nil
+
+	default:
+		return nil, fmt.Errorf("unknown IDEMPOTENCY_STORE %q", kind)
+	}
+}
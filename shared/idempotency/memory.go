@@ -0,0 +1,70 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps records in process memory
+type MemoryStore struct {
+	mu        sync.Mutex
+	records   map[string]*Record
+	lastSweep time.Time
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]*Record)}
+}
+
+// Begin claims key unless a live record exists
+func (m *MemoryStore) Begin(ctx context.Context, key, hash string, lockTimeout time.Duration) (*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if rec, ok := m.records[key]; ok && now.Before(rec.ExpiresAt) {
+		c := *rec
+		return &c, nil
+	}
+
+	// Drop expired records at most once a minute
+	if now.Sub(m.lastSweep) > time.Minute {
+		for k, rec := range m.records {
+			if !now.Before(rec.ExpiresAt) {
+				delete(m.records, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	m.records[key] = &Record{
+		Key:         key,
+		RequestHash: hash,
+		ExpiresAt:   now.Add(lockTimeout),
+	}
+	return nil, nil
+}
+
+// Complete stores the response for key
+func (m *MemoryStore) Complete(ctx context.Context, key string, resp Response, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if rec, ok := m.records[key]; ok {
+		rec.Completed = true
+		rec.Response = resp
+		rec.ExpiresAt = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+// Release drops key
+func (m *MemoryStore) Release(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.records, key)
+	return nil
+}
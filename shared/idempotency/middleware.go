@@ -0,0 +1,141 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Header is the request header carrying the key
+const Header = "Idempotency-Key"
+
+// Config tunes the middleware
+type Config struct {
+	// TTL is how long a completed response is replayed
+	TTL time.Duration
+	// LockTimeout is how long a key stays claimed by a request that never
+	// completes, e.g. because the process died
+	LockTimeout time.Duration
+}
+
+// DefaultConfig keeps responses for a day
+func DefaultConfig() Config {
+	return Config{
+		TTL:         24 * time.Hour,
+		LockTimeout: 2 * time.Minute,
+	}
+}
+
+// Middleware makes next idempotent for requests carrying an
+// Idempotency-Key header. A retry with the same key and body gets the
+// stored response (with Idempotent-Replayed: true), a different body under
+// the same key gets 422 and a retry while the first request is running
+// gets 409. 5xx responses are not stored so the client can retry them.
+// Requests without the header are passed through.
+func Middleware(store Store, cfg Config) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(Header)
+			if key == "" {
+				next(w, r)
+				return
+			}
+			if len(key) > MaxKeyLength {
+				http.Error(w, ErrKeyTooLong.Error(), http.StatusBadRequest)
+				return
+			}
+
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "Failed to read request body", http.StatusBadRequest)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			hash := requestHash(r, body)
+
+			existing, err := store.Begin(r.Context(), key, hash, cfg.LockTimeout)
+			if err != nil {
+				log.Printf("Idempotency store failed for key %s: %v", key, err)
+				http.Error(w, "Idempotency store unavailable", http.StatusServiceUnavailable)
+				return
+			}
+
+			if existing != nil {
+				switch {
+				case existing.RequestHash != hash:
+					http.Error(w, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+				case !existing.Completed:
+					http.Error(w, "A request with this Idempotency-Key is still in progress", http.StatusConflict)
+				default:
+					replay(w, existing.Response)
+				}
+				return
+			}
+
+			rec := &recorder{ResponseWriter: w, status: http.StatusOK}
+			next(rec, r)
+
+			// The client may be gone; still record the outcome
+			ctx := context.WithoutCancel(r.Context())
+			if rec.status >= 500 {
+				if err := store.Release(ctx, key); err != nil {
+					log.Printf("Failed to release idempotency key %s: %v", key, err)
+				}
+				return
+			}
+
+			resp := Response{
+				StatusCode:  rec.status,
+				ContentType: rec.Header().Get("Content-Type"),
+				Body:        rec.body.Bytes(),
+			}
+			if err := store.Complete(ctx, key, resp, cfg.TTL); err != nil {
+				log.Printf("Failed to store response for idempotency key %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// requestHash fingerprints the method, path and body of a request
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func replay(w http.ResponseWriter, resp Response) {
+	if resp.ContentType != "" {
+		w.Header().Set("Content-Type", resp.ContentType)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(resp.StatusCode)
+	w.Write(resp.Body)
+}
+
+// recorder passes the response through while keeping a copy
+type recorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,91 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key          TEXT PRIMARY KEY,
+	request_hash TEXT NOT NULL,
+	completed    INTEGER NOT NULL DEFAULT 0,
+	status_code  INTEGER NOT NULL DEFAULT 0,
+	content_type TEXT NOT NULL DEFAULT '',
+	body         BLOB,
+	expires_at   INTEGER NOT NULL
+);
+`
+
+// SQLiteStore keeps records in an embedded database, usually the same file
+// as the SQLite order repository
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// OpenSQLiteStore opens (creating if needed) the store at path
+func OpenSQLiteStore(ctx context.Context, path string) (*SQLiteStore, error) {
+	db, err := repository.OpenSQLiteDB(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create schema: %w", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// Begin claims key unless a live record exists
+func (s *SQLiteStore) Begin(ctx context.Context, key, hash string, lockTimeout time.Duration) (*Record, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	rec := Record{Key: key}
+	var completed int
+	var expiresAt int64
+	err = tx.QueryRowContext(ctx,
+		`SELECT request_hash, completed, status_code, content_type, body, expires_at
+		 FROM idempotency_keys WHERE key = ?`, key).
+		Scan(&rec.RequestHash, &completed, &rec.Response.StatusCode, &rec.Response.ContentType, &rec.Response.Body, &expiresAt)
+	switch {
+	case err == nil && now.UnixNano() < expiresAt:
+		rec.Completed = completed == 1
+		rec.ExpiresAt = time.Unix(0, expiresAt)
+		return &rec, nil
+	case err != nil && !errors.Is(err, sql.ErrNoRows):
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT OR REPLACE INTO idempotency_keys (key, request_hash, expires_at) VALUES (?, ?, ?)`,
+		key, hash, now.Add(lockTimeout).UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	return nil, tx.Commit()
+}
+
+// Complete stores the response for key
+func (s *SQLiteStore) Complete(ctx context.Context, key string, resp Response, ttl time.Duration) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE idempotency_keys SET completed = 1, status_code = ?, content_type = ?, body = ?, expires_at = ?
+		 WHERE key = ?`,
+		resp.StatusCode, resp.ContentType, resp.Body, time.Now().Add(ttl).UnixNano(), key)
+	return err
+}
+
+// Release drops key
+func (s *SQLiteStore) Release(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE key = ?`, key)
+	return err
+}
@@ -0,0 +1,326 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// maxTransactItems is the DynamoDB limit on actions per transaction; one
+// is taken by the reservation item
+const maxTransactItems = 100
+
+// DynamoDBStore keeps stock levels in one table keyed by product_id
+// (stock, available and reserved counts) and reservations in another keyed
+// by order_id. Conditional transactions keep available from going below
+// zero however many receivers reserve at once.
+type DynamoDBStore struct {
+	client           *dynamodb.Client
+	stockTable       string
+	reservationTable string
+}
+
+// NewDynamoDBStore creates a store on existing tables
+func NewDynamoDBStore(client *dynamodb.Client, stockTable, reservationTable string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, stockTable: stockTable, reservationTable: reservationTable}
+}
+
+// Reserve holds the tracked lines of orderID in one transaction
+func (d *DynamoDBStore) Reserve(ctx context.Context, orderID string, lines []Line, ttl time.Duration) error {
+	expiresAt := time.Now().Add(ttl)
+
+	// The order's own hold would otherwise count against it
+	existing, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:            aws.String(d.reservationTable),
+		Key:                  orderKey(orderID),
+		ProjectionExpression: aws.String("order_id"),
+		ConsistentRead:       aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+	if existing.Item != nil {
+		return d.extend(ctx, orderID, expiresAt)
+	}
+
+	available, err := d.available(ctx, lines)
+	if err != nil {
+		return err
+	}
+
+	var held []Line
+	for _, line := range lines {
+		n, ok := available[line.ProductID]
+		if !ok {
+			continue
+		}
+		if n < line.Quantity {
+			return &SoldOutError{ProductID: line.ProductID, Requested: line.Quantity, Available: n}
+		}
+		held = append(held, line)
+	}
+	if len(held) >= maxTransactItems {
+		return fmt.Errorf("order %s reserves %d products, at most %d are supported", orderID, len(held), maxTransactItems-1)
+	}
+
+	reserved := make(map[string]types.AttributeValue, len(held))
+	for _, line := range held {
+		reserved[line.ProductID] = number(line.Quantity)
+	}
+
+	items := []types.TransactWriteItem{{
+		Put: &types.Put{
+			TableName: aws.String(d.reservationTable),
+			Item: map[string]types.AttributeValue{
+				"order_id":   &types.AttributeValueMemberS{Value: orderID},
+				"lines":      &types.AttributeValueMemberM{Value: reserved},
+				"expires_at": nanos(expiresAt),
+			},
+			ConditionExpression: aws.String("attribute_not_exists(order_id)"),
+		},
+	}}
+	for _, line := range held {
+		items = append(items, types.TransactWriteItem{
+			Update: &types.Update{
+				TableName:           aws.String(d.stockTable),
+				Key:                 productKey(line.ProductID),
+				UpdateExpression:    aws.String("ADD available :minus, reserved :qty"),
+				ConditionExpression: aws.String("available >= :qty"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":qty":   number(line.Quantity),
+					":minus": number(-line.Quantity),
+				},
+			},
+		})
+	}
+
+	_, err = d.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return err
+	}
+	for i, reason := range canceled.CancellationReasons {
+		if aws.ToString(reason.Code) != "ConditionalCheckFailed" {
+			continue
+		}
+		if i == 0 {
+			return d.extend(ctx, orderID, expiresAt)
+		}
+		// Stock went between the read and the transaction
+		line := held[i-1]
+		return &SoldOutError{ProductID: line.ProductID, Requested: line.Quantity}
+	}
+	return err
+}
+
+// Commit removes the reserved units of orderID from stock
+func (d *DynamoDBStore) Commit(ctx context.Context, orderID string) error {
+	return d.finish(ctx, orderID, true, time.Time{})
+}
+
+// Release returns the reserved units of orderID
+func (d *DynamoDBStore) Release(ctx context.Context, orderID string) error {
+	return d.finish(ctx, orderID, false, time.Time{})
+}
+
+// ReleaseExpired scans the reservation table, which only holds live
+// reservations, for expired ones
+func (d *DynamoDBStore) ReleaseExpired(ctx context.Context) (int, error) {
+	now := time.Now()
+	paginator := dynamodb.NewScanPaginator(d.client, &dynamodb.ScanInput{
+		TableName:            aws.String(d.reservationTable),
+		ProjectionExpression: aws.String("order_id"),
+		FilterExpression:     aws.String("expires_at < :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": nanos(now),
+		},
+	})
+
+	released := 0
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return released, err
+		}
+		for _, item := range page.Items {
+			id, ok := item["order_id"].(*types.AttributeValueMemberS)
+			if !ok {
+				continue
+			}
+			if err := d.finish(ctx, id.Value, false, now); err != nil {
+				return released, err
+			}
+			released++
+		}
+	}
+	return released, nil
+}
+
+// Seed creates stock levels for products that have none
+func (d *DynamoDBStore) Seed(ctx context.Context, stock map[string]int) error {
+	for productID, n := range stock {
+		_, err := d.client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(d.stockTable),
+			Item: map[string]types.AttributeValue{
+				"product_id": &types.AttributeValueMemberS{Value: productID},
+				"stock":      number(n),
+				"available":  number(n),
+				"reserved":   number(0),
+			},
+			ConditionExpression: aws.String("attribute_not_exists(product_id)"),
+		})
+		var ccf *types.ConditionalCheckFailedException
+		if err != nil && !errors.As(err, &ccf) {
+			return err
+		}
+	}
+	return nil
+}
+
+// available reads the available count of the tracked products in lines
+func (d *DynamoDBStore) available(ctx context.Context, lines []Line) (map[string]int, error) {
+	available := make(map[string]int, len(lines))
+	// BatchGetItem takes at most 100 keys
+	for start := 0; start < len(lines); start += 100 {
+		end := min(start+100, len(lines))
+		keys := make([]map[string]types.AttributeValue, 0, end-start)
+		for _, line := range lines[start:end] {
+			keys = append(keys, productKey(line.ProductID))
+		}
+
+		request := map[string]types.KeysAndAttributes{
+			d.stockTable: {
+				Keys:                 keys,
+				ProjectionExpression: aws.String("product_id, available"),
+				ConsistentRead:       aws.Bool(true),
+			},
+		}
+		for len(request) > 0 {
+			out, err := d.client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: request})
+			if err != nil {
+				return nil, err
+			}
+			for _, item := range out.Responses[d.stockTable] {
+				id, _ := item["product_id"].(*types.AttributeValueMemberS)
+				n, _ := item["available"].(*types.AttributeValueMemberN)
+				if id == nil || n == nil {
+					continue
+				}
+				count, err := strconv.Atoi(n.Value)
+				if err != nil {
+					return nil, fmt.Errorf("product %s: invalid available count %q", id.Value, n.Value)
+				}
+				available[id.Value] = count
+			}
+			request = out.UnprocessedKeys
+		}
+	}
+	return available, nil
+}
+
+// extend moves the expiry of an existing reservation
+func (d *DynamoDBStore) extend(ctx context.Context, orderID string, expiresAt time.Time) error {
+	_, err := d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(d.reservationTable),
+		Key:                 orderKey(orderID),
+		UpdateExpression:    aws.String("SET expires_at = :expires"),
+		ConditionExpression: aws.String("attribute_exists(order_id)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":expires": nanos(expiresAt),
+		},
+	})
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return fmt.Errorf("reservation of order %s ended while being extended", orderID)
+	}
+	if err != nil {
+		return err
+	}
+	return ErrReserved
+}
+
+// finish deletes the reservation of orderID, taking its units out of stock
+// if sold and returning them to available otherwise. A non-zero
+// expiredBefore skips reservations that expire later.
+func (d *DynamoDBStore) finish(ctx context.Context, orderID string, sold bool, expiredBefore time.Time) error {
+	out, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.reservationTable),
+		Key:            orderKey(orderID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil || out.Item == nil {
+		return err
+	}
+	lines, _ := out.Item["lines"].(*types.AttributeValueMemberM)
+
+	del := &types.Delete{
+		TableName:           aws.String(d.reservationTable),
+		Key:                 orderKey(orderID),
+		ConditionExpression: aws.String("attribute_exists(order_id)"),
+	}
+	if !expiredBefore.IsZero() {
+		del.ConditionExpression = aws.String("attribute_exists(order_id) AND expires_at < :now")
+		del.ExpressionAttributeValues = map[string]types.AttributeValue{":now": nanos(expiredBefore)}
+	}
+	items := []types.TransactWriteItem{{Delete: del}}
+	if lines != nil {
+		for productID, av := range lines.Value {
+			qty, ok := av.(*types.AttributeValueMemberN)
+			if !ok {
+				continue
+			}
+			minus := &types.AttributeValueMemberN{Value: "-" + qty.Value}
+			update := &types.Update{
+				TableName:        aws.String(d.stockTable),
+				Key:              productKey(productID),
+				UpdateExpression: aws.String("ADD reserved :minus, available :qty"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":qty":   qty,
+					":minus": minus,
+				},
+			}
+			if sold {
+				update.UpdateExpression = aws.String("ADD reserved :minus, stock :minus")
+				update.ExpressionAttributeValues = map[string]types.AttributeValue{":minus": minus}
+			}
+			items = append(items, types.TransactWriteItem{Update: update})
+		}
+	}
+
+	_, err = d.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+
+	// Someone else finished or extended the reservation first
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 0 &&
+		aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+		return nil
+	}
+	return err
+}
+
+func productKey(productID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"product_id": &types.AttributeValueMemberS{Value: productID},
+	}
+}
+
+func orderKey(orderID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"order_id": &types.AttributeValueMemberS{Value: orderID},
+	}
+}
+
+func number(n int) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.Itoa(n)}
+}
+
+func nanos(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.UnixNano(), 10)}
+}
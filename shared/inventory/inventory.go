@@ -0,0 +1,217 @@
+// Package inventory holds stock for accepted orders so a flash sale never
+// accepts more units of a product than exist.
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+)
+
+// Errors returned by stores
+var (
+	// ErrSoldOut is matched by *SoldOutError
+	ErrSoldOut = errors.New("sold out")
+	// ErrReserved means the order already holds a reservation; its expiry
+	// has been extended
+	ErrReserved = errors.New("order already has a reservation")
+)
+
+// SoldOutError reports the first product without enough stock
+type SoldOutError struct {
+	ProductID string
+	Requested int
+	Available int
+}
+
+func (e *SoldOutError) Error() string {
+	return fmt.Sprintf("product %s sold out: %d requested, %d available", e.ProductID, e.Requested, e.Available)
+}
+
+// Is makes errors.Is(err, ErrSoldOut) match
+func (e *SoldOutError) Is(target error) bool {
+	return target == ErrSoldOut
+}
+
+// Line is a quantity of one product
+type Line struct {
+	ProductID string
+	Quantity  int
+}
+
+// Lines returns the quantities an order needs, one line per product
+func Lines(order *orders.Order) []Line {
+	index := make(map[string]int, len(order.Items))
+	var lines []Line
+	for _, item := range order.Items {
+		if i, ok := index[item.ProductID]; ok {
+			lines[i].Quantity += item.Quantity
+			continue
+		}
+		index[item.ProductID] = len(lines)
+		lines = append(lines, Line{ProductID: item.ProductID, Quantity: item.Quantity})
+	}
+	return lines
+}
+
+// Store keeps stock levels and per-order reservations. Products without a
+// stock level are not tracked and never sell out.
+type Store interface {
+	// Reserve holds lines for orderID until ttl passes, all or nothing.
+	// It fails with a *SoldOutError if a product lacks stock, or with
+	// ErrReserved (after extending the hold) if orderID already has one.
+	Reserve(ctx context.Context, orderID string, lines []Line, ttl time.Duration) error
+	// Commit turns the reservation of orderID into a sale, removing the
+	// units from stock. Committing an order without a reservation is a
+	// no-op.
+	Commit(ctx context.Context, orderID string) error
+	// Release returns the reserved units of orderID to stock
+	Release(ctx context.Context, orderID string) error
+	// ReleaseExpired releases reservations past their expiry and returns
+	// how many there were
+	ReleaseExpired(ctx context.Context) (int, error)
+	// Seed sets the stock of products that have no stock level yet
+	Seed(ctx context.Context, stock map[string]int) error
+}
+
+// Config holds reservation timings
+type Config struct {
+	// ReservationTTL bounds how long an unprocessed order holds stock; it
+	// should exceed the time an order waits in the queue
+	ReservationTTL time.Duration
+	// SweepInterval is how often expired reservations are released
+	SweepInterval time.Duration
+}
+
+// ConfigFromEnv reads INVENTORY_RESERVATION_TTL (default 15m) and
+// INVENTORY_SWEEP_INTERVAL (default 1m)
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		ReservationTTL: 15 * time.Minute,
+		SweepInterval:  time.Minute,
+	}
+	if v := os.Getenv("INVENTORY_RESERVATION_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid INVENTORY_RESERVATION_TTL %q", v)
+		}
+		cfg.ReservationTTL = d
+	}
+	if v := os.Getenv("INVENTORY_SWEEP_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid INVENTORY_SWEEP_INTERVAL %q", v)
+		}
+		cfg.SweepInterval = d
+	}
+	return cfg, nil
+}
+
+// ParseStock reads stock levels written as "PROD-101=50,PROD-102=10"
+func ParseStock(s string) (map[string]int, error) {
+	stock := make(map[string]int)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		productID, qty, ok := strings.Cut(entry, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(qty))
+		if !ok || err != nil || n < 0 || strings.TrimSpace(productID) == "" {
+			return nil, fmt.Errorf("invalid stock entry %q, want PRODUCT=QUANTITY", entry)
+		}
+		stock[strings.TrimSpace(productID)] = n
+	}
+	return stock, nil
+}
+
+// NewStoreFromEnv selects the store with INVENTORY_STORE, defaulting to the
+// ORDER_STORE backend, and seeds it from INVENTORY_STOCK:
+//
+//	memory    in-process (default)
+//	sqlite    SQLITE_PATH (default "orders.db")
+//	dynamodb  tables INVENTORY_TABLE (default "inventory") and
+//	          RESERVATIONS_TABLE (default "inventory-reservations")
+func NewStoreFromEnv(ctx context.Context) (Store, error) {
+	kind := os.Getenv("INVENTORY_STORE")
+	if kind == "" {
+		kind = os.Getenv("ORDER_STORE")
+	}
+
+	var store Store
+	switch kind {
+	case "", "memory":
+		store = NewMemoryStore()
+
+	case "sqlite":
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "orders.db"
+		}
+		s, err := OpenSQLiteStore(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		store = s
+
+	case "dynamodb":
+		stockTable := os.Getenv("INVENTORY_TABLE")
+		if stockTable == "" {
+			stockTable = "inventory"
+		}
+		reservationTable := os.Getenv("RESERVATIONS_TABLE")
+		if reservationTable == "" {
+			reservationTable = "inventory-reservations"
+		}
+		awsCfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("load AWS config: %w", err)
+		}
+		store = NewDynamoDBStore(repository.NewDynamoDBClient(awsCfg), stockTable, reservationTable)
+
+	default:
+		return nil, fmt.Errorf("unknown INVENTORY_STORE %q", kind)
+	}
+
+	if v := os.Getenv("INVENTORY_STOCK"); v != "" {
+		stock, err := ParseStock(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid INVENTORY_STOCK: %w", err)
+		}
+		if err := store.Seed(ctx, stock); err != nil {
+			return nil, fmt.Errorf("seed inventory: %w", err)
+		}
+	}
+	return store, nil
+}
+
+// Sweep releases expired reservations every interval until ctx is done
+func Sweep(ctx context.Context, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := store.ReleaseExpired(ctx)
+			if err != nil {
+				log.Printf("Failed to release expired reservations: %v", err)
+				continue
+			}
+			if n > 0 {
+				log.Printf("Released %d expired reservations", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package inventory
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type level struct {
+	stock    int
+	reserved int
+}
+
+type reservation struct {
+	lines     []Line
+	expiresAt time.Time
+}
+
+// MemoryStore keeps inventory in process memory
+type MemoryStore struct {
+	mu           sync.Mutex
+	levels       map[string]*level
+	reservations map[string]*reservation
+}
+
+// NewMemoryStore creates a store without stock levels
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		levels:       make(map[string]*level),
+		reservations: make(map[string]*reservation),
+	}
+}
+
+// Reserve holds the tracked lines of orderID
+func (m *MemoryStore) Reserve(ctx context.Context, orderID string, lines []Line, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if r, ok := m.reservations[orderID]; ok {
+		r.expiresAt = time.Now().Add(ttl)
+		return ErrReserved
+	}
+
+	var held []Line
+	for _, line := range lines {
+		l, ok := m.levels[line.ProductID]
+		if !ok {
+			continue
+		}
+		if available := l.stock - l.reserved; available < line.Quantity {
+			return &SoldOutError{ProductID: line.ProductID, Requested: line.Quantity, Available: available}
+		}
+		held = append(held, line)
+	}
+
+	for _, line := range held {
+		m.levels[line.ProductID].reserved += line.Quantity
+	}
+	m.reservations[orderID] = &reservation{lines: held, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// Commit removes the reserved units of orderID from stock
+func (m *MemoryStore) Commit(ctx context.Context, orderID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.reservations[orderID]
+	if !ok {
+		return nil
+	}
+	for _, line := range r.lines {
+		l := m.levels[line.ProductID]
+		l.reserved -= line.Quantity
+		l.stock -= line.Quantity
+	}
+	delete(m.reservations, orderID)
+	return nil
+}
+
+// Release returns the reserved units of orderID
+func (m *MemoryStore) Release(ctx context.Context, orderID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.release(orderID)
+	return nil
+}
+
+// ReleaseExpired releases reservations past their expiry
+func (m *MemoryStore) ReleaseExpired(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	released := 0
+	for orderID, r := range m.reservations {
+		if now.After(r.expiresAt) {
+			m.release(orderID)
+			released++
+		}
+	}
+	return released, nil
+}
+
+// Seed sets the stock of untracked products
+func (m *MemoryStore) Seed(ctx context.Context, stock map[string]int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for productID, n := range stock {
+		if _, ok := m.levels[productID]; !ok {
+			m.levels[productID] = &level{stock: n}
+		}
+	}
+	return nil
+}
+
+// release must be called with mu held
+func (m *MemoryStore) release(orderID string) {
+	r, ok := m.reservations[orderID]
+	if !ok {
+		return
+	}
+	for _, line := range r.lines {
+		m.levels[line.ProductID].reserved -= line.Quantity
+	}
+	delete(m.reservations, orderID)
+}
@@ -0,0 +1,217 @@
+package inventory
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS inventory (
+	product_id TEXT PRIMARY KEY,
+	stock      INTEGER NOT NULL,
+	reserved   INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS inventory_reservations (
+	order_id   TEXT NOT NULL,
+	product_id TEXT NOT NULL,
+	quantity   INTEGER NOT NULL,
+	PRIMARY KEY (order_id, product_id)
+);
+CREATE TABLE IF NOT EXISTS inventory_holds (
+	order_id   TEXT PRIMARY KEY,
+	expires_at INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS inventory_holds_expiry ON inventory_holds (expires_at);
+`
+
+// SQLiteStore keeps inventory in an embedded database, usually the same
+// file as the SQLite order repository. inventory_holds has one row per
+// reserving order, including orders of untracked products only.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// OpenSQLiteStore opens (creating if needed) the inventory at path
+func OpenSQLiteStore(ctx context.Context, path string) (*SQLiteStore, error) {
+	db, err := repository.OpenSQLiteDB(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create schema: %w", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// Reserve holds the tracked lines of orderID in one transaction
+func (s *SQLiteStore) Reserve(ctx context.Context, orderID string, lines []Line, ttl time.Duration) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	expiresAt := time.Now().Add(ttl).UnixNano()
+	result, err := tx.ExecContext(ctx,
+		`UPDATE inventory_holds SET expires_at = ? WHERE order_id = ?`, expiresAt, orderID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return ErrReserved
+	}
+
+	for _, line := range lines {
+		var stock, reserved int
+		err := tx.QueryRowContext(ctx,
+			`SELECT stock, reserved FROM inventory WHERE product_id = ?`, line.ProductID).
+			Scan(&stock, &reserved)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if available := stock - reserved; available < line.Quantity {
+			return &SoldOutError{ProductID: line.ProductID, Requested: line.Quantity, Available: available}
+		}
+
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE inventory SET reserved = reserved + ? WHERE product_id = ?`,
+			line.Quantity, line.ProductID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO inventory_reservations (order_id, product_id, quantity) VALUES (?, ?, ?)`,
+			orderID, line.ProductID, line.Quantity); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO inventory_holds (order_id, expires_at) VALUES (?, ?)`, orderID, expiresAt); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Commit removes the reserved units of orderID from stock
+func (s *SQLiteStore) Commit(ctx context.Context, orderID string) error {
+	return s.finish(ctx, orderID, true, 0)
+}
+
+// Release returns the reserved units of orderID
+func (s *SQLiteStore) Release(ctx context.Context, orderID string) error {
+	return s.finish(ctx, orderID, false, 0)
+}
+
+// ReleaseExpired releases reservations past their expiry
+func (s *SQLiteStore) ReleaseExpired(ctx context.Context) (int, error) {
+	now := time.Now().UnixNano()
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT order_id FROM inventory_holds WHERE expires_at < ?`, now)
+	if err != nil {
+		return 0, err
+	}
+	var expired []string
+	for rows.Next() {
+		var orderID string
+		if err := rows.Scan(&orderID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		expired = append(expired, orderID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// A processor may extend a hold between the query and the release
+	for i, orderID := range expired {
+		if err := s.finish(ctx, orderID, false, now); err != nil {
+			return i, err
+		}
+	}
+	return len(expired), nil
+}
+
+// Seed sets the stock of untracked products
+func (s *SQLiteStore) Seed(ctx context.Context, stock map[string]int) error {
+	for productID, n := range stock {
+		if _, err := s.db.ExecContext(ctx,
+			`INSERT OR IGNORE INTO inventory (product_id, stock) VALUES (?, ?)`, productID, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish drops the reservation of orderID, taking its units out of stock
+// if sold. A non-zero expiredBefore skips holds that expire later.
+func (s *SQLiteStore) finish(ctx context.Context, orderID string, sold bool, expiredBefore int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if expiredBefore != 0 {
+		var expiresAt int64
+		err := tx.QueryRowContext(ctx,
+			`SELECT expires_at FROM inventory_holds WHERE order_id = ?`, orderID).Scan(&expiresAt)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && expiresAt >= expiredBefore) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT product_id, quantity FROM inventory_reservations WHERE order_id = ?`, orderID)
+	if err != nil {
+		return err
+	}
+	var lines []Line
+	for rows.Next() {
+		var line Line
+		if err := rows.Scan(&line.ProductID, &line.Quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		lines = append(lines, line)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	update := `UPDATE inventory SET reserved = reserved - ? WHERE product_id = ?`
+	if sold {
+		update = `UPDATE inventory SET reserved = reserved - ?1, stock = stock - ?1 WHERE product_id = ?2`
+	}
+	for _, line := range lines {
+		if _, err := tx.ExecContext(ctx, update, line.Quantity, line.ProductID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM inventory_reservations WHERE order_id = ?`, orderID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM inventory_holds WHERE order_id = ?`, orderID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
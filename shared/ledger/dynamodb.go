@@ -0,0 +1,107 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoDBStore keeps the ledger in a table keyed by order_id. The table
+// should have TTL enabled on expires_at (epoch seconds); expiry is also
+// checked in conditions since TTL deletion is lazy.
+type DynamoDBStore struct {
+	client *dynamodb.Client
+	table  string
+}
+
+// NewDynamoDBStore creates a ledger on an existing table
+func NewDynamoDBStore(client *dynamodb.Client, table string) *DynamoDBStore {
+	return &DynamoDBStore{client: client, table: table}
+}
+
+// Claim reserves orderID with a conditional put
+func (d *DynamoDBStore) Claim(ctx context.Context, orderID string, lease time.Duration) (string, error) {
+	now := time.Now()
+	token := newToken()
+	_, err := d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item: map[string]types.AttributeValue{
+			"order_id":   &types.AttributeValueMemberS{Value: orderID},
+			"done":       &types.AttributeValueMemberBOOL{Value: false},
+			"owner":      &types.AttributeValueMemberS{Value: token},
+			"expires_at": epoch(now.Add(lease)),
+		},
+		ConditionExpression: aws.String("attribute_not_exists(order_id) OR expires_at < :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": epoch(now),
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		if done, ok := ccf.Item["done"].(*types.AttributeValueMemberBOOL); ok && done.Value {
+			return "", ErrAlreadyProcessed
+		}
+		if n, ok := ccf.Item["expires_at"].(*types.AttributeValueMemberN); ok {
+			if sec, err := strconv.ParseInt(n.Value, 10, 64); err == nil {
+				// The condition only lets a claim go once expires_at is
+				// in the past, a second after the stored time
+				return "", &InProgressError{Until: time.Unix(sec+1, 0)}
+			}
+		}
+		return "", ErrInProgress
+	}
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// MarkDone records orderID as charged
+func (d *DynamoDBStore) MarkDone(ctx context.Context, orderID string, ttl time.Duration) error {
+	_, err := d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item: map[string]types.AttributeValue{
+			"order_id":   &types.AttributeValueMemberS{Value: orderID},
+			"done":       &types.AttributeValueMemberBOOL{Value: true},
+			"expires_at": epoch(time.Now().Add(ttl)),
+		},
+	})
+	return err
+}
+
+// Release drops the unfinished claim with token
+func (d *DynamoDBStore) Release(ctx context.Context, orderID, token string) error {
+	_, err := d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.table),
+		Key: map[string]types.AttributeValue{
+			"order_id": &types.AttributeValueMemberS{Value: orderID},
+		},
+		ConditionExpression: aws.String("done = :false AND #owner = :owner"),
+		// OWNER is a DynamoDB reserved word
+		ExpressionAttributeNames: map[string]string{
+			"#owner": "owner",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":false": &types.AttributeValueMemberBOOL{Value: false},
+			":owner": &types.AttributeValueMemberS{Value: token},
+		},
+	})
+
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return nil
+	}
+	return err
+}
+
+// epoch encodes t as a DynamoDB TTL value
+func epoch(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.Unix(), 10)}
+}
@@ -0,0 +1,138 @@
+// Package ledger records which orders have been charged so redelivered or
+// duplicated messages are acknowledged without charging again.
+package ledger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/google/uuid"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+)
+
+// Errors returned by Claim
+var (
+	// ErrAlreadyProcessed means the order was charged before
+	ErrAlreadyProcessed = errors.New("order already processed")
+	// ErrInProgress means another consumer is charging the order
+	ErrInProgress = errors.New("order is being processed")
+)
+
+// InProgressError is the ErrInProgress returned by Claim when the store
+// knows when the other claim lapses
+type InProgressError struct {
+	Until time.Time
+}
+
+func (e *InProgressError) Error() string        { return ErrInProgress.Error() }
+func (e *InProgressError) Is(target error) bool { return target == ErrInProgress }
+
+// LeaseRemaining returns how long the claim that made Claim fail with err
+// still holds. It reports false if err does not say.
+func LeaseRemaining(err error) (time.Duration, bool) {
+	var e *InProgressError
+	if !errors.As(err, &e) {
+		return 0, false
+	}
+	return max(time.Until(e.Until), 0), true
+}
+
+// Store keeps the processed-order ledger
+type Store interface {
+	// Claim reserves orderID for charging until lease passes and returns
+	// the token of the claim. It fails with ErrAlreadyProcessed or
+	// ErrInProgress if the order is taken.
+	Claim(ctx context.Context, orderID string, lease time.Duration) (string, error)
+	// MarkDone records orderID as charged and remembers it for ttl
+	MarkDone(ctx context.Context, orderID string, ttl time.Duration) error
+	// Release drops the claim with token after a failed attempt so a
+	// redelivery can retry the payment. A claim that lapsed and was taken
+	// by another consumer is left alone, so a late attempt cannot let a
+	// third consumer charge the order while the second one does.
+	Release(ctx context.Context, orderID, token string) error
+}
+
+// newToken returns a token that tells a claim from later claims of the
+// same order
+func newToken() string {
+	return uuid.New().String()
+}
+
+// Config holds ledger timings
+type Config struct {
+	// Lease bounds how long a crashed consumer blocks redeliveries. It must
+	// outlast the consumer's hold on the message, or a redelivery could
+	// claim the order while the first attempt still charges it.
+	Lease time.Duration
+	// TTL is how long charged orders are remembered; it should exceed the
+	// queue's message retention
+	TTL time.Duration
+}
+
+// ConfigFromEnv reads LEDGER_LEASE (default 15m, above the processor's
+// default 10m heartbeat lifetime and the longest Lambda timeout) and
+// LEDGER_TTL (default 96h, the SQS default retention)
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Lease: 15 * time.Minute,
+		TTL:   96 * time.Hour,
+	}
+	if v := os.Getenv("LEDGER_LEASE"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid LEDGER_LEASE: %w", err)
+		}
+		cfg.Lease = d
+	}
+	if v := os.Getenv("LEDGER_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid LEDGER_TTL: %w", err)
+		}
+		cfg.TTL = d
+	}
+	return cfg, nil
+}
+
+// NewStoreFromEnv selects the store with LEDGER_STORE, defaulting to the
+// ORDER_STORE backend:
+//
+//	memory    in-process (default)
+//	sqlite    SQLITE_PATH (default "orders.db")
+//	dynamodb  table LEDGER_TABLE (default "processed-orders")
+func NewStoreFromEnv(ctx context.Context) (Store, error) {
+	kind := os.Getenv("LEDGER_STORE")
+	if kind == "" {
+		kind = os.Getenv("ORDER_STORE")
+	}
+
+	switch kind {
+	case "", "memory":
+		return NewMemoryStore(), nil
+
+	case "sqlite":
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "orders.db"
+		}
+		return OpenSQLiteStore(ctx, path)
+
+	case "dynamodb":
+		table := os.Getenv("LEDGER_TABLE")
+		if table == "" {
+			table = "processed-orders"
+		}
+		awsCfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("load AWS config: %w", err)
+		}
+		return NewDynamoDBStore(repository.NewDynamoDBClient(awsCfg), table), nil
+
+	default:
+		return nil, fmt.Errorf("unknown LEDGER_STORE %q", kind)
+	}
+}
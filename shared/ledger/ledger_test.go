@@ -0,0 +1,188 @@
+package ledger
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// stores returns an empty ledger of every backend that runs without AWS
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+	sqlite, err := OpenSQLiteStore(context.Background(), filepath.Join(t.TempDir(), "ledger.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { sqlite.db.Close() })
+	return map[string]Store{
+		"memory": NewMemoryStore(),
+		"sqlite": sqlite,
+	}
+}
+
+func TestClaim(t *testing.T) {
+	const lease = 50 * time.Millisecond
+
+	// Each case prepares the ledger for order o-1, then claims it again
+	tests := []struct {
+		name    string
+		prepare func(ctx context.Context, t *testing.T, s Store)
+		want    error
+	}{
+		{
+			name:    "unclaimed",
+			prepare: func(ctx context.Context, t *testing.T, s Store) {},
+		},
+		{
+			name: "claimed",
+			prepare: func(ctx context.Context, t *testing.T, s Store) {
+				mustClaim(ctx, t, s, time.Minute)
+			},
+			want: ErrInProgress,
+		},
+		{
+			name: "lease lapsed",
+			prepare: func(ctx context.Context, t *testing.T, s Store) {
+				mustClaim(ctx, t, s, lease)
+				time.Sleep(2 * lease)
+			},
+		},
+		{
+			name: "released",
+			prepare: func(ctx context.Context, t *testing.T, s Store) {
+				token := mustClaim(ctx, t, s, time.Minute)
+				if err := s.Release(ctx, "o-1", token); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "released with the token of a lapsed claim",
+			prepare: func(ctx context.Context, t *testing.T, s Store) {
+				stale := mustClaim(ctx, t, s, lease)
+				time.Sleep(2 * lease)
+				mustClaim(ctx, t, s, time.Minute)
+				if err := s.Release(ctx, "o-1", stale); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: ErrInProgress,
+		},
+		{
+			name: "done",
+			prepare: func(ctx context.Context, t *testing.T, s Store) {
+				if err := s.MarkDone(ctx, "o-1", time.Hour); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: ErrAlreadyProcessed,
+		},
+		{
+			name: "released after done",
+			prepare: func(ctx context.Context, t *testing.T, s Store) {
+				token := mustClaim(ctx, t, s, time.Minute)
+				if err := s.MarkDone(ctx, "o-1", time.Hour); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.Release(ctx, "o-1", token); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: ErrAlreadyProcessed,
+		},
+		{
+			name: "done expired",
+			prepare: func(ctx context.Context, t *testing.T, s Store) {
+				if err := s.MarkDone(ctx, "o-1", lease); err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(2 * lease)
+			},
+		},
+	}
+	for _, name := range []string{"memory", "sqlite"} {
+		t.Run(name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					ctx := context.Background()
+					s := stores(t)[name]
+					tt.prepare(ctx, t, s)
+
+					token, err := s.Claim(ctx, "o-1", time.Minute)
+					if !errors.Is(err, tt.want) {
+						t.Fatalf("Claim = %v, want %v", err, tt.want)
+					}
+					if err == nil && token == "" {
+						t.Error("Claim returned an empty token")
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestClaimTokensDiffer(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			first := mustClaim(ctx, t, s, time.Minute)
+			if err := s.Release(ctx, "o-1", first); err != nil {
+				t.Fatal(err)
+			}
+			if second := mustClaim(ctx, t, s, time.Minute); second == first {
+				t.Errorf("both claims have token %q", first)
+			}
+		})
+	}
+}
+
+func TestLeaseRemaining(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "lease running", err: &InProgressError{Until: time.Now().Add(time.Minute)}, want: time.Minute, wantOK: true},
+		{name: "lease passed", err: &InProgressError{Until: time.Now().Add(-time.Minute)}, want: 0, wantOK: true},
+		{name: "no deadline", err: ErrInProgress},
+		{name: "other error", err: ErrAlreadyProcessed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := LeaseRemaining(tt.err)
+			if ok != tt.wantOK {
+				t.Fatalf("LeaseRemaining ok = %v, want %v", ok, tt.wantOK)
+			}
+			if got > tt.want || got < tt.want-time.Second {
+				t.Errorf("LeaseRemaining = %s, want about %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimReportsLease(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			mustClaim(ctx, t, s, time.Minute)
+
+			_, err := s.Claim(ctx, "o-1", time.Minute)
+			remaining, ok := LeaseRemaining(err)
+			if !ok || remaining <= 0 || remaining > time.Minute {
+				t.Errorf("LeaseRemaining(%v) = %s, %v", err, remaining, ok)
+			}
+		})
+	}
+}
+
+func mustClaim(ctx context.Context, t *testing.T, s Store, lease time.Duration) string {
+	t.Helper()
+	token, err := s.Claim(ctx, "o-1", lease)
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	return token
+}
@@ -0,0 +1,73 @@
+package ledger
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	done      bool
+	token     string
+	expiresAt time.Time
+}
+
+// MemoryStore keeps the ledger in process memory
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+// NewMemoryStore creates an empty ledger
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]memoryEntry)}
+}
+
+// Claim reserves orderID unless a live entry exists
+func (m *MemoryStore) Claim(ctx context.Context, orderID string, lease time.Duration) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if e, ok := m.entries[orderID]; ok && now.Before(e.expiresAt) {
+		if e.done {
+			return "", ErrAlreadyProcessed
+		}
+		return "", &InProgressError{Until: e.expiresAt}
+	}
+
+	// Drop expired entries at most once a minute
+	if now.Sub(m.lastSweep) > time.Minute {
+		for id, e := range m.entries {
+			if !now.Before(e.expiresAt) {
+				delete(m.entries, id)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	token := newToken()
+	m.entries[orderID] = memoryEntry{token: token, expiresAt: now.Add(lease)}
+	return token, nil
+}
+
+// MarkDone records orderID as charged
+func (m *MemoryStore) MarkDone(ctx context.Context, orderID string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[orderID] = memoryEntry{done: true, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+// Release drops the unfinished claim with token
+func (m *MemoryStore) Release(ctx context.Context, orderID, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[orderID]; ok && !e.done && e.token == token {
+		delete(m.entries, orderID)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package ledger
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS processed_orders (
+	order_id   TEXT PRIMARY KEY,
+	done       INTEGER NOT NULL DEFAULT 0,
+	owner      TEXT NOT NULL DEFAULT '',
+	expires_at INTEGER NOT NULL
+);
+`
+
+// SQLiteStore keeps the ledger in an embedded database, usually the same
+// file as the SQLite order repository
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// OpenSQLiteStore opens (creating if needed) the ledger at path
+func OpenSQLiteStore(ctx context.Context, path string) (*SQLiteStore, error) {
+	db, err := repository.OpenSQLiteDB(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create schema: %w", err)
+	}
+	// Ledgers created before claims had owners lack the column
+	if _, err := db.ExecContext(ctx, `SELECT owner FROM processed_orders LIMIT 0`); err != nil {
+		if _, err := db.ExecContext(ctx, `ALTER TABLE processed_orders ADD COLUMN owner TEXT NOT NULL DEFAULT ''`); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("add owner column: %w", err)
+		}
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// Claim reserves orderID unless a live entry exists
+func (s *SQLiteStore) Claim(ctx context.Context, orderID string, lease time.Duration) (string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var done int
+	var expiresAt int64
+	err = tx.QueryRowContext(ctx,
+		`SELECT done, expires_at FROM processed_orders WHERE order_id = ?`, orderID).
+		Scan(&done, &expiresAt)
+	switch {
+	case err == nil && now.UnixNano() < expiresAt:
+		if done == 1 {
+			return "", ErrAlreadyProcessed
+		}
+		return "", &InProgressError{Until: time.Unix(0, expiresAt)}
+	case err != nil && !errors.Is(err, sql.ErrNoRows):
+		return "", err
+	}
+
+	token := newToken()
+	_, err = tx.ExecContext(ctx,
+		`INSERT OR REPLACE INTO processed_orders (order_id, done, owner, expires_at) VALUES (?, 0, ?, ?)`,
+		orderID, token, now.Add(lease).UnixNano())
+	if err != nil {
+		return "", err
+	}
+	return token, tx.Commit()
+}
+
+// MarkDone records orderID as charged
+func (s *SQLiteStore) MarkDone(ctx context.Context, orderID string, ttl time.Duration) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT OR REPLACE INTO processed_orders (order_id, done, expires_at) VALUES (?, 1, ?)`,
+		orderID, time.Now().Add(ttl).UnixNano())
+	return err
+}
+
+// Release drops the unfinished claim with token
+func (s *SQLiteStore) Release(ctx context.Context, orderID, token string) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM processed_orders WHERE order_id = ? AND done = 0 AND owner = ?`, orderID, token)
+	return err
+}
@@ -0,0 +1,245 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxBatch is the most messages SQS deletes in one call
+const maxBatch = 10
+
+// BatchDeleter acknowledges several received messages in one call
+type BatchDeleter interface {
+	// DeleteBatch deletes up to 10 messages. It returns one error per
+	// receipt handle, nil for those deleted, or an error if the call
+	// failed as a whole.
+	DeleteBatch(ctx context.Context, receiptHandles []string) ([]error, error)
+}
+
+// BatchEntryError is the failure of one entry of a batch call
+type BatchEntryError struct {
+	Code    string
+	Message string
+	// SenderFault means the entry itself is wrong and retrying cannot help
+	SenderFault bool
+}
+
+func (e *BatchEntryError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// AckConfig holds acknowledgement batching settings
+type AckConfig struct {
+	// MaxDelay is how long an acknowledgement waits for a full batch; it
+	// must be well below the visibility timeout
+	MaxDelay time.Duration
+	// MaxAttempts bounds the delete attempts of one message
+	MaxAttempts int
+}
+
+// AckConfigFromEnv reads ACK_MAX_DELAY (default 1s) and ACK_MAX_ATTEMPTS
+// (default 3)
+func AckConfigFromEnv() (AckConfig, error) {
+	cfg := AckConfig{MaxDelay: time.Second, MaxAttempts: 3}
+	if v := os.Getenv("ACK_MAX_DELAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid ACK_MAX_DELAY %q", v)
+		}
+		cfg.MaxDelay = d
+	}
+	if v := os.Getenv("ACK_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("invalid ACK_MAX_ATTEMPTS %q", v)
+		}
+		cfg.MaxAttempts = n
+	}
+	return cfg, nil
+}
+
+// AckStats counts acknowledgements
+type AckStats struct {
+	// Acknowledged messages were deleted
+	Acknowledged int64
+	// Pending messages wait for their batch
+	Pending int64
+	// Failed messages were given up after MaxAttempts or a permanent
+	// error; they are delivered again
+	Failed int64
+}
+
+type pendingAck struct {
+	receiptHandle string
+	attempts      int
+	queuedAt      time.Time
+}
+
+// Acker groups message deletes into batches of up to 10. A batch is sent
+// once it is full or its oldest message has waited MaxDelay. Entries that
+// fail are retried with the next batch up to MaxAttempts.
+type Acker struct {
+	deleter BatchDeleter
+	cfg     AckConfig
+
+	mu      sync.Mutex
+	pending []pendingAck
+	// wake is signalled when a message is queued
+	wake chan struct{}
+
+	acknowledged int64
+	failed       int64
+}
+
+// NewAcker creates an acker deleting through deleter
+func NewAcker(deleter BatchDeleter, cfg AckConfig) *Acker {
+	return &Acker{deleter: deleter, cfg: cfg, wake: make(chan struct{}, 1)}
+}
+
+// Ack queues the message received with receiptHandle for deletion
+func (a *Acker) Ack(receiptHandle string) {
+	a.mu.Lock()
+	a.pending = append(a.pending, pendingAck{receiptHandle: receiptHandle, queuedAt: time.Now()})
+	a.mu.Unlock()
+
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends batches until ctx is done. Messages still pending then are
+// left for Flush.
+func (a *Acker) Run(ctx context.Context) {
+	timer := time.NewTimer(a.cfg.MaxDelay)
+	defer timer.Stop()
+
+	for {
+		a.mu.Lock()
+		n := len(a.pending)
+		var due time.Time
+		if n > 0 {
+			due = a.pending[0].queuedAt.Add(a.cfg.MaxDelay)
+		}
+		a.mu.Unlock()
+
+		if n >= maxBatch || (n > 0 && !time.Now().Before(due)) {
+			a.send(ctx)
+			continue
+		}
+
+		wait := a.cfg.MaxDelay
+		if n > 0 {
+			wait = time.Until(due)
+		}
+		timer.Reset(wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.wake:
+		case <-timer.C:
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+// Flush sends every pending message without waiting for full batches,
+// retrying failures at once. It returns ctx's error if ctx ends first.
+func (a *Acker) Flush(ctx context.Context) error {
+	for {
+		a.mu.Lock()
+		n := len(a.pending)
+		a.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		a.send(ctx)
+	}
+}
+
+// Stats returns the acknowledgement counts
+func (a *Acker) Stats() AckStats {
+	a.mu.Lock()
+	pending := len(a.pending)
+	a.mu.Unlock()
+
+	return AckStats{
+		Acknowledged: atomic.LoadInt64(&a.acknowledged),
+		Pending:      int64(pending),
+		Failed:       atomic.LoadInt64(&a.failed),
+	}
+}
+
+// send deletes the oldest pending messages, up to one batch, and queues
+// the retryable failures again
+func (a *Acker) send(ctx context.Context) {
+	a.mu.Lock()
+	n := min(len(a.pending), maxBatch)
+	batch := append([]pendingAck(nil), a.pending[:n]...)
+	a.pending = a.pending[n:]
+	a.mu.Unlock()
+
+	handles := make([]string, len(batch))
+	for i, entry := range batch {
+		handles[i] = entry.receiptHandle
+	}
+	errs, err := a.deleter.DeleteBatch(ctx, handles)
+	if err != nil {
+		log.Printf("Failed to delete %d messages: %v", len(batch), err)
+		errs = make([]error, len(batch))
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+
+	var retry []pendingAck
+	for i, entry := range batch {
+		if errs[i] == nil {
+			atomic.AddInt64(&a.acknowledged, 1)
+			continue
+		}
+		entry.attempts++
+		if entry.attempts >= a.cfg.MaxAttempts || !retryableAck(errs[i]) {
+			// The message becomes visible again and the ledger absorbs
+			// the redelivery
+			log.Printf("Giving up deleting message after %d attempts: %v", entry.attempts, errs[i])
+			atomic.AddInt64(&a.failed, 1)
+			continue
+		}
+		entry.queuedAt = time.Now()
+		retry = append(retry, entry)
+	}
+
+	if len(retry) > 0 {
+		a.mu.Lock()
+		a.pending = append(a.pending, retry...)
+		a.mu.Unlock()
+	}
+}
+
+// retryableAck reports whether a failed delete may succeed later
+func retryableAck(err error) bool {
+	if errors.Is(err, ErrInvalidReceipt) {
+		return false
+	}
+	var entryErr *BatchEntryError
+	if errors.As(err, &entryErr) {
+		return !entryErr.SenderFault
+	}
+	return true
+}
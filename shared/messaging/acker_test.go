@@ -0,0 +1,199 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+// scriptedDeleter fails each receipt handle with the errors scripted for
+// it, one per call, and succeeds once they run out
+type scriptedDeleter struct {
+	mu sync.Mutex
+	// entryErrs holds the errors of each handle, in call order
+	entryErrs map[string][]error
+	// callErrs fail whole calls, in call order
+	callErrs []error
+	batches  [][]string
+}
+
+func (d *scriptedDeleter) DeleteBatch(ctx context.Context, receiptHandles []string) ([]error, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.batches = append(d.batches, append([]string(nil), receiptHandles...))
+	if len(d.callErrs) > 0 {
+		err := d.callErrs[0]
+		d.callErrs = d.callErrs[1:]
+		if err != nil {
+			return nil, err
+		}
+	}
+	errs := make([]error, len(receiptHandles))
+	for i, handle := range receiptHandles {
+		if scripted := d.entryErrs[handle]; len(scripted) > 0 {
+			errs[i] = scripted[0]
+			d.entryErrs[handle] = scripted[1:]
+		}
+	}
+	return errs, nil
+}
+
+func TestAckerPartialFailure(t *testing.T) {
+	transient := &BatchEntryError{Code: "InternalError", Message: "try again"}
+	senderFault := &BatchEntryError{Code: "InvalidParameterValue", Message: "bad entry", SenderFault: true}
+
+	tests := []struct {
+		name      string
+		handles   []string
+		entryErrs map[string][]error
+		callErrs  []error
+		batches   [][]string
+		stats     AckStats
+	}{
+		{
+			name:    "all deleted",
+			handles: []string{"a", "b", "c"},
+			batches: [][]string{{"a", "b", "c"}},
+			stats:   AckStats{Acknowledged: 3},
+		},
+		{
+			name:      "failed entry retried alone",
+			handles:   []string{"a", "b", "c"},
+			entryErrs: map[string][]error{"b": {transient}},
+			batches:   [][]string{{"a", "b", "c"}, {"b"}},
+			stats:     AckStats{Acknowledged: 3},
+		},
+		{
+			name:      "sender fault not retried",
+			handles:   []string{"a", "b"},
+			entryErrs: map[string][]error{"a": {senderFault}},
+			batches:   [][]string{{"a", "b"}},
+			stats:     AckStats{Acknowledged: 1, Failed: 1},
+		},
+		{
+			name:      "invalid receipt not retried",
+			handles:   []string{"a", "b"},
+			entryErrs: map[string][]error{"b": {ErrInvalidReceipt}},
+			batches:   [][]string{{"a", "b"}},
+			stats:     AckStats{Acknowledged: 1, Failed: 1},
+		},
+		{
+			name:      "given up after max attempts",
+			handles:   []string{"a", "b"},
+			entryErrs: map[string][]error{"a": {transient, transient, transient, transient}},
+			batches:   [][]string{{"a", "b"}, {"a"}, {"a"}},
+			stats:     AckStats{Acknowledged: 1, Failed: 1},
+		},
+		{
+			name:     "failed call retried",
+			handles:  []string{"a", "b"},
+			callErrs: []error{errors.New("connection reset")},
+			batches:  [][]string{{"a", "b"}, {"a", "b"}},
+			stats:    AckStats{Acknowledged: 2},
+		},
+		{
+			name:    "batches of ten",
+			handles: strings.Split("0 1 2 3 4 5 6 7 8 9 10 11", " "),
+			batches: [][]string{strings.Split("0 1 2 3 4 5 6 7 8 9", " "), {"10", "11"}},
+			stats:   AckStats{Acknowledged: 12},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleter := &scriptedDeleter{entryErrs: tt.entryErrs, callErrs: tt.callErrs}
+			acker := NewAcker(deleter, AckConfig{MaxDelay: time.Hour, MaxAttempts: 3})
+			for _, handle := range tt.handles {
+				acker.Ack(handle)
+			}
+
+			if err := acker.Flush(context.Background()); err != nil {
+				t.Fatalf("Flush: %v", err)
+			}
+			if !reflect.DeepEqual(deleter.batches, tt.batches) {
+				t.Errorf("batches = %v, want %v", deleter.batches, tt.batches)
+			}
+			if got := acker.Stats(); got != tt.stats {
+				t.Errorf("Stats = %+v, want %+v", got, tt.stats)
+			}
+		})
+	}
+}
+
+func TestAckerRunSendsFullBatch(t *testing.T) {
+	deleter := &scriptedDeleter{}
+	// A full batch goes out without waiting for MaxDelay
+	acker := NewAcker(deleter, AckConfig{MaxDelay: time.Hour, MaxAttempts: 3})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go acker.Run(ctx)
+
+	for i := 0; i < maxBatch; i++ {
+		acker.Ack(strconv.Itoa(i))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for acker.Stats().Acknowledged < maxBatch {
+		if time.Now().After(deadline) {
+			t.Fatalf("Stats = %+v, want %d acknowledged", acker.Stats(), maxBatch)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// roundTripFunc answers SQS requests without a network
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestSQSDeleteBatchEntryErrors(t *testing.T) {
+	transport := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if target := r.Header.Get("X-Amz-Target"); target != "AmazonSQS.DeleteMessageBatch" {
+			t.Errorf("X-Amz-Target = %q", target)
+		}
+		body := `{
+			"Successful": [{"Id": "0"}],
+			"Failed": [
+				{"Id": "1", "Code": "ReceiptHandleIsInvalid", "Message": "expired", "SenderFault": true},
+				{"Id": "2", "Code": "InternalError", "Message": "try again", "SenderFault": false}
+			]}`
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/x-amz-json-1.0"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    r,
+		}, nil
+	})
+	client := sqs.New(sqs.Options{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		BaseEndpoint: aws.String("https://sqs.us-east-1.amazonaws.com"),
+		HTTPClient:   &http.Client{Transport: transport},
+	})
+	consumer := NewSQSConsumer(client, "https://sqs.us-east-1.amazonaws.com/000000000000/orders", DefaultQueueConfig())
+
+	errs, err := consumer.DeleteBatch(context.Background(), []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("DeleteBatch: %v", err)
+	}
+	if len(errs) != 3 || errs[0] != nil {
+		t.Fatalf("errs = %v", errs)
+	}
+	if !errors.Is(errs[1], ErrInvalidReceipt) || retryableAck(errs[1]) {
+		t.Errorf("errs[1] = %v, want a final ErrInvalidReceipt", errs[1])
+	}
+	var entryErr *BatchEntryError
+	if !errors.As(errs[2], &entryErr) || entryErr.Code != "InternalError" || !retryableAck(errs[2]) {
+		t.Errorf("errs[2] = %v, want a retryable InternalError", errs[2])
+	}
+}
@@ -0,0 +1,199 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// SNSPublisher publishes to an SNS topic
+type SNSPublisher struct {
+	client   *sns.Client
+	topicArn string
+}
+
+// NewSNSPublisher creates a publisher for topicArn
+func NewSNSPublisher(client *sns.Client, topicArn string) *SNSPublisher {
+	return &SNSPublisher{client: client, topicArn: topicArn}
+}
+
+// Publish sends body with attributes as String message attributes
+func (p *SNSPublisher) Publish(ctx context.Context, body string, attributes map[string]string) error {
+	messageAttributes := make(map[string]snstypes.MessageAttributeValue, len(attributes))
+	for name, value := range attributes {
+		messageAttributes[name] = snstypes.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+
+	_, err := p.client.Publish(ctx, &sns.PublishInput{
+		Message:           aws.String(body),
+		TopicArn:          aws.String(p.topicArn),
+		MessageAttributes: messageAttributes,
+	})
+	return err
+}
+
+// SQSPublisher sends events straight to an SQS queue, e.g. a failure
+// destination
+type SQSPublisher struct {
+	client   *sqs.Client
+	queueURL string
+}
+
+// NewSQSPublisher creates a publisher for queueURL
+func NewSQSPublisher(client *sqs.Client, queueURL string) *SQSPublisher {
+	return &SQSPublisher{client: client, queueURL: queueURL}
+}
+
+// Publish sends body with attributes as String message attributes
+func (p *SQSPublisher) Publish(ctx context.Context, body string, attributes map[string]string) error {
+	messageAttributes := make(map[string]sqstypes.MessageAttributeValue, len(attributes))
+	for name, value := range attributes {
+		messageAttributes[name] = sqstypes.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(value),
+		}
+	}
+
+	_, err := p.client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:          aws.String(p.queueURL),
+		MessageBody:       aws.String(body),
+		MessageAttributes: messageAttributes,
+	})
+	return err
+}
+
+// QueueURL returns the URL of the SQS queue with the given ARN, e.g. the
+// event source of a Lambda record
+func QueueURL(queueARN string) (string, error) {
+	// arn:PARTITION:sqs:REGION:ACCOUNT:NAME
+	parts := strings.Split(queueARN, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "sqs" {
+		return "", fmt.Errorf("invalid SQS queue ARN %q", queueARN)
+	}
+	domain := "amazonaws.com"
+	if parts[1] == "aws-cn" {
+		domain = "amazonaws.com.cn"
+	}
+	return fmt.Sprintf("https://sqs.%s.%s/%s/%s", parts[3], domain, parts[4], parts[5]), nil
+}
+
+// SQSConsumer receives from an SQS queue
+type SQSConsumer struct {
+	client   *sqs.Client
+	queueURL string
+	cfg      QueueConfig
+}
+
+// NewSQSConsumer creates a consumer for queueURL
+func NewSQSConsumer(client *sqs.Client, queueURL string, cfg QueueConfig) *SQSConsumer {
+	return &SQSConsumer{client: client, queueURL: queueURL, cfg: cfg}
+}
+
+// Receive long-polls the queue. SQS allows at most 10 messages per call.
+func (c *SQSConsumer) Receive(ctx context.Context, maxMessages int) ([]Message, error) {
+	if maxMessages > 10 {
+		maxMessages = 10
+	}
+	result, err := c.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(c.queueURL),
+		MaxNumberOfMessages:   int32(maxMessages),
+		WaitTimeSeconds:       int32(c.cfg.WaitTime / time.Second),
+		VisibilityTimeout:     int32(c.cfg.VisibilityTimeout / time.Second),
+		MessageAttributeNames: []string{"All"},
+		MessageSystemAttributeNames: []sqstypes.MessageSystemAttributeName{
+			sqstypes.MessageSystemAttributeNameApproximateReceiveCount,
+			sqstypes.MessageSystemAttributeNameSentTimestamp,
+			sqstypes.MessageSystemAttributeNameSenderId,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]Message, 0, len(result.Messages))
+	for _, m := range result.Messages {
+		msg := Message{
+			ID:            aws.ToString(m.MessageId),
+			Body:          aws.ToString(m.Body),
+			Attributes:    make(map[string]string, len(m.MessageAttributes)),
+			ReceiptHandle: aws.ToString(m.ReceiptHandle),
+			SenderID:      m.Attributes[string(sqstypes.MessageSystemAttributeNameSenderId)],
+		}
+		for name, value := range m.MessageAttributes {
+			msg.Attributes[name] = aws.ToString(value.StringValue)
+		}
+		msg.ReceiveCount, _ = strconv.Atoi(m.Attributes[string(sqstypes.MessageSystemAttributeNameApproximateReceiveCount)])
+		if ms, err := strconv.ParseInt(m.Attributes[string(sqstypes.MessageSystemAttributeNameSentTimestamp)], 10, 64); err == nil {
+			msg.SentAt = time.UnixMilli(ms)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// Delete removes a received message from the queue
+func (c *SQSConsumer) Delete(ctx context.Context, receiptHandle string) error {
+	_, err := c.client.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(c.queueURL),
+		ReceiptHandle: aws.String(receiptHandle),
+	})
+	return err
+}
+
+// DeleteBatch removes up to 10 received messages in one call
+func (c *SQSConsumer) DeleteBatch(ctx context.Context, receiptHandles []string) ([]error, error) {
+	entries := make([]sqstypes.DeleteMessageBatchRequestEntry, len(receiptHandles))
+	for i, handle := range receiptHandles {
+		entries[i] = sqstypes.DeleteMessageBatchRequestEntry{
+			Id:            aws.String(strconv.Itoa(i)),
+			ReceiptHandle: aws.String(handle),
+		}
+	}
+	result, err := c.client.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{
+		QueueUrl: aws.String(c.queueURL),
+		Entries:  entries,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	errs := make([]error, len(receiptHandles))
+	for _, failed := range result.Failed {
+		i, err := strconv.Atoi(aws.ToString(failed.Id))
+		if err != nil || i < 0 || i >= len(errs) {
+			continue
+		}
+		entryErr := &BatchEntryError{
+			Code:        aws.ToString(failed.Code),
+			Message:     aws.ToString(failed.Message),
+			SenderFault: failed.SenderFault,
+		}
+		errs[i] = entryErr
+		if entryErr.Code == "ReceiptHandleIsInvalid" {
+			errs[i] = fmt.Errorf("%w: %v", ErrInvalidReceipt, entryErr)
+		}
+	}
+	return errs, nil
+}
+
+// ChangeVisibility sets the visibility timeout of a received message,
+// counted from now. SQS takes whole seconds.
+func (c *SQSConsumer) ChangeVisibility(ctx context.Context, receiptHandle string, timeout time.Duration) error {
+	_, err := c.client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(c.queueURL),
+		ReceiptHandle:     aws.String(receiptHandle),
+		VisibilityTimeout: int32(timeout / time.Second),
+	})
+	return err
+}
@@ -0,0 +1,121 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DeliveryMode is how a message reached the queue
+type DeliveryMode string
+
+// Delivery modes
+const (
+	// DeliverySNS is an SNS notification wrapped in its JSON envelope
+	DeliverySNS DeliveryMode = "sns"
+	// DeliveryRawSNS is an SNS notification with raw message delivery
+	DeliveryRawSNS DeliveryMode = "raw-sns"
+	// DeliveryDirect is a message sent straight to the queue
+	DeliveryDirect DeliveryMode = "direct"
+)
+
+// Delivery is a received message with any SNS envelope removed
+type Delivery struct {
+	Mode DeliveryMode
+	// Body is what the publisher sent
+	Body string
+	// Attributes are the publisher's message attributes, from the
+	// envelope or the queue message. They are never covered by the SNS
+	// signature.
+	Attributes map[string]string
+	// MessageID is the SNS message ID; raw and direct deliveries only
+	// have the queue's
+	MessageID string
+	// TopicArn and Subject are only known for DeliverySNS
+	TopicArn string
+	Subject  string
+	// Timestamp is when SNS accepted the message, or when it was sent to
+	// the queue
+	Timestamp time.Time
+}
+
+// Unwrapper detects how queued messages were delivered
+type Unwrapper struct {
+	// Verifier checks the signature of SNS envelopes; nil accepts them
+	// unverified. Envelopes delivered by a Broker are never checked.
+	Verifier *SignatureVerifier
+	// SNSSenderIDs are the queue sender IDs of SNS. Raw SNS deliveries
+	// look like direct sends otherwise.
+	SNSSenderIDs map[string]bool
+}
+
+// UnwrapperFromEnv reads SNS_VERIFY_SIGNATURES (default true) and
+// SNS_SENDER_IDS, a comma-separated list of sender IDs
+func UnwrapperFromEnv() (*Unwrapper, error) {
+	u := &Unwrapper{SNSSenderIDs: make(map[string]bool)}
+
+	verify := true
+	if v := os.Getenv("SNS_VERIFY_SIGNATURES"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SNS_VERIFY_SIGNATURES %q", v)
+		}
+		verify = b
+	}
+	if verify {
+		u.Verifier = NewSignatureVerifier(&http.Client{Timeout: 10 * time.Second})
+	}
+
+	for _, id := range strings.Split(os.Getenv("SNS_SENDER_IDS"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			u.SNSSenderIDs[id] = true
+		}
+	}
+	return u, nil
+}
+
+// Unwrap removes the SNS envelope of m, verifying its signature, or
+// returns the body of a raw or direct message as is. The signature covers
+// the body but not the attributes, which anyone able to send to the queue
+// can set. Messages from a Broker topic (SenderID BrokerSenderID) are
+// unsigned and accepted as SNS deliveries without verification.
+func (u *Unwrapper) Unwrap(ctx context.Context, m Message) (*Delivery, error) {
+	fromBroker := m.SenderID == BrokerSenderID
+	n, ok := ParseNotification(m.Body)
+	if !ok {
+		mode := DeliveryDirect
+		if fromBroker || u.SNSSenderIDs[m.SenderID] {
+			mode = DeliveryRawSNS
+		}
+		return &Delivery{
+			Mode:       mode,
+			Body:       m.Body,
+			Attributes: m.Attributes,
+			MessageID:  m.ID,
+			Timestamp:  m.SentAt,
+		}, nil
+	}
+
+	if u.Verifier != nil && !fromBroker {
+		if err := u.Verifier.Verify(ctx, n); err != nil {
+			return nil, err
+		}
+	}
+	timestamp, err := time.Parse(time.RFC3339Nano, n.Timestamp)
+	if err != nil {
+		timestamp = m.SentAt
+	}
+	return &Delivery{
+		Mode:       DeliverySNS,
+		Body:       n.Message,
+		Attributes: n.Attributes(),
+		MessageID:  n.MessageID,
+		TopicArn:   n.TopicArn,
+		Subject:    n.Subject,
+		Timestamp:  timestamp,
+	}, nil
+}
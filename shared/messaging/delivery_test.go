@@ -0,0 +1,70 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUnwrapBrokerDeliveries(t *testing.T) {
+	// The verifier would reject any unsigned envelope; the broker's must
+	// still get through
+	unwrapper := &Unwrapper{Verifier: NewSignatureVerifier(http.DefaultClient)}
+
+	tests := []struct {
+		name string
+		raw  bool
+		mode DeliveryMode
+	}{
+		{name: "envelope", raw: false, mode: DeliverySNS},
+		{name: "raw", raw: true, mode: DeliveryRawSNS},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			broker := NewBroker()
+			queue := broker.Queue(tt.name, QueueConfig{VisibilityTimeout: time.Minute})
+			topic := broker.Topic("orders")
+			topic.Subscribe(queue, tt.raw)
+
+			if err := topic.Publish(ctx, `{"orderId":"o-1"}`, map[string]string{"type": "OrderCreated"}); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+			messages, err := queue.Receive(ctx, 1)
+			if err != nil || len(messages) != 1 {
+				t.Fatalf("Receive = %d messages, %v", len(messages), err)
+			}
+
+			d, err := unwrapper.Unwrap(ctx, messages[0])
+			if err != nil {
+				t.Fatalf("Unwrap: %v", err)
+			}
+			if d.Mode != tt.mode {
+				t.Errorf("Mode = %q, want %q", d.Mode, tt.mode)
+			}
+			if d.Body != `{"orderId":"o-1"}` {
+				t.Errorf("Body = %q", d.Body)
+			}
+			if d.Attributes["type"] != "OrderCreated" {
+				t.Errorf("Attributes = %v", d.Attributes)
+			}
+		})
+	}
+}
+
+func TestUnwrapUnsignedEnvelope(t *testing.T) {
+	// The same envelope sent straight to a queue is not trusted
+	broker := NewBroker()
+	envelope, err := broker.Topic("orders").envelope("m-1", `{"orderId":"o-1"}`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unwrapper := &Unwrapper{Verifier: NewSignatureVerifier(http.DefaultClient)}
+
+	_, err = unwrapper.Unwrap(context.Background(), Message{ID: "q-1", Body: envelope})
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("Unwrap error = %v, want ErrInvalidSignature", err)
+	}
+}
@@ -0,0 +1,98 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrLifetimeExceeded is reported by a heartbeat that stopped extending
+// its message after the queue's MaxLifetime
+var ErrLifetimeExceeded = errors.New("message exceeded its maximum processing lifetime")
+
+// Heartbeat keeps a received message hidden from other consumers while it
+// is processed by extending its visibility timeout every
+// HeartbeatInterval. Once an extension fails or MaxLifetime passes, the
+// message may be delivered again and Err reports why; the handler should
+// then abandon the message rather than finish it twice.
+type Heartbeat struct {
+	consumer      MessageConsumer
+	receiptHandle string
+	cfg           QueueConfig
+	started       time.Time
+
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	mu  sync.Mutex
+	err error
+}
+
+// StartHeartbeat starts extending the visibility of message. It should be
+// started as soon as the message is received, since its visibility
+// timeout runs from then.
+func StartHeartbeat(ctx context.Context, consumer MessageConsumer, message Message, cfg QueueConfig) *Heartbeat {
+	h := &Heartbeat{
+		consumer:      consumer,
+		receiptHandle: message.ReceiptHandle,
+		cfg:           cfg,
+		started:       time.Now(),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go h.run(ctx)
+	return h
+}
+
+func (h *Heartbeat) run(ctx context.Context) {
+	defer close(h.done)
+
+	ticker := time.NewTicker(h.cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if time.Since(h.started)+h.cfg.VisibilityTimeout > h.cfg.MaxLifetime {
+				h.fail(ErrLifetimeExceeded)
+				return
+			}
+			if err := h.consumer.ChangeVisibility(ctx, h.receiptHandle, h.cfg.VisibilityTimeout); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				h.fail(fmt.Errorf("extend visibility: %w", err))
+				return
+			}
+		}
+	}
+}
+
+func (h *Heartbeat) fail(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.err = err
+}
+
+// Err returns nil while the message is known to be hidden from other
+// consumers
+func (h *Heartbeat) Err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.err
+}
+
+// Stop ends the heartbeat and waits for an extension in flight
+func (h *Heartbeat) Stop() {
+	h.stopOnce.Do(func() { close(h.stop) })
+	<-h.done
+}
@@ -0,0 +1,296 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// BrokerSenderID is the SenderID of messages a Broker topic delivers to
+// its queues. SQS sets SenderId itself, so no message received from AWS
+// carries it.
+const BrokerSenderID = "memory-broker"
+
+// Broker is an in-process stand-in for SNS and SQS. Topics fan out to
+// subscribed queues; queues implement visibility timeouts, receive counts
+// and deletes like SQS, so a receiver and a processor can share one broker
+// in a single process or test.
+type Broker struct {
+	mu     sync.Mutex
+	topics map[string]*Topic
+	queues map[string]*Queue
+}
+
+// NewBroker creates an empty broker
+func NewBroker() *Broker {
+	return &Broker{
+		topics: make(map[string]*Topic),
+		queues: make(map[string]*Queue),
+	}
+}
+
+// Topic returns the named topic, creating it on first use
+func (b *Broker) Topic(name string) *Topic {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t, ok := b.topics[name]
+	if !ok {
+		t = &Topic{name: name}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// Queue returns the named queue, creating it with cfg on first use
+func (b *Broker) Queue(name string, cfg QueueConfig) *Queue {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	q, ok := b.queues[name]
+	if !ok {
+		q = &Queue{name: name, cfg: cfg, notify: make(chan struct{})}
+		b.queues[name] = q
+	}
+	return q
+}
+
+// Topic delivers published events to every subscribed queue
+type Topic struct {
+	name string
+
+	mu            sync.Mutex
+	subscriptions []subscription
+}
+
+type subscription struct {
+	queue *Queue
+	raw   bool
+}
+
+// Subscribe delivers the topic's events to q. Without raw delivery the
+// body is wrapped in an SNS notification envelope, as SNS does for SQS
+// subscriptions. The envelope is not signed; the queue records the
+// delivery as sent by BrokerSenderID instead, which Unwrapper trusts.
+func (t *Topic) Subscribe(q *Queue, raw bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.subscriptions = append(t.subscriptions, subscription{queue: q, raw: raw})
+}
+
+// Publish delivers the event to all subscriptions. Events published
+// without subscriptions are dropped.
+func (t *Topic) Publish(ctx context.Context, body string, attributes map[string]string) error {
+	t.mu.Lock()
+	subscriptions := append([]subscription(nil), t.subscriptions...)
+	t.mu.Unlock()
+
+	messageID := uuid.New().String()
+	for _, sub := range subscriptions {
+		if sub.raw {
+			sub.queue.send(body, attributes, BrokerSenderID)
+			continue
+		}
+		envelope, err := t.envelope(messageID, body, attributes)
+		if err != nil {
+			return err
+		}
+		sub.queue.send(envelope, nil, BrokerSenderID)
+	}
+	return nil
+}
+
+// envelope renders an SNS notification
+func (t *Topic) envelope(messageID, body string, attributes map[string]string) (string, error) {
+	type attribute struct {
+		Type  string `json:"Type"`
+		Value string `json:"Value"`
+	}
+	messageAttributes := make(map[string]attribute, len(attributes))
+	for name, value := range attributes {
+		messageAttributes[name] = attribute{Type: "String", Value: value}
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"Type":              "Notification",
+		"MessageId":         messageID,
+		"TopicArn":          "arn:memory:sns:local:000000000000:" + t.name,
+		"Message":           body,
+		"Timestamp":         time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+		"MessageAttributes": messageAttributes,
+	})
+	return string(data), err
+}
+
+// Queue is an in-memory queue with SQS delivery semantics
+type Queue struct {
+	name string
+	cfg  QueueConfig
+
+	mu       sync.Mutex
+	messages []*queuedMessage
+	// deadLetters receives messages received more than maxReceiveCount
+	// times; nil keeps them
+	deadLetters     *Queue
+	maxReceiveCount int
+	// notify is closed and replaced whenever a message is sent
+	notify chan struct{}
+}
+
+type queuedMessage struct {
+	msg       Message
+	visibleAt time.Time
+}
+
+// SetRedrive moves messages received maxReceiveCount times to
+// deadLetters on their next receive, as an SQS redrive policy does
+func (q *Queue) SetRedrive(deadLetters *Queue, maxReceiveCount int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.deadLetters = deadLetters
+	q.maxReceiveCount = maxReceiveCount
+}
+
+// Send appends a message to the queue, as a direct send
+func (q *Queue) Send(ctx context.Context, body string, attributes map[string]string) {
+	q.send(body, attributes, "")
+}
+
+func (q *Queue) send(body string, attributes map[string]string, senderID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	attrs := make(map[string]string, len(attributes))
+	for k, v := range attributes {
+		attrs[k] = v
+	}
+	now := time.Now()
+	q.messages = append(q.messages, &queuedMessage{
+		msg: Message{
+			ID:         uuid.New().String(),
+			Body:       body,
+			Attributes: attrs,
+			SenderID:   senderID,
+			SentAt:     now,
+		},
+		visibleAt: now,
+	})
+	close(q.notify)
+	q.notify = make(chan struct{})
+}
+
+// Receive returns up to maxMessages visible messages, oldest first,
+// waiting up to the queue's wait time for one to become visible
+func (q *Queue) Receive(ctx context.Context, maxMessages int) ([]Message, error) {
+	deadline := time.Now().Add(q.cfg.WaitTime)
+	for {
+		q.mu.Lock()
+		now := time.Now()
+		var received []Message
+		wake := deadline
+		kept := q.messages[:0]
+		for _, m := range q.messages {
+			if m.visibleAt.After(now) || len(received) == maxMessages {
+				if m.visibleAt.After(now) && m.visibleAt.Before(wake) {
+					wake = m.visibleAt
+				}
+				kept = append(kept, m)
+				continue
+			}
+			if q.deadLetters != nil && m.msg.ReceiveCount >= q.maxReceiveCount {
+				q.deadLetters.send(m.msg.Body, m.msg.Attributes, m.msg.SenderID)
+				continue
+			}
+			kept = append(kept, m)
+			m.msg.ReceiveCount++
+			m.msg.ReceiptHandle = uuid.New().String()
+			m.visibleAt = now.Add(q.cfg.VisibilityTimeout)
+			received = append(received, copyMessage(m.msg))
+		}
+		for i := len(kept); i < len(q.messages); i++ {
+			q.messages[i] = nil
+		}
+		q.messages = kept
+		notify := q.notify
+		q.mu.Unlock()
+
+		if len(received) > 0 || !now.Before(deadline) {
+			return received, nil
+		}
+
+		timer := time.NewTimer(wake.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Delete removes the message received with receiptHandle
+func (q *Queue) Delete(ctx context.Context, receiptHandle string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, m := range q.messages {
+		if m.msg.ReceiptHandle == receiptHandle {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			return nil
+		}
+	}
+	return ErrInvalidReceipt
+}
+
+// DeleteBatch removes the messages received with receiptHandles
+func (q *Queue) DeleteBatch(ctx context.Context, receiptHandles []string) ([]error, error) {
+	errs := make([]error, len(receiptHandles))
+	for i, handle := range receiptHandles {
+		errs[i] = q.Delete(ctx, handle)
+	}
+	return errs, nil
+}
+
+// ChangeVisibility hides the message received with receiptHandle for
+// timeout from now
+func (q *Queue) ChangeVisibility(ctx context.Context, receiptHandle string, timeout time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, m := range q.messages {
+		if m.msg.ReceiptHandle == receiptHandle {
+			m.visibleAt = time.Now().Add(timeout)
+			if timeout <= 0 {
+				// Wake receivers waiting for a visible message
+				close(q.notify)
+				q.notify = make(chan struct{})
+			}
+			return nil
+		}
+	}
+	return ErrInvalidReceipt
+}
+
+// Len returns the number of messages in the queue, visible or not
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.messages)
+}
+
+func copyMessage(m Message) Message {
+	attrs := make(map[string]string, len(m.Attributes))
+	for k, v := range m.Attributes {
+		attrs[k] = v
+	}
+	m.Attributes = attrs
+	return m
+}
@@ -0,0 +1,120 @@
+// Package messaging hides the message bus behind small interfaces: SNS and
+// SQS in AWS, or an in-process broker for local runs and integration tests.
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ErrInvalidReceipt is returned when a receipt handle does not match a
+// message that is currently received, e.g. after it was deleted or its
+// visibility timeout passed and it was received again
+var ErrInvalidReceipt = errors.New("invalid receipt handle")
+
+// Message is a received message
+type Message struct {
+	ID   string
+	Body string
+	// Attributes are the message attributes (empty for SNS notifications
+	// without raw delivery, where they travel inside the body)
+	Attributes map[string]string
+	// SenderID is the queue's record of who sent the message, e.g. an IAM
+	// principal or SNS
+	SenderID string
+	// ReceiptHandle identifies this receipt for Delete
+	ReceiptHandle string
+	// ReceiveCount is how many times the message has been received,
+	// including this time
+	ReceiveCount int
+	SentAt       time.Time
+}
+
+// EventPublisher publishes events to subscribers
+type EventPublisher interface {
+	Publish(ctx context.Context, body string, attributes map[string]string) error
+}
+
+// MessageConsumer receives messages from a queue. A received message is
+// hidden from other consumers for the visibility timeout and delivered
+// again unless it is deleted.
+type MessageConsumer interface {
+	// Receive waits up to the consumer's wait time for at most
+	// maxMessages messages. It returns no messages and no error if none
+	// arrived.
+	Receive(ctx context.Context, maxMessages int) ([]Message, error)
+	// Delete acknowledges a received message
+	Delete(ctx context.Context, receiptHandle string) error
+	// ChangeVisibility hides a received message for timeout from now;
+	// zero makes it visible again at once
+	ChangeVisibility(ctx context.Context, receiptHandle string, timeout time.Duration) error
+}
+
+// QueueConfig holds queue consumer settings
+type QueueConfig struct {
+	// VisibilityTimeout hides a received message from other consumers
+	VisibilityTimeout time.Duration
+	// WaitTime is how long Receive waits for messages (long polling)
+	WaitTime time.Duration
+	// HeartbeatInterval is how often a message being processed is hidden
+	// for another VisibilityTimeout; it must be shorter than
+	// VisibilityTimeout
+	HeartbeatInterval time.Duration
+	// MaxLifetime bounds how long heartbeats keep one message hidden
+	MaxLifetime time.Duration
+}
+
+// DefaultQueueConfig returns 30s visibility extended every 10s for up to
+// 10m, and 20s long polling
+func DefaultQueueConfig() QueueConfig {
+	return QueueConfig{
+		VisibilityTimeout: 30 * time.Second,
+		WaitTime:          20 * time.Second,
+		HeartbeatInterval: 10 * time.Second,
+		MaxLifetime:       10 * time.Minute,
+	}
+}
+
+// MaxHidden is the longest a received message stays hidden from other
+// consumers: heartbeats extend it for MaxLifetime, and the last extension
+// lasts one more VisibilityTimeout
+func (c QueueConfig) MaxHidden() time.Duration {
+	return c.MaxLifetime + c.VisibilityTimeout
+}
+
+// QueueConfigFromEnv reads SQS_VISIBILITY_TIMEOUT (seconds, like the queue
+// setting), VISIBILITY_HEARTBEAT_INTERVAL and VISIBILITY_MAX_LIFETIME over
+// DefaultQueueConfig
+func QueueConfigFromEnv() (QueueConfig, error) {
+	cfg := DefaultQueueConfig()
+	if v := os.Getenv("SQS_VISIBILITY_TIMEOUT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("invalid SQS_VISIBILITY_TIMEOUT %q", v)
+		}
+		cfg.VisibilityTimeout = time.Duration(n) * time.Second
+	}
+	if v := os.Getenv("VISIBILITY_HEARTBEAT_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid VISIBILITY_HEARTBEAT_INTERVAL %q", v)
+		}
+		cfg.HeartbeatInterval = d
+	}
+	if v := os.Getenv("VISIBILITY_MAX_LIFETIME"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid VISIBILITY_MAX_LIFETIME %q", v)
+		}
+		cfg.MaxLifetime = d
+	}
+	if cfg.HeartbeatInterval >= cfg.VisibilityTimeout {
+		return cfg, fmt.Errorf("VISIBILITY_HEARTBEAT_INTERVAL %s must be shorter than the %s visibility timeout",
+			cfg.HeartbeatInterval, cfg.VisibilityTimeout)
+	}
+	return cfg, nil
+}
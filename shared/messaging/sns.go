@@ -0,0 +1,193 @@
+package messaging
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidSignature is returned for SNS notifications that are unsigned
+// or whose signature does not verify
+var ErrInvalidSignature = errors.New("invalid SNS signature")
+
+// snsCertHost matches the hosts SNS serves signing certificates from
+var snsCertHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// Notification is an SNS notification as delivered to an SQS queue
+// without raw message delivery
+type Notification struct {
+	Type              string `json:"Type"`
+	MessageID         string `json:"MessageId"`
+	TopicArn          string `json:"TopicArn"`
+	Subject           string `json:"Subject,omitempty"`
+	Message           string `json:"Message"`
+	Timestamp         string `json:"Timestamp"`
+	SignatureVersion  string `json:"SignatureVersion,omitempty"`
+	Signature         string `json:"Signature,omitempty"`
+	SigningCertURL    string `json:"SigningCertURL,omitempty"`
+	UnsubscribeURL    string `json:"UnsubscribeURL,omitempty"`
+	MessageAttributes map[string]struct {
+		Type  string `json:"Type"`
+		Value string `json:"Value"`
+	} `json:"MessageAttributes,omitempty"`
+}
+
+// ParseNotification returns the SNS notification in body, or false if body
+// is not one
+func ParseNotification(body string) (*Notification, bool) {
+	var n Notification
+	if err := json.Unmarshal([]byte(body), &n); err != nil {
+		return nil, false
+	}
+	// Field matching is case-insensitive, so an event's "type" must not
+	// pass for an envelope
+	if n.Type != "Notification" || n.MessageID == "" || n.TopicArn == "" {
+		return nil, false
+	}
+	return &n, true
+}
+
+// Attributes returns the string values of the message attributes
+func (n *Notification) Attributes() map[string]string {
+	attributes := make(map[string]string, len(n.MessageAttributes))
+	for name, attribute := range n.MessageAttributes {
+		attributes[name] = attribute.Value
+	}
+	return attributes
+}
+
+// stringToSign builds the signed text of a notification: the signed
+// fields in alphabetical order, each as "name\nvalue\n". SNS does not sign
+// MessageAttributes, so a valid signature says nothing about them.
+func (n *Notification) stringToSign() string {
+	var b strings.Builder
+	field := func(name, value string) {
+		b.WriteString(name)
+		b.WriteByte('\n')
+		b.WriteString(value)
+		b.WriteByte('\n')
+	}
+	field("Message", n.Message)
+	field("MessageId", n.MessageID)
+	if n.Subject != "" {
+		field("Subject", n.Subject)
+	}
+	field("Timestamp", n.Timestamp)
+	field("TopicArn", n.TopicArn)
+	field("Type", n.Type)
+	return b.String()
+}
+
+// SignatureVerifier checks SNS notification signatures against the signing
+// certificates SNS publishes, caching each certificate by URL
+type SignatureVerifier struct {
+	client *http.Client
+
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate
+}
+
+// NewSignatureVerifier creates a verifier fetching certificates with client
+func NewSignatureVerifier(client *http.Client) *SignatureVerifier {
+	return &SignatureVerifier{client: client, certs: make(map[string]*x509.Certificate)}
+}
+
+// Verify checks the signature of n (SignatureVersion 1 is SHA1, 2 is
+// SHA256, both RSA PKCS #1 v1.5)
+func (v *SignatureVerifier) Verify(ctx context.Context, n *Notification) error {
+	var hash crypto.Hash
+	var digest []byte
+	switch n.SignatureVersion {
+	case "1":
+		sum := sha1.Sum([]byte(n.stringToSign()))
+		hash, digest = crypto.SHA1, sum[:]
+	case "2":
+		sum := sha256.Sum256([]byte(n.stringToSign()))
+		hash, digest = crypto.SHA256, sum[:]
+	case "":
+		return fmt.Errorf("%w: notification %s is not signed", ErrInvalidSignature, n.MessageID)
+	default:
+		return fmt.Errorf("%w: unsupported SignatureVersion %q", ErrInvalidSignature, n.SignatureVersion)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(n.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	cert, err := v.certificate(ctx, n.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: signing certificate has no RSA key", ErrInvalidSignature)
+	}
+	if err := rsa.VerifyPKCS1v15(key, hash, digest, signature); err != nil {
+		return fmt.Errorf("%w: notification %s: %v", ErrInvalidSignature, n.MessageID, err)
+	}
+	return nil
+}
+
+// certificate returns the signing certificate at rawURL, which must be
+// served over HTTPS by SNS
+func (v *SignatureVerifier) certificate(ctx context.Context, rawURL string) (*x509.Certificate, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || !snsCertHost.MatchString(u.Hostname()) || !strings.HasSuffix(u.Path, ".pem") {
+		return nil, fmt.Errorf("%w: untrusted SigningCertURL %q", ErrInvalidSignature, rawURL)
+	}
+
+	v.mu.Lock()
+	cert, ok := v.certs[rawURL]
+	v.mu.Unlock()
+	if ok && time.Now().Before(cert.NotAfter) {
+		return cert, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch signing certificate: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch signing certificate: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, fmt.Errorf("fetch signing certificate: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: signing certificate is not PEM", ErrInvalidSignature)
+	}
+	cert, err = x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("%w: signing certificate is not valid now", ErrInvalidSignature)
+	}
+
+	v.mu.Lock()
+	v.certs[rawURL] = cert
+	v.mu.Unlock()
+	return cert, nil
+}
@@ -0,0 +1,169 @@
+// Package orders holds the order domain shared by the sync service, the
+// async receiver/processor and the Lambda processor.
+package orders
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/money"
+)
+
+// Order represents an e-commerce order
+type Order struct {
+	OrderID    string    `json:"order_id"`
+	CustomerID int       `json:"customer_id"`
+	Status     Status    `json:"status"`
+	Items      []Item    `json:"items"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	// Version is incremented by the repository on every status change
+	Version int64 `json:"version"`
+	// History lists every status change, oldest first. It is append-only.
+	History []Transition `json:"history,omitempty"`
+	// PromoCode and Region are optional pricing inputs
+	PromoCode string `json:"promo_code,omitempty"`
+	Region    string `json:"region,omitempty"`
+	// Pricing is set by the pricing engine when the order is accepted
+	Pricing *Pricing `json:"pricing,omitempty"`
+}
+
+// Item represents an item in an order
+type Item struct {
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+	// Price is the unit price
+	Price money.Money `json:"price"`
+}
+
+// itemJSON is the wire form of Item. The price stays a decimal number in
+// major units, as before currencies were supported, with the currency
+// beside it.
+type itemJSON struct {
+	ProductID string          `json:"product_id"`
+	Quantity  int             `json:"quantity"`
+	Price     json.RawMessage `json:"price,omitempty"`
+	Currency  string          `json:"currency,omitempty"`
+}
+
+// MarshalJSON writes the price as a decimal number and its currency
+func (i Item) MarshalJSON() ([]byte, error) {
+	return json.Marshal(itemJSON{
+		ProductID: i.ProductID,
+		Quantity:  i.Quantity,
+		Price:     json.RawMessage(i.Price.Decimal()),
+		Currency:  i.Price.Currency,
+	})
+}
+
+// UnmarshalJSON reads the price as a decimal number (in currency, default
+// USD) or as a {"amount", "currency"} object in minor units
+func (i *Item) UnmarshalJSON(data []byte) error {
+	var wire itemJSON
+	if err := json.Unmarshal(data, &wire); err != nil {
+		return err
+	}
+	price, err := ParsePrice(wire.Price, wire.Currency)
+	if err != nil {
+		return err
+	}
+	*i = Item{ProductID: wire.ProductID, Quantity: wire.Quantity, Price: price}
+	return nil
+}
+
+// ParsePrice decodes a JSON price: a decimal number in major units of
+// currency (DefaultCurrency if empty), or a money object whose currency
+// must agree with currency if both are given
+func ParsePrice(raw json.RawMessage, currency string) (money.Money, error) {
+	raw = bytes.TrimSpace(raw)
+	if currency == "" {
+		currency = money.DefaultCurrency
+	}
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		if _, ok := money.Exponent(currency); !ok {
+			return money.Money{}, fmt.Errorf("%w %q", money.ErrUnknownCurrency, currency)
+		}
+		return money.New(0, currency), nil
+	}
+
+	if raw[0] == '{' {
+		var m money.Money
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return money.Money{}, fmt.Errorf("%w: %v", money.ErrInvalidAmount, err)
+		}
+		if m.Currency == "" {
+			m.Currency = currency
+		}
+		if _, ok := money.Exponent(m.Currency); !ok {
+			return money.Money{}, fmt.Errorf("%w %q", money.ErrUnknownCurrency, m.Currency)
+		}
+		return m, nil
+	}
+
+	var number json.Number
+	if err := json.Unmarshal(raw, &number); err != nil {
+		return money.Money{}, fmt.Errorf("%w: price must be a number", money.ErrInvalidAmount)
+	}
+	return money.Parse(number.String(), currency)
+}
+
+// ErrNoItems is returned by Validate for an order without items
+var ErrNoItems = errors.New("order has no items")
+
+// Validate checks that the order can be charged
+func (o *Order) Validate() error {
+	if len(o.Items) == 0 {
+		return ErrNoItems
+	}
+	for i, item := range o.Items {
+		if item.ProductID == "" {
+			return fmt.Errorf("item %d: missing product_id", i)
+		}
+		if item.Quantity <= 0 {
+			return fmt.Errorf("item %d: quantity must be positive", i)
+		}
+		if item.Price.Amount < 0 {
+			return fmt.Errorf("item %d: price must not be negative", i)
+		}
+	}
+	_, err := o.Total()
+	return err
+}
+
+// Currency returns the currency of the order's items
+func (o *Order) Currency() string {
+	if len(o.Items) == 0 || o.Items[0].Price.Currency == "" {
+		return money.DefaultCurrency
+	}
+	return o.Items[0].Price.Currency
+}
+
+// Total returns the order value (price * quantity summed over items). It
+// fails if the items use different currencies.
+func (o *Order) Total() (money.Money, error) {
+	total := money.New(0, o.Currency())
+	for i, item := range o.Items {
+		var err error
+		if total, err = total.Add(item.Total()); err != nil {
+			return money.Money{}, fmt.Errorf("item %d: %w", i, err)
+		}
+	}
+	return total, nil
+}
+
+// Total returns the line value of the item
+func (i Item) Total() money.Money {
+	return i.Price.Mul(int64(i.Quantity))
+}
+
+// AmountDue returns the amount to charge: the priced total if the order
+// has been priced, otherwise the item total
+func (o *Order) AmountDue() (money.Money, error) {
+	if o.Pricing != nil {
+		return o.Pricing.Total, nil
+	}
+	return o.Total()
+}
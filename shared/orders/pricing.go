@@ -0,0 +1,35 @@
+package orders
+
+import "github.com/shivlal1/Order-Processing-System-on-AWS/shared/money"
+
+// Pricing is the priced breakdown of an order. Amounts are in minor units
+// of the order currency.
+type Pricing struct {
+	Lines     []LinePrice `json:"lines"`
+	Subtotal  money.Money `json:"subtotal"`
+	Discounts []Discount  `json:"discounts,omitempty"`
+	Discount  money.Money `json:"discount"`
+	// TaxRegion is empty if no tax was applied
+	TaxRegion string      `json:"tax_region,omitempty"`
+	Tax       money.Money `json:"tax"`
+	Total     money.Money `json:"total"`
+}
+
+// LinePrice is the breakdown of one item. Total is Subtotal - Discount +
+// Tax.
+type LinePrice struct {
+	ProductID string      `json:"product_id"`
+	Quantity  int         `json:"quantity"`
+	UnitPrice money.Money `json:"unit_price"`
+	Subtotal  money.Money `json:"subtotal"`
+	Discount  money.Money `json:"discount"`
+	Tax       money.Money `json:"tax"`
+	Total     money.Money `json:"total"`
+}
+
+// Discount is a promotion applied to the order
+type Discount struct {
+	Code        string      `json:"code"`
+	Description string      `json:"description"`
+	Amount      money.Money `json:"amount"`
+}
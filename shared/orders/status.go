@@ -0,0 +1,117 @@
+package orders
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Status is the lifecycle state of an order
+type Status string
+
+// Order statuses used across the services
+const (
+	StatusAccepted   Status = "accepted"
+	StatusProcessing Status = "processing"
+	StatusCompleted  Status = "completed"
+	StatusFailed     Status = "failed"
+	StatusCancelled  Status = "cancelled"
+	StatusRefunding  Status = "refunding"
+	StatusRefunded   Status = "refunded"
+)
+
+// ErrIllegalTransition is returned for a status change the state machine
+// does not allow
+var ErrIllegalTransition = errors.New("illegal status transition")
+
+// transitions lists the statuses each status may move to. A new order has
+// the empty status; the sync path starts it in processing, the async path
+// in accepted.
+var transitions = map[Status][]Status{
+	"":             {StatusAccepted, StatusProcessing},
+	StatusAccepted: {StatusProcessing, StatusFailed, StatusCancelled},
+	// processing -> processing restarts an attempt that crashed before
+	// recording its outcome
+	StatusProcessing: {StatusProcessing, StatusCompleted, StatusFailed},
+	// A failed payment is retried when the message is redelivered
+	StatusFailed:    {StatusProcessing, StatusCancelled},
+	StatusCompleted: {StatusRefunding},
+	// refunding -> refunding retries a refund the gateway did not confirm
+	StatusRefunding: {StatusRefunding, StatusRefunded},
+	StatusCancelled: {},
+	StatusRefunded:  {},
+}
+
+// Valid reports whether s is a known status
+func (s Status) Valid() bool {
+	_, ok := transitions[s]
+	return ok && s != ""
+}
+
+// CanTransition reports whether an order may move from one status to
+// another
+func CanTransition(from, to Status) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Chargeable reports whether an order in status s may still be charged:
+// it is accepted, its payment failed, or an attempt crashed while
+// processing. Completed, refunded and cancelled orders never are.
+func (s Status) Chargeable() bool {
+	return s != "" && CanTransition(s, StatusProcessing)
+}
+
+// Transition is one entry of an order's status history
+type Transition struct {
+	From Status    `json:"from"`
+	To   Status    `json:"to"`
+	At   time.Time `json:"at"`
+	// Actor is the service that made the change, e.g. "order-processor"
+	Actor  string `json:"actor"`
+	Reason string `json:"reason,omitempty"`
+	// AuthorizationID is the payment authorization captured or refunded
+	// by the change
+	AuthorizationID string `json:"authorization_id,omitempty"`
+}
+
+// Transition moves the order to status, appending the change to its
+// history. Illegal moves fail with ErrIllegalTransition and leave the
+// order unchanged.
+func (o *Order) Transition(to Status, actor, reason string) (Transition, error) {
+	return o.PaymentTransition(to, actor, reason, "")
+}
+
+// PaymentTransition is Transition for a change that captures or refunds
+// the payment authorization authorizationID
+func (o *Order) PaymentTransition(to Status, actor, reason, authorizationID string) (Transition, error) {
+	if !CanTransition(o.Status, to) {
+		return Transition{}, fmt.Errorf("%w: %q to %q", ErrIllegalTransition, o.Status, to)
+	}
+	t := Transition{
+		From:            o.Status,
+		To:              to,
+		At:              time.Now().UTC(),
+		Actor:           actor,
+		Reason:          reason,
+		AuthorizationID: authorizationID,
+	}
+	o.Status = to
+	o.History = append(o.History, t)
+	return t, nil
+}
+
+// AuthorizationID returns the payment authorization captured when the
+// order completed, or "" if none was recorded
+func (o *Order) AuthorizationID() string {
+	for i := len(o.History) - 1; i >= 0; i-- {
+		if t := o.History[i]; t.To == StatusCompleted && t.AuthorizationID != "" {
+			return t.AuthorizationID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,252 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+)
+
+// PendingIndex is the sparse global secondary index (pending, created_at)
+// holding only unsent events
+const PendingIndex = "pending-created_at-index"
+
+// timeLayout is fixed width so created_at sorts lexically
+const timeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// pendingValue is the pending attribute of unsent events; MarkSent removes
+// it, which drops the event from PendingIndex
+const pendingValue = "1"
+
+// DynamoDBStore keeps the outbox in a table keyed by event_id and writes
+// order and event with TransactWriteItems. Sent events expire through TTL
+// on expires_at (epoch seconds).
+type DynamoDBStore struct {
+	repo   *repository.DynamoDB
+	client *dynamodb.Client
+	table  string
+}
+
+// NewDynamoDBStore creates an outbox on an existing table for repo
+func NewDynamoDBStore(repo *repository.DynamoDB, client *dynamodb.Client, table string) *DynamoDBStore {
+	return &DynamoDBStore{repo: repo, client: client, table: table}
+}
+
+// CreateOrder puts the order and its event in one transaction
+func (d *DynamoDBStore) CreateOrder(ctx context.Context, order *orders.Order, newEvent EventFunc) error {
+	return d.repo.CreateWith(ctx, order, func() ([]types.TransactWriteItem, error) {
+		event, err := newEvent(order)
+		if err != nil {
+			return nil, err
+		}
+		return []types.TransactWriteItem{{Put: d.put(event)}}, nil
+	})
+}
+
+// UpdateStatus updates the order and puts event in one transaction
+func (d *DynamoDBStore) UpdateStatus(ctx context.Context, orderID string, t orders.Transition, version int64, event *Event) (int64, error) {
+	return d.repo.UpdateStatusWith(ctx, orderID, t, version, func() ([]types.TransactWriteItem, error) {
+		return []types.TransactWriteItem{{Put: d.put(event)}}, nil
+	})
+}
+
+// Enqueue puts an event
+func (d *DynamoDBStore) Enqueue(ctx context.Context, event *Event) error {
+	put := d.put(event)
+	_, err := d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           put.TableName,
+		Item:                put.Item,
+		ConditionExpression: put.ConditionExpression,
+	})
+	return err
+}
+
+// put builds the write of a new pending event
+func (d *DynamoDBStore) put(event *Event) *types.Put {
+	attributes := make(map[string]types.AttributeValue, len(event.Attributes))
+	for k, v := range event.Attributes {
+		attributes[k] = &types.AttributeValueMemberS{Value: v}
+	}
+	return &types.Put{
+		TableName: aws.String(d.table),
+		Item: map[string]types.AttributeValue{
+			"event_id":        &types.AttributeValueMemberS{Value: event.ID},
+			"order_id":        &types.AttributeValueMemberS{Value: event.OrderID},
+			"payload":         &types.AttributeValueMemberS{Value: event.Payload},
+			"attributes":      &types.AttributeValueMemberM{Value: attributes},
+			"created_at":      &types.AttributeValueMemberS{Value: event.CreatedAt.UTC().Format(timeLayout)},
+			"attempts":        &types.AttributeValueMemberN{Value: "0"},
+			"next_attempt_at": nanos(event.CreatedAt),
+			"pending":         &types.AttributeValueMemberS{Value: pendingValue},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(event_id)"),
+	}
+}
+
+// Claim queries the pending index for due events and takes each one with
+// a conditional update, skipping events another relay claimed first
+func (d *DynamoDBStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]*Event, error) {
+	now := time.Now()
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(d.table),
+		IndexName:              aws.String(PendingIndex),
+		KeyConditionExpression: aws.String("pending = :pending"),
+		FilterExpression:       aws.String("next_attempt_at <= :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: pendingValue},
+			":now":     nanos(now),
+		},
+	}
+
+	var events []*Event
+	paginator := dynamodb.NewQueryPaginator(d.client, input)
+	for paginator.HasMorePages() && len(events) < limit {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return events, err
+		}
+		for _, item := range page.Items {
+			if len(events) == limit {
+				break
+			}
+			event := decodeEvent(item)
+			_, err := d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+				TableName:           aws.String(d.table),
+				Key:                 eventKey(event.ID),
+				UpdateExpression:    aws.String("SET next_attempt_at = :lease"),
+				ConditionExpression: aws.String("attribute_exists(pending) AND next_attempt_at <= :now"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":lease": nanos(now.Add(lease)),
+					":now":   nanos(now),
+				},
+			})
+			var ccf *types.ConditionalCheckFailedException
+			if errors.As(err, &ccf) {
+				continue
+			}
+			if err != nil {
+				return events, err
+			}
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// MarkSent removes the event from the pending index and lets it expire
+func (d *DynamoDBStore) MarkSent(ctx context.Context, eventID string) error {
+	now := time.Now()
+	_, err := d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(d.table),
+		Key:              eventKey(eventID),
+		UpdateExpression: aws.String("SET sent_at = :now, expires_at = :expires REMOVE pending"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now":     &types.AttributeValueMemberS{Value: now.UTC().Format(timeLayout)},
+			":expires": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(sentRetention).Unix(), 10)},
+		},
+	})
+	return err
+}
+
+// Retry reschedules the event
+func (d *DynamoDBStore) Retry(ctx context.Context, event *Event, next time.Time) error {
+	_, err := d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(d.table),
+		Key:              eventKey(event.ID),
+		UpdateExpression: aws.String("SET attempts = :attempts, last_error = :error, next_attempt_at = :next"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":attempts": &types.AttributeValueMemberN{Value: strconv.Itoa(event.Attempts)},
+			":error":    &types.AttributeValueMemberS{Value: event.LastError},
+			":next":     nanos(next),
+		},
+	})
+	return err
+}
+
+// Lag counts the pending index and reads its oldest entry
+func (d *DynamoDBStore) Lag(ctx context.Context) (Lag, error) {
+	var lag Lag
+	values := map[string]types.AttributeValue{
+		":pending": &types.AttributeValueMemberS{Value: pendingValue},
+	}
+
+	paginator := dynamodb.NewQueryPaginator(d.client, &dynamodb.QueryInput{
+		TableName:                 aws.String(d.table),
+		IndexName:                 aws.String(PendingIndex),
+		KeyConditionExpression:    aws.String("pending = :pending"),
+		ExpressionAttributeValues: values,
+		Select:                    types.SelectCount,
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return lag, err
+		}
+		lag.Pending += int(page.Count)
+	}
+	if lag.Pending == 0 {
+		return lag, nil
+	}
+
+	out, err := d.client.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(d.table),
+		IndexName:                 aws.String(PendingIndex),
+		KeyConditionExpression:    aws.String("pending = :pending"),
+		ExpressionAttributeValues: values,
+		Limit:                     aws.Int32(1),
+	})
+	if err != nil {
+		return lag, err
+	}
+	if len(out.Items) > 0 {
+		lag.Oldest = decodeEvent(out.Items[0]).CreatedAt
+	}
+	return lag, nil
+}
+
+func decodeEvent(item map[string]types.AttributeValue) *Event {
+	event := &Event{Attributes: make(map[string]string)}
+	if v, ok := item["event_id"].(*types.AttributeValueMemberS); ok {
+		event.ID = v.Value
+	}
+	if v, ok := item["order_id"].(*types.AttributeValueMemberS); ok {
+		event.OrderID = v.Value
+	}
+	if v, ok := item["payload"].(*types.AttributeValueMemberS); ok {
+		event.Payload = v.Value
+	}
+	if v, ok := item["attributes"].(*types.AttributeValueMemberM); ok {
+		for k, av := range v.Value {
+			if s, ok := av.(*types.AttributeValueMemberS); ok {
+				event.Attributes[k] = s.Value
+			}
+		}
+	}
+	if v, ok := item["created_at"].(*types.AttributeValueMemberS); ok {
+		event.CreatedAt, _ = time.Parse(timeLayout, v.Value)
+	}
+	if v, ok := item["attempts"].(*types.AttributeValueMemberN); ok {
+		event.Attempts, _ = strconv.Atoi(v.Value)
+	}
+	if v, ok := item["last_error"].(*types.AttributeValueMemberS); ok {
+		event.LastError = v.Value
+	}
+	return event
+}
+
+func eventKey(eventID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"event_id": &types.AttributeValueMemberS{Value: eventID},
+	}
+}
+
+// nanos encodes a time as a number of Unix nanoseconds
+func nanos(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.UnixNano(), 10)}
+}
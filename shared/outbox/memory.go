@@ -0,0 +1,121 @@
+package outbox
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+)
+
+// MemoryStore keeps pending events in process memory next to a memory
+// repository. Sent events are dropped.
+type MemoryStore struct {
+	repo   *repository.Memory
+	mu     sync.Mutex
+	events map[string]*memoryEvent
+}
+
+type memoryEvent struct {
+	event Event
+	next  time.Time
+}
+
+// NewMemoryStore creates an empty outbox for repo
+func NewMemoryStore(repo *repository.Memory) *MemoryStore {
+	return &MemoryStore{repo: repo, events: make(map[string]*memoryEvent)}
+}
+
+// CreateOrder stores the order and queues its event
+func (m *MemoryStore) CreateOrder(ctx context.Context, order *orders.Order, newEvent EventFunc) error {
+	return m.repo.CreateWith(ctx, order, func() error {
+		event, err := newEvent(order)
+		if err != nil {
+			return err
+		}
+
+		return m.Enqueue(ctx, event)
+	})
+}
+
+// UpdateStatus changes the order status and queues event
+func (m *MemoryStore) UpdateStatus(ctx context.Context, orderID string, t orders.Transition, version int64, event *Event) (int64, error) {
+	return m.repo.UpdateStatusWith(ctx, orderID, t, version, func() error {
+		return m.Enqueue(ctx, event)
+	})
+}
+
+// Enqueue queues an event
+func (m *MemoryStore) Enqueue(ctx context.Context, event *Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.events[event.ID] = &memoryEvent{event: *event, next: event.CreatedAt}
+	return nil
+}
+
+// Claim returns due events, oldest first
+func (m *MemoryStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]*Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var due []*memoryEvent
+	for _, e := range m.events {
+		if !e.next.After(now) {
+			due = append(due, e)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].event.CreatedAt.Before(due[j].event.CreatedAt)
+	})
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+
+	claimed := make([]*Event, 0, len(due))
+	for _, e := range due {
+		e.next = now.Add(lease)
+		c := e.event
+		claimed = append(claimed, &c)
+	}
+	return claimed, nil
+}
+
+// MarkSent drops the event
+func (m *MemoryStore) MarkSent(ctx context.Context, eventID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.events, eventID)
+	return nil
+}
+
+// Retry reschedules the event
+func (m *MemoryStore) Retry(ctx context.Context, event *Event, next time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.events[event.ID]; ok {
+		e.event.Attempts = event.Attempts
+		e.event.LastError = event.LastError
+		e.next = next
+	}
+	return nil
+}
+
+// Lag counts the pending events
+func (m *MemoryStore) Lag(ctx context.Context) (Lag, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lag := Lag{Pending: len(m.events)}
+	for _, e := range m.events {
+		if lag.Oldest.IsZero() || e.event.CreatedAt.Before(lag.Oldest) {
+			lag.Oldest = e.event.CreatedAt
+		}
+	}
+	return lag, nil
+}
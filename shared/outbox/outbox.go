@@ -0,0 +1,110 @@
+// Package outbox stores events together with the order that produced them
+// and relays them to the message bus in the background, so an accepted
+// order is never lost to a failed or interrupted publish.
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/google/uuid"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+)
+
+// Event is a message waiting to be published
+type Event struct {
+	ID      string
+	OrderID string
+	// Payload is the message body
+	Payload string
+	// Attributes become message attributes
+	Attributes map[string]string
+	CreatedAt  time.Time
+	// Attempts counts failed publish attempts
+	Attempts  int
+	LastError string
+}
+
+// NewEvent creates an event with a fresh ID
+func NewEvent(orderID, payload string, attributes map[string]string) *Event {
+	return &Event{
+		ID:         uuid.New().String(),
+		OrderID:    orderID,
+		Payload:    payload,
+		Attributes: attributes,
+		CreatedAt:  time.Now(),
+	}
+}
+
+// EventFunc builds the event for an order once the repository has set its
+// version
+type EventFunc func(order *orders.Order) (*Event, error)
+
+// Lag describes the events still waiting to be published
+type Lag struct {
+	Pending int
+	// Oldest is the creation time of the oldest pending event, zero if
+	// none is pending
+	Oldest time.Time
+}
+
+// Age returns how long the oldest pending event has been waiting
+func (l Lag) Age(now time.Time) time.Duration {
+	if l.Oldest.IsZero() {
+		return 0
+	}
+	return now.Sub(l.Oldest)
+}
+
+// Store keeps the outbox next to the orders
+type Store interface {
+	// CreateOrder stores the order as repository.OrderRepository.Create
+	// does, together with the event built by newEvent, in one transaction
+	CreateOrder(ctx context.Context, order *orders.Order, newEvent EventFunc) error
+	// UpdateStatus applies t as repository.OrderRepository.UpdateStatus
+	// does, together with event, in one transaction
+	UpdateStatus(ctx context.Context, orderID string, t orders.Transition, version int64, event *Event) (int64, error)
+	// Enqueue adds an event that is not written together with an order
+	Enqueue(ctx context.Context, event *Event) error
+	// Claim returns up to limit due events, oldest first, and hides them
+	// from other relays until lease passes
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]*Event, error)
+	// MarkSent records that the event was published
+	MarkSent(ctx context.Context, eventID string) error
+	// Retry stores the event's Attempts and LastError and schedules the
+	// next attempt at next
+	Retry(ctx context.Context, event *Event, next time.Time) error
+	// Lag reports the pending events
+	Lag(ctx context.Context) (Lag, error)
+}
+
+// NewStoreFromEnv creates the outbox for repo in the same backend, which
+// is what makes writing the order and its event atomic. The DynamoDB outbox
+// lives in the table OUTBOX_TABLE (default "order-outbox").
+func NewStoreFromEnv(ctx context.Context, repo repository.OrderRepository) (Store, error) {
+	switch r := repo.(type) {
+	case *repository.Memory:
+		return NewMemoryStore(r), nil
+
+	case *repository.SQLite:
+		return NewSQLiteStore(ctx, r)
+
+	case *repository.DynamoDB:
+		table := os.Getenv("OUTBOX_TABLE")
+		if table == "" {
+			table = "order-outbox"
+		}
+		awsCfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("load AWS config: %w", err)
+		}
+		return NewDynamoDBStore(r, repository.NewDynamoDBClient(awsCfg), table), nil
+
+	default:
+		return nil, fmt.Errorf("no outbox for order repository %T", repo)
+	}
+}
@@ -0,0 +1,172 @@
+package outbox
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+)
+
+// stores returns an empty outbox of every backend that runs without AWS
+func stores(t *testing.T) map[string]Store {
+	t.Helper()
+	ctx := context.Background()
+	repo, err := repository.OpenSQLite(ctx, filepath.Join(t.TempDir(), "orders.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	sqlite, err := NewSQLiteStore(ctx, repo)
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	return map[string]Store{
+		"memory": NewMemoryStore(repository.NewMemory()),
+		"sqlite": sqlite,
+	}
+}
+
+func TestClaim(t *testing.T) {
+	const lease = 50 * time.Millisecond
+
+	// Each case starts with events e1, e2 and e3, oldest first, and runs
+	// prepare before claiming up to two events
+	tests := []struct {
+		name    string
+		prepare func(ctx context.Context, t *testing.T, s Store)
+		want    []string
+	}{
+		{
+			name:    "oldest first",
+			prepare: func(ctx context.Context, t *testing.T, s Store) {},
+			want:    []string{"e1", "e2"},
+		},
+		{
+			name: "claimed events are hidden",
+			prepare: func(ctx context.Context, t *testing.T, s Store) {
+				mustClaim(ctx, t, s, 2, time.Minute)
+			},
+			want: []string{"e3"},
+		},
+		{
+			name: "all claimed",
+			prepare: func(ctx context.Context, t *testing.T, s Store) {
+				mustClaim(ctx, t, s, 3, time.Minute)
+			},
+			want: nil,
+		},
+		{
+			name: "lease lapsed",
+			prepare: func(ctx context.Context, t *testing.T, s Store) {
+				mustClaim(ctx, t, s, 3, lease)
+				time.Sleep(2 * lease)
+			},
+			want: []string{"e1", "e2"},
+		},
+		{
+			name: "sent events are not claimed again",
+			prepare: func(ctx context.Context, t *testing.T, s Store) {
+				mustClaim(ctx, t, s, 3, lease)
+				for _, id := range []string{"e1", "e3"} {
+					if err := s.MarkSent(ctx, id); err != nil {
+						t.Fatal(err)
+					}
+				}
+				time.Sleep(2 * lease)
+			},
+			want: []string{"e2"},
+		},
+		{
+			name: "retry scheduled later",
+			prepare: func(ctx context.Context, t *testing.T, s Store) {
+				events := mustClaim(ctx, t, s, 1, lease)
+				events[0].Attempts = 1
+				events[0].LastError = "publish failed"
+				if err := s.Retry(ctx, events[0], time.Now().Add(time.Minute)); err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(2 * lease)
+			},
+			want: []string{"e2", "e3"},
+		},
+	}
+	for _, name := range []string{"memory", "sqlite"} {
+		t.Run(name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					ctx := context.Background()
+					s := stores(t)[name]
+					enqueue(ctx, t, s, "e1", "e2", "e3")
+					tt.prepare(ctx, t, s)
+
+					var got []string
+					for _, event := range mustClaim(ctx, t, s, 2, time.Minute) {
+						got = append(got, event.ID)
+					}
+					if !reflect.DeepEqual(got, tt.want) {
+						t.Errorf("Claim = %v, want %v", got, tt.want)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestMarkSentLag(t *testing.T) {
+	for name, s := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			enqueue(ctx, t, s, "e1", "e2")
+			for _, event := range mustClaim(ctx, t, s, 2, time.Minute) {
+				if event.Payload != `{"event":"`+event.ID+`"}` || event.Attributes["orderId"] != "o-"+event.ID {
+					t.Errorf("claimed %+v", event)
+				}
+			}
+
+			lag, err := s.Lag(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lag.Pending != 2 {
+				t.Errorf("Pending = %d before MarkSent, want 2", lag.Pending)
+			}
+
+			if err := s.MarkSent(ctx, "e1"); err != nil {
+				t.Fatal(err)
+			}
+			lag, err = s.Lag(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lag.Pending != 1 {
+				t.Errorf("Pending = %d after MarkSent, want 1", lag.Pending)
+			}
+		})
+	}
+}
+
+// enqueue adds an event per ID, each a second younger than the one before
+func enqueue(ctx context.Context, t *testing.T, s Store, ids ...string) {
+	t.Helper()
+	created := time.Now().Add(-time.Duration(len(ids)) * time.Second)
+	for i, id := range ids {
+		event := NewEvent("o-"+id, `{"event":"`+id+`"}`, map[string]string{"orderId": "o-" + id})
+		event.ID = id
+		event.CreatedAt = created.Add(time.Duration(i) * time.Second)
+		if err := s.Enqueue(ctx, event); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+}
+
+func mustClaim(ctx context.Context, t *testing.T, s Store, limit int, lease time.Duration) []*Event {
+	t.Helper()
+	events, err := s.Claim(ctx, limit, lease)
+	if err != nil {
+		t.Fatalf("Claim: %v", err)
+	}
+	return events
+}
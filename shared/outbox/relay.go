@@ -0,0 +1,192 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// PublishFunc sends an event to the message bus
+type PublishFunc func(ctx context.Context, event *Event) error
+
+// Config holds relay settings
+type Config struct {
+	// PollInterval is how often the outbox is checked when no new event
+	// was signalled
+	PollInterval time.Duration
+	// BatchSize bounds the events claimed, and published concurrently, per
+	// round
+	BatchSize int
+	// Lease hides claimed events from other relays while they publish
+	Lease time.Duration
+	// MinBackoff and MaxBackoff bound the exponential delay between
+	// attempts for one event
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// ConfigFromEnv reads OUTBOX_POLL_INTERVAL (default 1s), OUTBOX_BATCH_SIZE
+// (default 25) and OUTBOX_MAX_BACKOFF (default 5m)
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		PollInterval: time.Second,
+		BatchSize:    25,
+		Lease:        30 * time.Second,
+		MinBackoff:   time.Second,
+		MaxBackoff:   5 * time.Minute,
+	}
+	if v := os.Getenv("OUTBOX_POLL_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid OUTBOX_POLL_INTERVAL %q", v)
+		}
+		cfg.PollInterval = d
+	}
+	if v := os.Getenv("OUTBOX_BATCH_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("invalid OUTBOX_BATCH_SIZE %q", v)
+		}
+		cfg.BatchSize = n
+	}
+	if v := os.Getenv("OUTBOX_MAX_BACKOFF"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < cfg.MinBackoff {
+			return cfg, fmt.Errorf("invalid OUTBOX_MAX_BACKOFF %q", v)
+		}
+		cfg.MaxBackoff = d
+	}
+	return cfg, nil
+}
+
+// Stats describes the relay's progress
+type Stats struct {
+	Lag
+	Published       int64
+	PublishFailures int64
+}
+
+// Relay publishes pending events until they succeed. An event can be
+// published more than once (e.g. if MarkSent fails), so consumers must
+// tolerate duplicates.
+type Relay struct {
+	store   Store
+	publish PublishFunc
+	cfg     Config
+	wake    chan struct{}
+
+	published       int64
+	publishFailures int64
+}
+
+// NewRelay creates a relay for store
+func NewRelay(store Store, publish PublishFunc, cfg Config) *Relay {
+	return &Relay{
+		store:   store,
+		publish: publish,
+		cfg:     cfg,
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+// Notify wakes the relay after an event was stored, so it is published
+// without waiting for the next poll
+func (r *Relay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run publishes events until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		r.drain(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// drain publishes due events batch by batch until none is left
+func (r *Relay) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		events, err := r.store.Claim(ctx, r.cfg.BatchSize, r.cfg.Lease)
+		if err != nil {
+			log.Printf("Failed to read outbox: %v", err)
+			return
+		}
+
+		var wg sync.WaitGroup
+		for _, event := range events {
+			wg.Add(1)
+			go func(event *Event) {
+				defer wg.Done()
+				r.send(ctx, event)
+			}(event)
+		}
+		wg.Wait()
+
+		if len(events) < r.cfg.BatchSize {
+			return
+		}
+	}
+}
+
+// send publishes one event and records the outcome
+func (r *Relay) send(ctx context.Context, event *Event) {
+	err := r.publish(ctx, event)
+	if err == nil {
+		atomic.AddInt64(&r.published, 1)
+		if err := r.store.MarkSent(ctx, event.ID); err != nil {
+			// Published again once the lease passes
+			log.Printf("Failed to mark outbox event %s sent: %v", event.ID, err)
+		}
+		return
+	}
+
+	atomic.AddInt64(&r.publishFailures, 1)
+	event.Attempts++
+	event.LastError = err.Error()
+	delay := r.backoff(event.Attempts)
+	log.Printf("Failed to publish event %s for order %s (attempt %d), retrying in %s: %v",
+		event.ID, event.OrderID, event.Attempts, delay, err)
+
+	if err := r.store.Retry(ctx, event, time.Now().Add(delay)); err != nil {
+		log.Printf("Failed to reschedule outbox event %s: %v", event.ID, err)
+	}
+}
+
+// backoff doubles MinBackoff per failed attempt up to MaxBackoff
+func (r *Relay) backoff(attempts int) time.Duration {
+	delay := r.cfg.MinBackoff
+	for i := 1; i < attempts && delay < r.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.cfg.MaxBackoff {
+		delay = r.cfg.MaxBackoff
+	}
+	return delay
+}
+
+// Stats reports the outbox lag and publish counters
+func (r *Relay) Stats(ctx context.Context) (Stats, error) {
+	lag, err := r.store.Lag(ctx)
+	return Stats{
+		Lag:             lag,
+		Published:       atomic.LoadInt64(&r.published),
+		PublishFailures: atomic.LoadInt64(&r.publishFailures),
+	}, err
+}
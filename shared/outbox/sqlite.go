@@ -0,0 +1,196 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS outbox (
+	event_id        TEXT PRIMARY KEY,
+	order_id        TEXT NOT NULL,
+	payload         TEXT NOT NULL,
+	attributes      TEXT NOT NULL,
+	created_at      INTEGER NOT NULL,
+	attempts        INTEGER NOT NULL DEFAULT 0,
+	last_error      TEXT NOT NULL DEFAULT '',
+	next_attempt_at INTEGER NOT NULL,
+	sent_at         INTEGER
+);
+CREATE INDEX IF NOT EXISTS outbox_pending ON outbox (next_attempt_at) WHERE sent_at IS NULL;
+`
+
+// sentRetention is how long sent events are kept for inspection
+const sentRetention = 24 * time.Hour
+
+// SQLiteStore keeps the outbox in the order repository's database, so the
+// order row and its event are committed in one transaction
+type SQLiteStore struct {
+	repo *repository.SQLite
+	db   *sql.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewSQLiteStore creates (if needed) the outbox table in repo's database
+func NewSQLiteStore(ctx context.Context, repo *repository.SQLite) (*SQLiteStore, error) {
+	db := repo.DB()
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		return nil, fmt.Errorf("create schema: %w", err)
+	}
+	return &SQLiteStore{repo: repo, db: db}, nil
+}
+
+// CreateOrder inserts the order and its event in one transaction
+func (s *SQLiteStore) CreateOrder(ctx context.Context, order *orders.Order, newEvent EventFunc) error {
+	return s.repo.CreateWith(ctx, order, func(tx *sql.Tx) error {
+		event, err := newEvent(order)
+		if err != nil {
+			return err
+		}
+		return insertEvent(ctx, tx, event)
+	})
+}
+
+// UpdateStatus changes the order status and inserts event in one
+// transaction
+func (s *SQLiteStore) UpdateStatus(ctx context.Context, orderID string, t orders.Transition, version int64, event *Event) (int64, error) {
+	return s.repo.UpdateStatusWith(ctx, orderID, t, version, func(tx *sql.Tx) error {
+		return insertEvent(ctx, tx, event)
+	})
+}
+
+// Enqueue inserts an event
+func (s *SQLiteStore) Enqueue(ctx context.Context, event *Event) error {
+	return insertEvent(ctx, s.db, event)
+}
+
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertEvent writes a pending event through db or a transaction
+func insertEvent(ctx context.Context, db execer, event *Event) error {
+	attributes, err := json.Marshal(event.Attributes)
+	if err != nil {
+		return fmt.Errorf("marshal attributes: %w", err)
+	}
+
+	_, err = db.ExecContext(ctx,
+		`INSERT INTO outbox (event_id, order_id, payload, attributes, created_at, next_attempt_at)
+		 VALUES (?, ?, ?, ?, ?, ?)`,
+		event.ID, event.OrderID, event.Payload, string(attributes),
+		event.CreatedAt.UnixNano(), event.CreatedAt.UnixNano())
+	return err
+}
+
+// Claim selects due events and pushes their next attempt past the lease
+func (s *SQLiteStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]*Event, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	rows, err := tx.QueryContext(ctx,
+		`SELECT event_id, order_id, payload, attributes, created_at, attempts, last_error FROM outbox
+		 WHERE sent_at IS NULL AND next_attempt_at <= ? ORDER BY created_at LIMIT ?`,
+		now.UnixNano(), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*Event
+	for rows.Next() {
+		var (
+			event      Event
+			attributes string
+			createdAt  int64
+		)
+		if err := rows.Scan(&event.ID, &event.OrderID, &event.Payload, &attributes,
+			&createdAt, &event.Attempts, &event.LastError); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(attributes), &event.Attributes); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("unmarshal attributes: %w", err)
+		}
+		event.CreatedAt = time.Unix(0, createdAt)
+		events = append(events, &event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, event := range events {
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE outbox SET next_attempt_at = ? WHERE event_id = ?`,
+			now.Add(lease).UnixNano(), event.ID); err != nil {
+			return nil, err
+		}
+	}
+	return events, tx.Commit()
+}
+
+// MarkSent records the send time. Sent events older than a day are
+// deleted at most once a minute.
+func (s *SQLiteStore) MarkSent(ctx context.Context, eventID string) error {
+	now := time.Now()
+	if _, err := s.db.ExecContext(ctx,
+		`UPDATE outbox SET sent_at = ? WHERE event_id = ?`, now.UnixNano(), eventID); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	sweep := now.Sub(s.lastSweep) > time.Minute
+	if sweep {
+		s.lastSweep = now
+	}
+	s.mu.Unlock()
+
+	if sweep {
+		_, err := s.db.ExecContext(ctx,
+			`DELETE FROM outbox WHERE sent_at IS NOT NULL AND sent_at < ?`,
+			now.Add(-sentRetention).UnixNano())
+		return err
+	}
+	return nil
+}
+
+// Retry reschedules the event
+func (s *SQLiteStore) Retry(ctx context.Context, event *Event, next time.Time) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE outbox SET attempts = ?, last_error = ?, next_attempt_at = ? WHERE event_id = ?`,
+		event.Attempts, event.LastError, next.UnixNano(), event.ID)
+	return err
+}
+
+// Lag counts the unsent events
+func (s *SQLiteStore) Lag(ctx context.Context) (Lag, error) {
+	var (
+		lag    Lag
+		oldest sql.NullInt64
+	)
+	err := s.db.QueryRowContext(ctx,
+		`SELECT COUNT(*), MIN(created_at) FROM outbox WHERE sent_at IS NULL`).
+		Scan(&lag.Pending, &oldest)
+	if err != nil {
+		return lag, err
+	}
+	if oldest.Valid {
+		lag.Oldest = time.Unix(0, oldest.Int64)
+	}
+	return lag, nil
+}
@@ -0,0 +1,124 @@
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/money"
+)
+
+// HTTPGateway talks to a payment provider over a small JSON API:
+//
+//	POST /authorizations              body: Request        → Authorization
+//	POST /authorizations/{id}/capture body: Authorization
+//	POST /authorizations/{id}/void    body: Authorization
+//	POST /authorizations/{id}/refund  body: {"amount": {"amount": minor, "currency": "USD"}}
+//
+// Refunds carry an Idempotency-Key header so the provider returns the
+// money once however often a refund is retried.
+// A 402 response is reported as ErrDeclined, 503 as ErrUnavailable and
+// 504 or a client timeout as ErrTimeout. cmd/payment-stub serves this API
+// locally.
+type HTTPGateway struct {
+	baseURL string
+	client  *http.Client
+}
+
+// NewHTTPGateway creates a gateway for the API at baseURL
+func NewHTTPGateway(baseURL string, timeout time.Duration) *HTTPGateway {
+	return &HTTPGateway{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// idempotencyHeader carries the key of a refund
+const idempotencyHeader = "Idempotency-Key"
+
+// Authorize requests a hold for the payment
+func (g *HTTPGateway) Authorize(ctx context.Context, req Request) (Authorization, error) {
+	var auth Authorization
+	if err := g.post(ctx, "/authorizations", "", req, &auth); err != nil {
+		return Authorization{}, err
+	}
+	if auth.ID == "" {
+		return Authorization{}, fmt.Errorf("payment gateway returned no authorization id")
+	}
+	auth.Request = req
+	return auth, nil
+}
+
+// Capture settles an authorization
+func (g *HTTPGateway) Capture(ctx context.Context, auth Authorization) error {
+	return g.post(ctx, g.authPath(auth, "capture"), "", auth, nil)
+}
+
+// Void releases an authorization
+func (g *HTTPGateway) Void(ctx context.Context, auth Authorization) error {
+	return g.post(ctx, g.authPath(auth, "void"), "", auth, nil)
+}
+
+// Refund returns amount of a captured authorization
+func (g *HTTPGateway) Refund(ctx context.Context, auth Authorization, amount money.Money, idempotencyKey string) error {
+	body := map[string]money.Money{"amount": amount}
+	return g.post(ctx, g.authPath(auth, "refund"), idempotencyKey, body, nil)
+}
+
+func (g *HTTPGateway) authPath(auth Authorization, action string) string {
+	return "/authorizations/" + url.PathEscape(auth.ID) + "/" + action
+}
+
+// post sends body as JSON, with idempotencyKey unless it is empty, and
+// decodes the response into out when non-nil
+func (g *HTTPGateway) post(ctx context.Context, path, idempotencyKey string, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, g.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		httpReq.Header.Set(idempotencyHeader, idempotencyKey)
+	}
+
+	resp, err := g.client.Do(httpReq)
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return fmt.Errorf("%w: %v", ErrTimeout, err)
+		}
+		return fmt.Errorf("payment gateway: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPaymentRequired:
+		return ErrDeclined
+	case http.StatusServiceUnavailable:
+		return ErrUnavailable
+	case http.StatusGatewayTimeout:
+		return ErrTimeout
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("payment gateway: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,208 @@
+package payment
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Latency draws simulated call durations
+type Latency interface {
+	Sample(r *rand.Rand) time.Duration
+	// Expected is the mean duration of a sample
+	Expected() time.Duration
+}
+
+// FixedLatency always takes D
+type FixedLatency struct {
+	D time.Duration
+}
+
+// Sample returns D
+func (l FixedLatency) Sample(*rand.Rand) time.Duration {
+	return l.D
+}
+
+// Expected returns D
+func (l FixedLatency) Expected() time.Duration {
+	return l.D
+}
+
+// UniformLatency is uniformly distributed in [Min, Max]
+type UniformLatency struct {
+	Min, Max time.Duration
+}
+
+// Sample draws a duration between Min and Max
+func (l UniformLatency) Sample(r *rand.Rand) time.Duration {
+	if l.Max <= l.Min {
+		return l.Min
+	}
+	return l.Min + time.Duration(r.Int63n(int64(l.Max-l.Min)+1))
+}
+
+// Expected returns the midpoint of Min and Max
+func (l UniformLatency) Expected() time.Duration {
+	if l.Max <= l.Min {
+		return l.Min
+	}
+	return l.Min + (l.Max-l.Min)/2
+}
+
+// NormalLatency is normally distributed, clipped at zero
+type NormalLatency struct {
+	Mean, StdDev time.Duration
+}
+
+// Sample draws a duration around Mean
+func (l NormalLatency) Sample(r *rand.Rand) time.Duration {
+	d := time.Duration(float64(l.Mean) + r.NormFloat64()*float64(l.StdDev))
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// Expected returns Mean, ignoring the clipping at zero
+func (l NormalLatency) Expected() time.Duration {
+	return max(l.Mean, 0)
+}
+
+// Percentile pins the latency at quantile P (0..1)
+type Percentile struct {
+	P float64
+	D time.Duration
+}
+
+// PercentileLatency models long-tail latency from a few observed
+// percentiles, interpolating linearly between them. Below the first point
+// it interpolates from zero and above the last point it stays at the last
+// duration.
+type PercentileLatency struct {
+	Points []Percentile
+}
+
+// Sample draws a duration from the interpolated distribution
+func (l PercentileLatency) Sample(r *rand.Rand) time.Duration {
+	if len(l.Points) == 0 {
+		return 0
+	}
+
+	u := r.Float64()
+	prev := Percentile{}
+	for _, pt := range l.Points {
+		if u <= pt.P {
+			if pt.P == prev.P {
+				return pt.D
+			}
+			frac := (u - prev.P) / (pt.P - prev.P)
+			return prev.D + time.Duration(frac*float64(pt.D-prev.D))
+		}
+		prev = pt
+	}
+	return prev.D
+}
+
+// Expected integrates the interpolated distribution
+func (l PercentileLatency) Expected() time.Duration {
+	var mean float64
+	prev := Percentile{}
+	for _, pt := range l.Points {
+		mean += (pt.P - prev.P) * float64(prev.D+pt.D) / 2
+		prev = pt
+	}
+	mean += (1 - prev.P) * float64(prev.D)
+	return time.Duration(mean)
+}
+
+// ParseLatency parses a latency spec:
+//
+//	fixed:3s
+//	uniform:1s,5s
+//	normal:3s,500ms                  (mean, standard deviation)
+//	percentiles:p50=1s,p99=8s,p100=20s
+//
+// A bare duration such as "3s" is shorthand for fixed.
+func ParseLatency(spec string) (Latency, error) {
+	kind, args, found := strings.Cut(spec, ":")
+	if !found {
+		d, err := time.ParseDuration(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid latency %q: %w", spec, err)
+		}
+		return FixedLatency{D: d}, nil
+	}
+
+	switch kind {
+	case "fixed":
+		d, err := time.ParseDuration(args)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fixed latency: %w", err)
+		}
+		return FixedLatency{D: d}, nil
+
+	case "uniform":
+		ds, err := parseDurations(args, 2)
+		if err != nil {
+			return nil, fmt.Errorf("invalid uniform latency: %w", err)
+		}
+		if ds[1] < ds[0] {
+			return nil, fmt.Errorf("invalid uniform latency: max %s below min %s", ds[1], ds[0])
+		}
+		return UniformLatency{Min: ds[0], Max: ds[1]}, nil
+
+	case "normal":
+		ds, err := parseDurations(args, 2)
+		if err != nil {
+			return nil, fmt.Errorf("invalid normal latency: %w", err)
+		}
+		return NormalLatency{Mean: ds[0], StdDev: ds[1]}, nil
+
+	case "percentiles":
+		var points []Percentile
+		for _, part := range strings.Split(args, ",") {
+			name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+			if !ok || !strings.HasPrefix(name, "p") {
+				return nil, fmt.Errorf("invalid percentile %q, want pNN=duration", part)
+			}
+			p, err := strconv.ParseFloat(name[1:], 64)
+			if err != nil || p < 0 || p > 100 {
+				return nil, fmt.Errorf("invalid percentile %q", name)
+			}
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid percentile %s: %w", name, err)
+			}
+			points = append(points, Percentile{P: p / 100, D: d})
+		}
+		sort.Slice(points, func(i, j int) bool { return points[i].P < points[j].P })
+		for i := 1; i < len(points); i++ {
+			if points[i].D < points[i-1].D {
+				return nil, fmt.Errorf("invalid percentiles: latency must not decrease")
+			}
+		}
+		return PercentileLatency{Points: points}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown latency distribution %q", kind)
+	}
+}
+
+func parseDurations(s string, n int) ([]time.Duration, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, fmt.Errorf("want %d durations, got %q", n, s)
+	}
+	ds := make([]time.Duration, n)
+	for i, part := range parts {
+		d, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		ds[i] = d
+	}
+	return ds, nil
+}
@@ -0,0 +1,241 @@
+// Package payment contains the payment gateway used by every service.
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/money"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+)
+
+// Errors reported by gateways
+var (
+	// ErrDeclined is returned when the gateway refuses a payment
+	ErrDeclined = errors.New("payment declined")
+	// ErrUnavailable is returned when the gateway fails to process a call
+	ErrUnavailable = errors.New("payment gateway unavailable")
+	// ErrTimeout is returned when the gateway does not answer in time
+	ErrTimeout = errors.New("payment gateway timeout")
+)
+
+// Request describes a payment for an order
+type Request struct {
+	OrderID    string      `json:"order_id"`
+	CustomerID int         `json:"customer_id"`
+	Amount     money.Money `json:"amount"`
+}
+
+// NewRequest builds the payment request for the amount due on an order.
+// It fails if the order total cannot be computed.
+func NewRequest(order *orders.Order) (Request, error) {
+	total, err := order.AmountDue()
+	if err != nil {
+		return Request{}, err
+	}
+	return Request{
+		OrderID:    order.OrderID,
+		CustomerID: order.CustomerID,
+		Amount:     total,
+	}, nil
+}
+
+// Authorization is a hold placed on the customer's funds
+type Authorization struct {
+	ID string `json:"id"`
+	Request
+}
+
+// Gateway is implemented by payment providers
+type Gateway interface {
+	// Authorize places a hold for the request amount
+	Authorize(ctx context.Context, req Request) (Authorization, error)
+	// Capture settles a previous authorization
+	Capture(ctx context.Context, auth Authorization) error
+	// Void releases an authorization that was not captured
+	Void(ctx context.Context, auth Authorization) error
+	// Refund returns amount of a captured authorization to the customer.
+	// Calls with the same idempotencyKey refund at most once.
+	Refund(ctx context.Context, auth Authorization, amount money.Money, idempotencyKey string) error
+}
+
+// RefundKey is the idempotency key of the refund of an order. An order is
+// refunded in full at most once, so retrying its refund reuses the key.
+func RefundKey(orderID string) string {
+	return "refund-" + orderID
+}
+
+// Charge authorizes and captures a payment, voiding the authorization
+// if the capture fails
+func Charge(ctx context.Context, gw Gateway, req Request) (Authorization, error) {
+	auth, err := gw.Authorize(ctx, req)
+	if err != nil {
+		return Authorization{}, fmt.Errorf("authorize: %w", err)
+	}
+
+	if err := gw.Capture(ctx, auth); err != nil {
+		if voidErr := gw.Void(ctx, auth); voidErr != nil {
+			log.Printf("Failed to void authorization %s for order %s: %v", auth.ID, req.OrderID, voidErr)
+		}
+		return Authorization{}, fmt.Errorf("capture: %w", err)
+	}
+
+	return auth, nil
+}
+
+// ChargeOrder charges the order total
+func ChargeOrder(ctx context.Context, gw Gateway, order *orders.Order) (Authorization, error) {
+	req, err := NewRequest(order)
+	if err != nil {
+		return Authorization{}, err
+	}
+	return Charge(ctx, gw, req)
+}
+
+// ExpectedChargeTime estimates how long ChargeOrder takes with gw. It
+// reports false if the gateway's latency is not known, as for the HTTP
+// gateway.
+func ExpectedChargeTime(gw Gateway) (time.Duration, bool) {
+	sim, ok := gw.(*SimulatedGateway)
+	if !ok {
+		return 0, false
+	}
+	return sim.ExpectedChargeTime(), true
+}
+
+// NewGatewayFromEnv selects the gateway with PAYMENT_GATEWAY
+// ("simulated" by default, or "http"). The simulated gateway is configured
+// by SimulatedConfigFromEnv; the HTTP gateway needs PAYMENT_GATEWAY_URL and
+// honours PAYMENT_TIMEOUT.
+func NewGatewayFromEnv(defaults SimulatedConfig) (Gateway, error) {
+	switch kind := os.Getenv("PAYMENT_GATEWAY"); kind {
+	case "", "simulated":
+		cfg, err := SimulatedConfigFromEnv(defaults)
+		if err != nil {
+			return nil, err
+		}
+		return NewSimulatedGateway(cfg), nil
+
+	case "http":
+		baseURL := os.Getenv("PAYMENT_GATEWAY_URL")
+		if baseURL == "" {
+			return nil, errors.New("PAYMENT_GATEWAY_URL environment variable not set")
+		}
+		timeout := 10 * time.Second
+		if v := os.Getenv("PAYMENT_TIMEOUT"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid PAYMENT_TIMEOUT: %w", err)
+			}
+			timeout = d
+		}
+		return NewHTTPGateway(baseURL, timeout), nil
+
+	default:
+		return nil, fmt.Errorf("unknown PAYMENT_GATEWAY %q", kind)
+	}
+}
+
+// SimulatedConfigFromEnv applies the simulation variables on top of
+// defaults:
+//
+//	PAYMENT_DELAY              fixed authorization delay, e.g. 3s
+//	PAYMENT_LATENCY            authorization latency spec, see ParseLatency
+//	PAYMENT_MAX_CONCURRENT     concurrent call limit
+//	PAYMENT_ERROR_RATE         fraction of calls failing, 0..1
+//	PAYMENT_TIMEOUT_RATE       fraction of calls timing out, 0..1
+//	PAYMENT_TIMEOUT            how long a timed-out call hangs
+//	PAYMENT_DECLINE_CUSTOMERS  comma-separated customer IDs to decline
+//	PAYMENT_DECLINE_OVER       decline amounts above this value ("500" or
+//	                           "500 EUR"; other currencies are not limited)
+//	PAYMENT_SEED               random seed for reproducible runs
+func SimulatedConfigFromEnv(defaults SimulatedConfig) (SimulatedConfig, error) {
+	cfg := defaults
+	if v := os.Getenv("PAYMENT_DELAY"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid PAYMENT_DELAY: %w", err)
+		}
+		cfg.AuthorizeLatency = FixedLatency{D: d}
+	}
+	if v := os.Getenv("PAYMENT_LATENCY"); v != "" {
+		l, err := ParseLatency(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid PAYMENT_LATENCY: %w", err)
+		}
+		cfg.AuthorizeLatency = l
+	}
+	if v := os.Getenv("PAYMENT_MAX_CONCURRENT"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid PAYMENT_MAX_CONCURRENT: %w", err)
+		}
+		cfg.MaxConcurrent = n
+	}
+	if v := os.Getenv("PAYMENT_ERROR_RATE"); v != "" {
+		rate, err := parseRate(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid PAYMENT_ERROR_RATE: %w", err)
+		}
+		cfg.ErrorRate = rate
+	}
+	if v := os.Getenv("PAYMENT_TIMEOUT_RATE"); v != "" {
+		rate, err := parseRate(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid PAYMENT_TIMEOUT_RATE: %w", err)
+		}
+		cfg.TimeoutRate = rate
+	}
+	if cfg.ErrorRate+cfg.TimeoutRate > 1 {
+		return cfg, errors.New("PAYMENT_ERROR_RATE and PAYMENT_TIMEOUT_RATE add up to more than 1")
+	}
+	if v := os.Getenv("PAYMENT_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid PAYMENT_TIMEOUT: %w", err)
+		}
+		cfg.Timeout = d
+	}
+	if v := os.Getenv("PAYMENT_DECLINE_CUSTOMERS"); v != "" {
+		cfg.DeclineCustomers = make(map[int]bool)
+		for _, id := range strings.Split(v, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(id))
+			if err != nil {
+				return cfg, fmt.Errorf("invalid PAYMENT_DECLINE_CUSTOMERS: %w", err)
+			}
+			cfg.DeclineCustomers[n] = true
+		}
+	}
+	if v := os.Getenv("PAYMENT_DECLINE_OVER"); v != "" {
+		amount, err := money.ParseString(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid PAYMENT_DECLINE_OVER: %w", err)
+		}
+		cfg.DeclineOver = amount
+	}
+	if v := os.Getenv("PAYMENT_SEED"); v != "" {
+		seed, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid PAYMENT_SEED: %w", err)
+		}
+		cfg.Seed = seed
+	}
+	return cfg, nil
+}
+
+func parseRate(s string) (float64, error) {
+	rate, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("rate %v outside 0..1", rate)
+	}
+	return rate, nil
+}
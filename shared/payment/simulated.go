@@ -0,0 +1,206 @@
+package payment
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/money"
+)
+
+// SimulatedConfig configures the simulated gateway
+type SimulatedConfig struct {
+	// AuthorizeLatency simulates payment verification
+	AuthorizeLatency Latency
+	// CaptureLatency, VoidLatency and RefundLatency simulate the other
+	// calls, nil means instant
+	CaptureLatency Latency
+	VoidLatency    Latency
+	RefundLatency  Latency
+	// MaxConcurrent limits how many calls run at once, 0 means unlimited
+	MaxConcurrent int
+
+	// ErrorRate is the fraction of calls failing with ErrUnavailable
+	ErrorRate float64
+	// TimeoutRate is the fraction of calls that hang for Timeout and
+	// then fail with ErrTimeout
+	TimeoutRate float64
+	Timeout     time.Duration
+
+	// DeclineCustomers are always declined
+	DeclineCustomers map[int]bool
+	// DeclineOver declines authorizations in its currency above this
+	// amount, zero disables
+	DeclineOver money.Money
+
+	// Seed makes the simulation reproducible, 0 picks a random seed
+	Seed int64
+}
+
+// DefaultSimulatedConfig reproduces the original 3-second verification
+func DefaultSimulatedConfig() SimulatedConfig {
+	return SimulatedConfig{
+		AuthorizeLatency: FixedLatency{D: 3 * time.Second},
+		Timeout:          30 * time.Second,
+	}
+}
+
+// SimulatedGateway approves payments after a simulated delay, failing a
+// configurable share of them
+type SimulatedGateway struct {
+	cfg SimulatedConfig
+	// Buffered channel to limit concurrent payment processing,
+	// nil means no limit
+	semaphore chan struct{}
+
+	// rand.Rand is not safe for concurrent use
+	mu  sync.Mutex
+	rnd *rand.Rand
+	// refunded holds the idempotency keys of completed refunds
+	refunded map[string]bool
+}
+
+// NewSimulatedGateway creates a simulated gateway
+func NewSimulatedGateway(cfg SimulatedConfig) *SimulatedGateway {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	g := &SimulatedGateway{
+		cfg:      cfg,
+		rnd:      rand.New(rand.NewSource(seed)),
+		refunded: make(map[string]bool),
+	}
+	if cfg.MaxConcurrent > 0 {
+		g.semaphore = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	return g
+}
+
+// Authorize simulates payment verification
+func (g *SimulatedGateway) Authorize(ctx context.Context, req Request) (Authorization, error) {
+	log.Printf("Processing payment for order %s (%s)...", req.OrderID, req.Amount)
+	if err := g.call(ctx, g.cfg.AuthorizeLatency); err != nil {
+		return Authorization{}, err
+	}
+
+	if g.cfg.DeclineCustomers[req.CustomerID] {
+		return Authorization{}, fmt.Errorf("%w: customer %d", ErrDeclined, req.CustomerID)
+	}
+	if !g.cfg.DeclineOver.IsZero() {
+		if cmp, err := req.Amount.Cmp(g.cfg.DeclineOver); err == nil && cmp > 0 {
+			return Authorization{}, fmt.Errorf("%w: amount %s over limit", ErrDeclined, req.Amount)
+		}
+	}
+
+	log.Printf("Payment authorized for order %s", req.OrderID)
+	return Authorization{ID: uuid.New().String(), Request: req}, nil
+}
+
+// Capture simulates settling an authorization
+func (g *SimulatedGateway) Capture(ctx context.Context, auth Authorization) error {
+	if err := g.call(ctx, g.cfg.CaptureLatency); err != nil {
+		return err
+	}
+	log.Printf("Payment processed for order %s", auth.OrderID)
+	return nil
+}
+
+// Void simulates releasing an authorization
+func (g *SimulatedGateway) Void(ctx context.Context, auth Authorization) error {
+	if err := g.call(ctx, g.cfg.VoidLatency); err != nil {
+		return err
+	}
+	log.Printf("Authorization %s voided for order %s", auth.ID, auth.OrderID)
+	return nil
+}
+
+// Refund simulates returning money to the customer. A repeated
+// idempotencyKey succeeds without refunding again.
+func (g *SimulatedGateway) Refund(ctx context.Context, auth Authorization, amount money.Money, idempotencyKey string) error {
+	if err := g.call(ctx, g.cfg.RefundLatency); err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if idempotencyKey != "" && g.refunded[idempotencyKey] {
+		log.Printf("Refund %s for order %s already made", idempotencyKey, auth.OrderID)
+		return nil
+	}
+	if idempotencyKey != "" {
+		g.refunded[idempotencyKey] = true
+	}
+	log.Printf("Refunded %s for order %s", amount, auth.OrderID)
+	return nil
+}
+
+// ExpectedChargeTime estimates the mean duration of Charge: authorize and
+// capture, or the timeout for the share of calls that time out
+func (g *SimulatedGateway) ExpectedChargeTime() time.Duration {
+	d := expected(g.cfg.AuthorizeLatency) + expected(g.cfg.CaptureLatency)
+	return time.Duration((1-g.cfg.TimeoutRate)*float64(d) + g.cfg.TimeoutRate*float64(g.cfg.Timeout))
+}
+
+// expected is the mean of l, zero for an instant call
+func expected(l Latency) time.Duration {
+	if l == nil {
+		return 0
+	}
+	return l.Expected()
+}
+
+// call holds a concurrency slot for a sampled latency and injects the
+// configured errors and timeouts
+func (g *SimulatedGateway) call(ctx context.Context, latency Latency) error {
+	if g.semaphore != nil {
+		// Acquire semaphore (blocks while the limit is reached)
+		select {
+		case g.semaphore <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() { <-g.semaphore }()
+	}
+
+	g.mu.Lock()
+	var d time.Duration
+	if latency != nil {
+		d = latency.Sample(g.rnd)
+	}
+	roll := g.rnd.Float64()
+	g.mu.Unlock()
+
+	switch {
+	case roll < g.cfg.TimeoutRate:
+		if err := sleep(ctx, g.cfg.Timeout); err != nil {
+			return err
+		}
+		return ErrTimeout
+	case roll < g.cfg.TimeoutRate+g.cfg.ErrorRate:
+		if err := sleep(ctx, d); err != nil {
+			return err
+		}
+		return ErrUnavailable
+	}
+	return sleep(ctx, d)
+}
+
+// sleep waits for d, returning early if ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,120 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// DynamoDBRedemptions counts redemptions in a table keyed by code, using
+// conditional updates so concurrent receivers never exceed a limit. The use
+// each order holds is an item of the same table keyed by holdKey, written
+// in one transaction with the count.
+type DynamoDBRedemptions struct {
+	client *dynamodb.Client
+	table  string
+}
+
+// NewDynamoDBRedemptions creates a store on an existing table
+func NewDynamoDBRedemptions(client *dynamodb.Client, table string) *DynamoDBRedemptions {
+	return &DynamoDBRedemptions{client: client, table: table}
+}
+
+// Redeem records the hold of orderID and increments the count of code
+// unless it has reached limit
+func (d *DynamoDBRedemptions) Redeem(ctx context.Context, code, orderID string, limit int) error {
+	_, err := d.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Put: &types.Put{
+					TableName:           aws.String(d.table),
+					Item:                key(holdKey(code, orderID)),
+					ConditionExpression: aws.String("attribute_not_exists(code)"),
+				},
+			},
+			{
+				Update: &types.Update{
+					TableName:           aws.String(d.table),
+					Key:                 key(code),
+					UpdateExpression:    aws.String("ADD redeemed :one"),
+					ConditionExpression: aws.String("attribute_not_exists(redeemed) OR redeemed < :limit"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":one":   number(1),
+						":limit": number(limit),
+					},
+				},
+			},
+		},
+	})
+
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) {
+		return err
+	}
+	for i, reason := range canceled.CancellationReasons {
+		if aws.ToString(reason.Code) != "ConditionalCheckFailed" {
+			continue
+		}
+		if i == 0 {
+			// Already redeemed for this order
+			return nil
+		}
+		return ErrPromoExhausted
+	}
+	return err
+}
+
+// Release deletes the hold of orderID and decrements the count of code
+func (d *DynamoDBRedemptions) Release(ctx context.Context, code, orderID string) error {
+	_, err := d.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Delete: &types.Delete{
+					TableName:           aws.String(d.table),
+					Key:                 key(holdKey(code, orderID)),
+					ConditionExpression: aws.String("attribute_exists(code)"),
+				},
+			},
+			{
+				Update: &types.Update{
+					TableName:           aws.String(d.table),
+					Key:                 key(code),
+					UpdateExpression:    aws.String("ADD redeemed :minus"),
+					ConditionExpression: aws.String("redeemed > :zero"),
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":minus": number(-1),
+						":zero":  number(0),
+					},
+				},
+			},
+		},
+	})
+
+	// The order holds no use of code, or it was given back already
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 0 &&
+		aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+		return nil
+	}
+	return err
+}
+
+// holdKey is the key of the item recording that orderID holds a use of
+// code, kept apart from the counters by its prefix
+func holdKey(code, orderID string) string {
+	return "HOLD#" + code + "#" + orderID
+}
+
+func key(code string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"code": &types.AttributeValueMemberS{Value: code},
+	}
+}
+
+func number(n int) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.Itoa(n)}
+}
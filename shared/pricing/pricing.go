@@ -0,0 +1,414 @@
+// Package pricing computes what an order costs: line totals, promo code
+// discounts and tax by region.
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/money"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+)
+
+// Errors wrapped by Error
+var (
+	ErrUnknownPromo     = errors.New("unknown promo code")
+	ErrPromoExhausted   = errors.New("promo code has no redemptions left")
+	ErrPromoNotEligible = errors.New("order is not eligible for the promo code")
+	ErrUnknownRegion    = errors.New("unknown tax region")
+)
+
+// Error is a pricing failure caused by an order field, reported to the
+// client rather than treated as a server error
+type Error struct {
+	// Field is the JSON name of the order field, e.g. "promo_code"
+	Field   string
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Promo types
+const (
+	PromoPercentage = "percentage"
+	PromoFixed      = "fixed"
+	PromoBuyXGetY   = "buy_x_get_y"
+)
+
+// Promo is a promo code definition
+type Promo struct {
+	Code string `json:"code"`
+	Type string `json:"type"`
+	// Percent off the subtotal, for percentage promos (1-100)
+	Percent int `json:"percent,omitempty"`
+	// Amount off the subtotal for fixed promos, e.g. "5.00 USD"
+	Amount string `json:"amount,omitempty"`
+	// For buy_x_get_y: every Buy units of ProductID (any product if
+	// empty) add Get free units
+	ProductID string `json:"product_id,omitempty"`
+	Buy       int    `json:"buy,omitempty"`
+	Get       int    `json:"get,omitempty"`
+	// MaxRedemptions limits how many orders may use the code; 0 means
+	// unlimited
+	MaxRedemptions int `json:"max_redemptions,omitempty"`
+
+	amount money.Money
+}
+
+// TaxRule is the tax rate of a region
+type TaxRule struct {
+	Region string `json:"region"`
+	// RateBasisPoints is the rate in hundredths of a percent (725 = 7.25%)
+	RateBasisPoints int64 `json:"rate_bps"`
+	// Exempt lists product ID prefixes that are not taxed
+	Exempt []string `json:"exempt,omitempty"`
+}
+
+// Config holds the promo codes and tax table
+type Config struct {
+	Promos     []Promo   `json:"promos"`
+	TaxRegions []TaxRule `json:"tax_regions"`
+	// DefaultRegion is taxed when an order has no region; empty means
+	// such orders are not taxed
+	DefaultRegion string `json:"default_region,omitempty"`
+}
+
+// DefaultConfig has a few flash sale promo codes and common tax rates.
+// Orders without a region are not taxed.
+func DefaultConfig() Config {
+	return Config{
+		Promos: []Promo{
+			{Code: "FLASH10", Type: PromoPercentage, Percent: 10, MaxRedemptions: 1000},
+			{Code: "SAVE5", Type: PromoFixed, Amount: "5.00 USD"},
+			{Code: "BUY2GET1", Type: PromoBuyXGetY, Buy: 2, Get: 1},
+		},
+		TaxRegions: []TaxRule{
+			{Region: "US-CA", RateBasisPoints: 725},
+			{Region: "US-NY", RateBasisPoints: 400},
+			{Region: "US-TX", RateBasisPoints: 625},
+			{Region: "US-OR", RateBasisPoints: 0},
+			{Region: "GB", RateBasisPoints: 2000},
+			{Region: "DE", RateBasisPoints: 1900},
+			{Region: "FR", RateBasisPoints: 2000},
+			{Region: "JP", RateBasisPoints: 1000},
+		},
+	}
+}
+
+// LoadConfig reads a JSON config file
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Engine prices orders
+type Engine struct {
+	promos        map[string]Promo
+	taxes         map[string]TaxRule
+	defaultRegion string
+	redemptions   RedemptionStore
+}
+
+// NewEngine checks cfg and creates an engine that counts limited promo
+// redemptions in redemptions
+func NewEngine(cfg Config, redemptions RedemptionStore) (*Engine, error) {
+	e := &Engine{
+		promos:        make(map[string]Promo, len(cfg.Promos)),
+		taxes:         make(map[string]TaxRule, len(cfg.TaxRegions)),
+		defaultRegion: strings.ToUpper(cfg.DefaultRegion),
+		redemptions:   redemptions,
+	}
+
+	for _, p := range cfg.Promos {
+		p.Code = strings.ToUpper(p.Code)
+		switch p.Type {
+		case PromoPercentage:
+			if p.Percent < 1 || p.Percent > 100 {
+				return nil, fmt.Errorf("promo %s: percent must be 1-100", p.Code)
+			}
+		case PromoFixed:
+			amount, err := money.ParseString(p.Amount)
+			if err != nil || !amount.IsPositive() {
+				return nil, fmt.Errorf("promo %s: invalid amount %q", p.Code, p.Amount)
+			}
+			p.amount = amount
+		case PromoBuyXGetY:
+			if p.Buy < 1 || p.Get < 1 {
+				return nil, fmt.Errorf("promo %s: buy and get must be at least 1", p.Code)
+			}
+		default:
+			return nil, fmt.Errorf("promo %s: unknown type %q", p.Code, p.Type)
+		}
+		if _, ok := e.promos[p.Code]; ok {
+			return nil, fmt.Errorf("promo %s defined twice", p.Code)
+		}
+		e.promos[p.Code] = p
+	}
+
+	for _, t := range cfg.TaxRegions {
+		t.Region = strings.ToUpper(t.Region)
+		if t.RateBasisPoints < 0 || t.RateBasisPoints > 10000 {
+			return nil, fmt.Errorf("tax region %s: rate must be 0-10000 basis points", t.Region)
+		}
+		e.taxes[t.Region] = t
+	}
+	if _, ok := e.taxes[e.defaultRegion]; e.defaultRegion != "" && !ok {
+		return nil, fmt.Errorf("default region %s has no tax rule", e.defaultRegion)
+	}
+	return e, nil
+}
+
+// NewEngineFromEnv loads the config file named by PRICING_CONFIG (the
+// built-in DefaultConfig if unset) and the redemption store selected by
+// PROMO_STORE
+func NewEngineFromEnv(ctx context.Context) (*Engine, error) {
+	cfg := DefaultConfig()
+	if path := os.Getenv("PRICING_CONFIG"); path != "" {
+		var err error
+		if cfg, err = LoadConfig(path); err != nil {
+			return nil, err
+		}
+	}
+
+	redemptions, err := NewRedemptionStoreFromEnv(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewEngine(cfg, redemptions)
+}
+
+// Quote computes the pricing of order without redeeming its promo code
+func (e *Engine) Quote(order *orders.Order) (*orders.Pricing, error) {
+	currency := order.Currency()
+	zero := money.New(0, currency)
+
+	p := &orders.Pricing{
+		Lines:    make([]orders.LinePrice, len(order.Items)),
+		Subtotal: zero,
+		Discount: zero,
+		Tax:      zero,
+	}
+	for i, item := range order.Items {
+		if item.Price.Currency != currency {
+			return nil, fmt.Errorf("item %d: %w: %s and %s", i, money.ErrCurrencyMismatch, item.Price.Currency, currency)
+		}
+		p.Lines[i] = orders.LinePrice{
+			ProductID: item.ProductID,
+			Quantity:  item.Quantity,
+			UnitPrice: item.Price,
+			Subtotal:  item.Total(),
+			Discount:  zero,
+			Tax:       zero,
+		}
+		p.Subtotal.Amount += p.Lines[i].Subtotal.Amount
+	}
+
+	if order.PromoCode != "" {
+		if err := e.applyPromo(p, strings.ToUpper(order.PromoCode)); err != nil {
+			return nil, err
+		}
+	}
+
+	region := strings.ToUpper(order.Region)
+	if region == "" {
+		region = e.defaultRegion
+	}
+	if region != "" {
+		rule, ok := e.taxes[region]
+		if !ok {
+			return nil, &Error{Field: "region", Message: fmt.Sprintf("no tax rule for region %q", order.Region), Err: ErrUnknownRegion}
+		}
+		p.TaxRegion = region
+		for i := range p.Lines {
+			line := &p.Lines[i]
+			if rule.exempts(line.ProductID) {
+				continue
+			}
+			line.Tax.Amount = percentOf(line.Subtotal.Amount-line.Discount.Amount, rule.RateBasisPoints, 10000)
+			p.Tax.Amount += line.Tax.Amount
+		}
+	}
+
+	for i := range p.Lines {
+		line := &p.Lines[i]
+		line.Total.Currency = currency
+		line.Total.Amount = line.Subtotal.Amount - line.Discount.Amount + line.Tax.Amount
+	}
+	p.Total = money.New(p.Subtotal.Amount-p.Discount.Amount+p.Tax.Amount, currency)
+	return p, nil
+}
+
+// Price quotes order, redeems its promo code and stores the result in
+// order.Pricing. Promo codes with a redemption limit fail with
+// ErrPromoExhausted once it is reached.
+func (e *Engine) Price(ctx context.Context, order *orders.Order) (*orders.Pricing, error) {
+	p, err := e.Quote(order)
+	if err != nil {
+		return nil, err
+	}
+
+	if order.PromoCode != "" {
+		order.PromoCode = strings.ToUpper(order.PromoCode)
+		if promo := e.promos[order.PromoCode]; promo.MaxRedemptions > 0 {
+			if err := e.redemptions.Redeem(ctx, promo.Code, order.OrderID, promo.MaxRedemptions); err != nil {
+				if errors.Is(err, ErrPromoExhausted) {
+					return nil, &Error{Field: "promo_code", Message: err.Error(), Err: err}
+				}
+				return nil, fmt.Errorf("redeem promo %s: %w", promo.Code, err)
+			}
+		}
+	}
+
+	order.Pricing = p
+	return p, nil
+}
+
+// Release gives back the promo redemption of an order that was priced but
+// not accepted, or that was cancelled or failed for good. Releasing an
+// order twice gives back one use.
+func (e *Engine) Release(ctx context.Context, order *orders.Order) error {
+	if order.Pricing == nil || order.PromoCode == "" {
+		return nil
+	}
+	if promo := e.promos[order.PromoCode]; promo.MaxRedemptions > 0 {
+		return e.redemptions.Release(ctx, promo.Code, order.OrderID)
+	}
+	return nil
+}
+
+// applyPromo spreads the promo discount over the lines of p
+func (e *Engine) applyPromo(p *orders.Pricing, code string) error {
+	promo, ok := e.promos[code]
+	if !ok {
+		return &Error{Field: "promo_code", Message: ErrUnknownPromo.Error(), Err: ErrUnknownPromo}
+	}
+
+	currency := p.Subtotal.Currency
+	discounts := make([]int64, len(p.Lines))
+	var description string
+
+	switch promo.Type {
+	case PromoPercentage:
+		description = fmt.Sprintf("%d%% off", promo.Percent)
+		total := percentOf(p.Subtotal.Amount, int64(promo.Percent), 100)
+		discounts = allocate(total, p.Lines)
+
+	case PromoFixed:
+		if promo.amount.Currency != currency {
+			return &Error{
+				Field:   "promo_code",
+				Message: fmt.Sprintf("only applies to %s orders", promo.amount.Currency),
+				Err:     ErrPromoNotEligible,
+			}
+		}
+		description = promo.amount.String() + " off"
+		total := promo.amount.Amount
+		if total > p.Subtotal.Amount {
+			total = p.Subtotal.Amount
+		}
+		discounts = allocate(total, p.Lines)
+
+	case PromoBuyXGetY:
+		description = fmt.Sprintf("buy %d get %d free", promo.Buy, promo.Get)
+		eligible := false
+		for i, line := range p.Lines {
+			if promo.ProductID != "" && line.ProductID != promo.ProductID {
+				continue
+			}
+			free := int64(line.Quantity/(promo.Buy+promo.Get)) * int64(promo.Get)
+			if free > 0 {
+				eligible = true
+				discounts[i] = free * line.UnitPrice.Amount
+			}
+		}
+		if !eligible {
+			product := "any product"
+			if promo.ProductID != "" {
+				product = promo.ProductID
+			}
+			return &Error{
+				Field:   "promo_code",
+				Message: fmt.Sprintf("requires at least %d units of %s", promo.Buy+promo.Get, product),
+				Err:     ErrPromoNotEligible,
+			}
+		}
+	}
+
+	discount := money.New(0, currency)
+	for i, d := range discounts {
+		p.Lines[i].Discount.Amount = d
+		discount.Amount += d
+	}
+	p.Discount = discount
+	p.Discounts = []orders.Discount{{Code: promo.Code, Description: description, Amount: discount}}
+	return nil
+}
+
+func (t TaxRule) exempts(productID string) bool {
+	for _, prefix := range t.Exempt {
+		if strings.HasPrefix(productID, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// percentOf returns amount * num / den rounded half up
+func percentOf(amount, num, den int64) int64 {
+	return (amount*num + den/2) / den
+}
+
+// allocate splits total over lines in proportion to their subtotals. The
+// remainder left by rounding down goes to the lines with the largest
+// fractional shares, so the parts add up to total exactly.
+func allocate(total int64, lines []orders.LinePrice) []int64 {
+	parts := make([]int64, len(lines))
+	var sum int64
+	for _, line := range lines {
+		sum += line.Subtotal.Amount
+	}
+	if sum == 0 || total == 0 {
+		return parts
+	}
+
+	// total * subtotal can overflow int64 for large orders
+	remainders := make([]int64, len(lines))
+	left := total
+	var share, rem big.Int
+	for i, line := range lines {
+		share.Mul(big.NewInt(total), big.NewInt(line.Subtotal.Amount))
+		share.QuoRem(&share, big.NewInt(sum), &rem)
+		parts[i], remainders[i] = share.Int64(), rem.Int64()
+		left -= parts[i]
+	}
+	for ; left > 0; left-- {
+		best := 0
+		for i := range remainders {
+			if remainders[i] > remainders[best] {
+				best = i
+			}
+		}
+		parts[best]++
+		remainders[best] = -1
+	}
+	return parts
+}
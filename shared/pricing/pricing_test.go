@@ -0,0 +1,217 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/money"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+)
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		name      string
+		total     int64
+		subtotals []int64
+		want      []int64
+	}{
+		{name: "even split", total: 90, subtotals: []int64{100, 100, 100}, want: []int64{30, 30, 30}},
+		{name: "proportional", total: 30, subtotals: []int64{100, 200}, want: []int64{10, 20}},
+		{name: "remainder to first of equal shares", total: 100, subtotals: []int64{1, 1, 1}, want: []int64{34, 33, 33}},
+		{name: "remainder to largest fractions", total: 5, subtotals: []int64{1, 2, 3}, want: []int64{1, 2, 2}},
+		{name: "small line gets nothing", total: 100, subtotals: []int64{999, 1}, want: []int64{100, 0}},
+		{name: "zero total", total: 0, subtotals: []int64{100, 200}, want: []int64{0, 0}},
+		{name: "zero subtotals", total: 10, subtotals: []int64{0, 0}, want: []int64{0, 0}},
+		{name: "no overflow", total: 1e15, subtotals: []int64{1e15, 1e15}, want: []int64{5e14, 5e14}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := make([]orders.LinePrice, len(tt.subtotals))
+			for i, s := range tt.subtotals {
+				lines[i].Subtotal = money.New(s, "USD")
+			}
+			if got := allocate(tt.total, lines); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("allocate(%d, %v) = %v, want %v", tt.total, tt.subtotals, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPercentOf(t *testing.T) {
+	tests := []struct {
+		amount, num, den int64
+		want             int64
+	}{
+		{amount: 1005, num: 10, den: 100, want: 101},
+		{amount: 1004, num: 10, den: 100, want: 100},
+		{amount: 1000, num: 725, den: 10000, want: 73},
+		{amount: 899, num: 725, den: 10000, want: 65},
+		{amount: 0, num: 725, den: 10000, want: 0},
+	}
+	for _, tt := range tests {
+		if got := percentOf(tt.amount, tt.num, tt.den); got != tt.want {
+			t.Errorf("percentOf(%d, %d, %d) = %d, want %d", tt.amount, tt.num, tt.den, got, tt.want)
+		}
+	}
+}
+
+func item(productID string, quantity int, amount int64, currency string) orders.Item {
+	return orders.Item{ProductID: productID, Quantity: quantity, Price: money.New(amount, currency)}
+}
+
+func TestQuote(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TaxRegions = append(cfg.TaxRegions, TaxRule{Region: "US-WA", RateBasisPoints: 650, Exempt: []string{"FOOD-"}})
+	engine, err := NewEngine(cfg, NewMemoryRedemptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		order    orders.Order
+		discount int64
+		tax      int64
+		total    int64
+		// lineDiscounts is checked when set
+		lineDiscounts []int64
+		err           error
+	}{
+		{
+			name:  "no promo or region",
+			order: orders.Order{Items: []orders.Item{item("p", 3, 333, "USD")}},
+			total: 999,
+		},
+		{
+			name:          "percentage spread over lines",
+			order:         orders.Order{PromoCode: "flash10", Items: []orders.Item{item("p", 3, 333, "USD"), item("q", 1, 1, "USD")}},
+			discount:      100,
+			lineDiscounts: []int64{100, 0},
+			total:         900,
+		},
+		{
+			name:     "tax after discount, per line",
+			order:    orders.Order{PromoCode: "FLASH10", Region: "us-ca", Items: []orders.Item{item("p", 3, 333, "USD"), item("q", 1, 1, "USD")}},
+			discount: 100,
+			tax:      65,
+			total:    965,
+		},
+		{
+			name:     "fixed capped at subtotal",
+			order:    orders.Order{PromoCode: "SAVE5", Items: []orders.Item{item("p", 1, 300, "USD")}},
+			discount: 300,
+			total:    0,
+		},
+		{
+			name:  "fixed in another currency",
+			order: orders.Order{PromoCode: "SAVE5", Items: []orders.Item{item("p", 1, 300, "EUR")}},
+			err:   ErrPromoNotEligible,
+		},
+		{
+			name:          "buy two get one",
+			order:         orders.Order{PromoCode: "BUY2GET1", Items: []orders.Item{item("p", 3, 200, "USD"), item("q", 2, 500, "USD")}},
+			discount:      200,
+			lineDiscounts: []int64{200, 0},
+			total:         1400,
+		},
+		{
+			name:  "buy two get one, too few units",
+			order: orders.Order{PromoCode: "BUY2GET1", Items: []orders.Item{item("p", 2, 200, "USD")}},
+			err:   ErrPromoNotEligible,
+		},
+		{
+			name:  "zero-decimal currency",
+			order: orders.Order{Region: "JP", Items: []orders.Item{item("p", 1, 999, "JPY")}},
+			tax:   100,
+			total: 1099,
+		},
+		{
+			name:  "exempt product",
+			order: orders.Order{Region: "US-WA", Items: []orders.Item{item("FOOD-1", 1, 1000, "USD"), item("p", 1, 1000, "USD")}},
+			tax:   65,
+			total: 2065,
+		},
+		{
+			name:  "unknown promo",
+			order: orders.Order{PromoCode: "FREE", Items: []orders.Item{item("p", 1, 100, "USD")}},
+			err:   ErrUnknownPromo,
+		},
+		{
+			name:  "unknown region",
+			order: orders.Order{Region: "XX", Items: []orders.Item{item("p", 1, 100, "USD")}},
+			err:   ErrUnknownRegion,
+		},
+		{
+			name:  "mixed currencies",
+			order: orders.Order{Items: []orders.Item{item("p", 1, 100, "USD"), item("q", 1, 100, "EUR")}},
+			err:   money.ErrCurrencyMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := engine.Quote(&tt.order)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Quote = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if p.Discount.Amount != tt.discount || p.Tax.Amount != tt.tax || p.Total.Amount != tt.total {
+				t.Errorf("discount %d, tax %d, total %d; want %d, %d, %d",
+					p.Discount.Amount, p.Tax.Amount, p.Total.Amount, tt.discount, tt.tax, tt.total)
+			}
+
+			// The lines add up to the order
+			var discount, tax, total int64
+			var lineDiscounts []int64
+			for _, line := range p.Lines {
+				if line.Total.Amount != line.Subtotal.Amount-line.Discount.Amount+line.Tax.Amount {
+					t.Errorf("line %s total %d does not add up", line.ProductID, line.Total.Amount)
+				}
+				discount += line.Discount.Amount
+				tax += line.Tax.Amount
+				total += line.Total.Amount
+				lineDiscounts = append(lineDiscounts, line.Discount.Amount)
+			}
+			if discount != p.Discount.Amount || tax != p.Tax.Amount || total != p.Total.Amount {
+				t.Errorf("lines sum to discount %d, tax %d, total %d", discount, tax, total)
+			}
+			if tt.lineDiscounts != nil && !reflect.DeepEqual(lineDiscounts, tt.lineDiscounts) {
+				t.Errorf("line discounts = %v, want %v", lineDiscounts, tt.lineDiscounts)
+			}
+		})
+	}
+}
+
+func TestPriceRedemptionLimit(t *testing.T) {
+	cfg := Config{Promos: []Promo{{Code: "ONCE", Type: PromoPercentage, Percent: 50, MaxRedemptions: 1}}}
+	engine, err := NewEngine(cfg, NewMemoryRedemptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	newOrder := func(id string) *orders.Order {
+		return &orders.Order{OrderID: id, PromoCode: "once", Items: []orders.Item{item("p", 1, 100, "USD")}}
+	}
+
+	first := newOrder("o-1")
+	if _, err := engine.Price(ctx, first); err != nil {
+		t.Fatalf("Price o-1: %v", err)
+	}
+	// Pricing the same order again does not use up another redemption
+	if _, err := engine.Price(ctx, newOrder("o-1")); err != nil {
+		t.Fatalf("Price o-1 again: %v", err)
+	}
+	if _, err := engine.Price(ctx, newOrder("o-2")); !errors.Is(err, ErrPromoExhausted) {
+		t.Fatalf("Price o-2 = %v, want ErrPromoExhausted", err)
+	}
+
+	if err := engine.Release(ctx, first); err != nil {
+		t.Fatalf("Release: %v", err)
+	}
+	if _, err := engine.Price(ctx, newOrder("o-2")); err != nil {
+		t.Fatalf("Price o-2 after release: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package pricing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+)
+
+// RedemptionStore counts redemptions of limited promo codes. It is shared
+// by every receiver and processor instance so a limit holds across the
+// fleet. Each use is held for an order, so redeeming or releasing the same
+// order twice counts once.
+type RedemptionStore interface {
+	// Redeem counts one use of code for orderID, failing with
+	// ErrPromoExhausted if limit uses have been counted already
+	Redeem(ctx context.Context, code, orderID string, limit int) error
+	// Release gives back the use of code held by orderID, if any
+	Release(ctx context.Context, code, orderID string) error
+}
+
+// NewRedemptionStoreFromEnv selects the store with PROMO_STORE, defaulting
+// to the ORDER_STORE backend:
+//
+//	memory    in-process (default)
+//	sqlite    SQLITE_PATH (default "orders.db")
+//	dynamodb  table PROMO_TABLE (default "promo-redemptions")
+func NewRedemptionStoreFromEnv(ctx context.Context) (RedemptionStore, error) {
+	kind := os.Getenv("PROMO_STORE")
+	if kind == "" {
+		kind = os.Getenv("ORDER_STORE")
+	}
+
+	switch kind {
+	case "", "memory":
+		return NewMemoryRedemptions(), nil
+
+	case "sqlite":
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "orders.db"
+		}
+		return OpenSQLiteRedemptions(ctx, path)
+
+	case "dynamodb":
+		table := os.Getenv("PROMO_TABLE")
+		if table == "" {
+			table = "promo-redemptions"
+		}
+		awsCfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("load AWS config: %w", err)
+		}
+		return NewDynamoDBRedemptions(repository.NewDynamoDBClient(awsCfg), table), nil
+
+	default:
+		return nil, fmt.Errorf("unknown PROMO_STORE %q", kind)
+	}
+}
+
+// MemoryRedemptions counts redemptions in process memory
+type MemoryRedemptions struct {
+	mu    sync.Mutex
+	holds map[string]map[string]bool // code -> order IDs holding a use
+}
+
+// NewMemoryRedemptions creates an empty store
+func NewMemoryRedemptions() *MemoryRedemptions {
+	return &MemoryRedemptions{holds: make(map[string]map[string]bool)}
+}
+
+// Redeem counts one use of code for orderID if it is below limit
+func (m *MemoryRedemptions) Redeem(ctx context.Context, code, orderID string, limit int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	holders := m.holds[code]
+	if holders[orderID] {
+		return nil
+	}
+	if len(holders) >= limit {
+		return ErrPromoExhausted
+	}
+	if holders == nil {
+		holders = make(map[string]bool)
+		m.holds[code] = holders
+	}
+	holders[orderID] = true
+	return nil
+}
+
+// Release gives back the use of code held by orderID
+func (m *MemoryRedemptions) Release(ctx context.Context, code, orderID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.holds[code], orderID)
+	return nil
+}
@@ -0,0 +1,102 @@
+package pricing
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS promo_redemptions (
+	code     TEXT PRIMARY KEY,
+	redeemed INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS promo_holds (
+	code     TEXT NOT NULL,
+	order_id TEXT NOT NULL,
+	PRIMARY KEY (code, order_id)
+);
+`
+
+// SQLiteRedemptions counts redemptions in an embedded database, usually
+// the same file as the SQLite order repository
+type SQLiteRedemptions struct {
+	db *sql.DB
+}
+
+// OpenSQLiteRedemptions opens (creating if needed) the store at path
+func OpenSQLiteRedemptions(ctx context.Context, path string) (*SQLiteRedemptions, error) {
+	db, err := repository.OpenSQLiteDB(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create schema: %w", err)
+	}
+	return &SQLiteRedemptions{db: db}, nil
+}
+
+// Redeem records the hold of orderID and counts one use of code with a
+// conditional upsert, in one transaction
+func (s *SQLiteRedemptions) Redeem(ctx context.Context, code, orderID string, limit int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`INSERT INTO promo_holds (code, order_id) VALUES (?, ?) ON CONFLICT DO NOTHING`, code, orderID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		// Already redeemed for this order
+		return nil
+	}
+
+	result, err = tx.ExecContext(ctx, `
+		INSERT INTO promo_redemptions (code, redeemed) VALUES (?, 1)
+		ON CONFLICT (code) DO UPDATE SET redeemed = redeemed + 1 WHERE redeemed < ?`,
+		code, limit)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrPromoExhausted
+	}
+	return tx.Commit()
+}
+
+// Release deletes the hold of orderID and gives back its use of code
+func (s *SQLiteRedemptions) Release(ctx context.Context, code, orderID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`DELETE FROM promo_holds WHERE code = ? AND order_id = ?`, code, orderID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE promo_redemptions SET redeemed = redeemed - 1 WHERE code = ? AND redeemed > 0`, code); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,760 @@
+// Package processor charges queued orders: it receives OrderCreated
+// events from a MessageConsumer, claims each order in the ledger and runs
+// its payment. The ECS order processor runs it against SQS; the order
+// receivers run it against the in-process broker when MESSAGE_BROKER is
+// memory.
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/event"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/inventory"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/ledger"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/messaging"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/payment"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/pricing"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/repository"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/retry"
+)
+
+// actor names this service in order status history
+const actor = "order-processor"
+
+// Stats tracks processing metrics
+type Stats struct {
+	mu                sync.Mutex
+	messagesReceived  int64
+	messagesProcessed int64
+	messagesFailed    int64
+	// Redelivered or duplicated orders acknowledged without charging
+	messagesDuplicate int64
+	// Orders failed because their stock hold lapsed and the product sold out
+	messagesSoldOut int64
+	// Orders cancelled before their payment started
+	messagesCancelled int64
+	// Messages given up because their visibility could not be extended
+	messagesAbandoned int64
+	// Failed messages scheduled for another attempt
+	messagesRetried int64
+	// Failed messages sent or left for the dead-letter queue
+	messagesGivenUp int64
+	// Messages handed back unprocessed during shutdown
+	messagesReleased int64
+	startTime        time.Time
+}
+
+// OrderProcessor handles queued order messages and payment processing
+type OrderProcessor struct {
+	consumer       messaging.MessageConsumer
+	queueConfig    messaging.QueueConfig
+	acker          *messaging.Acker
+	deadLetters    messaging.EventPublisher // nil without DLQ_URL
+	unwrapper      *messaging.Unwrapper
+	retryPolicy    retry.Policy
+	workerCount    int
+	paymentGateway payment.Gateway
+	repo           repository.OrderRepository
+	ledger         ledger.Store
+	ledgerConfig   ledger.Config
+	inventory      inventory.Store
+	reservationTTL time.Duration
+	pricer         *pricing.Engine
+	stats          *Stats
+	activeWorkers  int32
+	// slots bounds the messages received and not yet processed across all
+	// workers; its capacity is the in-flight limit
+	slots      chan struct{}
+	inFlight   int32
+	processing sync.WaitGroup
+	// drainTimeout bounds how long messages in flight may finish after
+	// shutdown starts; stopping is closed when it starts
+	drainTimeout time.Duration
+	stopping     <-chan struct{}
+}
+
+// Config holds what an OrderProcessor consumes from and the
+// stores and services it charges orders with
+type Config struct {
+	Consumer messaging.MessageConsumer
+	Queue    messaging.QueueConfig
+	Acker    *messaging.Acker
+	// DeadLetters receives messages that can never succeed; nil leaves
+	// them to the queue's redrive policy
+	DeadLetters messaging.EventPublisher
+	Unwrapper   *messaging.Unwrapper
+	Retry       retry.Policy
+
+	// Workers poll the queue; MaxInFlight bounds the messages all of them
+	// process at once
+	Workers     int
+	MaxInFlight int
+	// DrainTimeout bounds how long messages in flight may finish after
+	// shutdown starts
+	DrainTimeout time.Duration
+
+	Payment        payment.Gateway
+	Orders         repository.OrderRepository
+	Ledger         ledger.Store
+	LedgerConfig   ledger.Config
+	Inventory      inventory.Store
+	ReservationTTL time.Duration
+	Pricer         *pricing.Engine
+}
+
+// ConfigFromEnv reads WORKER_COUNT (default 1), MAX_IN_FLIGHT
+// (default 10, one full batch) and SHUTDOWN_DRAIN_TIMEOUT (default 25s;
+// ECS kills the task 30 seconds after SIGTERM). Invalid worker and
+// in-flight counts fall back to the defaults.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Workers:      1,
+		MaxInFlight:  10,
+		DrainTimeout: 25 * time.Second,
+	}
+	if v := os.Getenv("WORKER_COUNT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.Workers = n
+		}
+	}
+	if v := os.Getenv("MAX_IN_FLIGHT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			cfg.MaxInFlight = n
+		}
+	}
+	if v := os.Getenv("SHUTDOWN_DRAIN_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid SHUTDOWN_DRAIN_TIMEOUT %q", v)
+		}
+		cfg.DrainTimeout = d
+	}
+	return cfg, nil
+}
+
+// Validate checks that a ledger claim outlives the message it was made
+// for. A claim that lapsed while heartbeats still hide the message would
+// let a redelivery or a duplicate copy charge the order again.
+func (cfg Config) Validate() error {
+	if cfg.LedgerConfig.Lease < cfg.Queue.MaxHidden() {
+		return fmt.Errorf("LEDGER_LEASE %s must be at least VISIBILITY_MAX_LIFETIME plus the visibility timeout (%s)",
+			cfg.LedgerConfig.Lease, cfg.Queue.MaxHidden())
+	}
+	return nil
+}
+
+// New creates a processor from cfg
+func New(cfg Config) *OrderProcessor {
+	return &OrderProcessor{
+		consumer:       cfg.Consumer,
+		queueConfig:    cfg.Queue,
+		acker:          cfg.Acker,
+		deadLetters:    cfg.DeadLetters,
+		unwrapper:      cfg.Unwrapper,
+		retryPolicy:    cfg.Retry,
+		workerCount:    cfg.Workers,
+		slots:          make(chan struct{}, cfg.MaxInFlight),
+		drainTimeout:   cfg.DrainTimeout,
+		paymentGateway: cfg.Payment,
+		repo:           cfg.Orders,
+		ledger:         cfg.Ledger,
+		ledgerConfig:   cfg.LedgerConfig,
+		inventory:      cfg.Inventory,
+		reservationTTL: cfg.ReservationTTL,
+		pricer:         cfg.Pricer,
+		stats: &Stats{
+			startTime: time.Now(),
+		},
+	}
+}
+
+// ProcessMessage handles a single queued message while heartbeat keeps it
+// hidden from other consumers
+func (p *OrderProcessor) ProcessMessage(ctx context.Context, message messaging.Message, heartbeat *messaging.Heartbeat) {
+	atomic.AddInt64(&p.stats.messagesReceived, 1)
+
+	// Remove the SNS envelope, if the subscription does not use raw
+	// delivery, after checking its signature
+	delivery, err := p.unwrapper.Unwrap(ctx, message)
+	if err != nil {
+		log.Printf("Rejecting message %s: %v", message.ID, err)
+		p.retryLater(ctx, message, heartbeat, err)
+		return
+	}
+
+	// Decode the envelope or CloudEvent, upcasting payloads of older
+	// producers; binary-mode CloudEvents keep their attributes in the
+	// message attributes
+	envelope, err := event.DecodeMessage([]byte(delivery.Body), delivery.Attributes)
+	if errors.Is(err, event.ErrUnknownType) || (err == nil && envelope.Type != event.OrderCreated) {
+		// Refunds and other order events share the topic; only new orders
+		// are charged
+		log.Printf("Skipping %s event %s", envelope.Type, envelope.ID)
+		p.deleteMessage(message)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to decode event: %v", err)
+		p.retryLater(ctx, message, heartbeat, err)
+		return
+	}
+
+	// Parse the actual order
+	parsed, err := envelope.Order()
+	if err != nil {
+		log.Printf("Failed to parse order: %v", err)
+		p.retryLater(ctx, message, heartbeat, err)
+		return
+	}
+	order := *parsed
+	if p.abandoned(message, heartbeat) {
+		return
+	}
+	if p.draining() {
+		// Another task can start the order at once; orders already claimed
+		// are finished before the processor exits
+		log.Printf("Shutting down, releasing order %s", order.OrderID)
+		p.release(message, heartbeat)
+		return
+	}
+	log.Printf("Processing order %s (event %s, correlation %s, %s message %s, queued %.1fs)",
+		order.OrderID, envelope.ID, envelope.CorrelationID, delivery.Mode, delivery.MessageID,
+		time.Since(delivery.Timestamp).Seconds())
+
+	// Consult the ledger so a redelivered message never charges twice
+	claim, err := p.ledger.Claim(ctx, order.OrderID, p.ledgerConfig.Lease)
+	if err != nil {
+		switch {
+		case errors.Is(err, ledger.ErrAlreadyProcessed):
+			log.Printf("Order %s already processed, acknowledging duplicate message", order.OrderID)
+			atomic.AddInt64(&p.stats.messagesDuplicate, 1)
+			p.deleteMessage(message)
+		case errors.Is(err, ledger.ErrInProgress):
+			p.awaitClaim(ctx, message, heartbeat, &order, err)
+		default:
+			log.Printf("Failed to claim order %s in ledger: %v", order.OrderID, err)
+			p.retryLater(ctx, message, heartbeat, err)
+		}
+		return
+	}
+
+	// The stored version is authoritative; the message copy is stale after
+	// earlier delivery attempts
+	if stored, err := p.repo.Get(ctx, order.OrderID); err == nil {
+		order.Version = stored.Version
+		order.Status = stored.Status
+		order.History = stored.History
+	}
+	if !order.Status.Chargeable() {
+		p.skipPayment(ctx, message, &order)
+		return
+	}
+
+	// Make sure the order still holds its stock; the receiver's hold may
+	// have expired or been released by an earlier failed attempt
+	err = p.inventory.Reserve(ctx, order.OrderID, inventory.Lines(&order), p.reservationTTL)
+	switch {
+	case err == nil, errors.Is(err, inventory.ErrReserved):
+	case errors.Is(err, inventory.ErrSoldOut):
+		log.Printf("Order %s cannot be fulfilled: %v", order.OrderID, err)
+		if order.Status != orders.StatusFailed {
+			p.setStatus(ctx, &order, orders.StatusFailed, "sold out: "+err.Error())
+		}
+		p.releasePromo(ctx, &order)
+		if err := p.ledger.MarkDone(ctx, order.OrderID, p.ledgerConfig.TTL); err != nil {
+			log.Printf("Failed to record order %s in ledger: %v", order.OrderID, err)
+		}
+		p.deleteMessage(message)
+		atomic.AddInt64(&p.stats.messagesSoldOut, 1)
+		atomic.AddInt64(&p.stats.messagesFailed, 1)
+		return
+	default:
+		log.Printf("Failed to reserve stock for order %s: %v", order.OrderID, err)
+		if err := p.ledger.Release(ctx, order.OrderID, claim); err != nil {
+			log.Printf("Failed to release order %s in ledger: %v", order.OrderID, err)
+		}
+		p.retryLater(ctx, message, heartbeat, err)
+		return
+	}
+
+	// Charging after the message became visible again could race with
+	// the consumer that received it next
+	if p.abandoned(message, heartbeat) {
+		if err := p.ledger.Release(ctx, order.OrderID, claim); err != nil {
+			log.Printf("Failed to release order %s in ledger: %v", order.OrderID, err)
+		}
+		return
+	}
+
+	// Process payment
+	startTime := time.Now()
+	if !p.startPayment(ctx, &order) {
+		p.skipPayment(ctx, message, &order)
+		return
+	}
+	auth, err := payment.ChargeOrder(ctx, p.paymentGateway, &order)
+	if err != nil {
+		log.Printf("Payment processing failed for order %s: %v", order.OrderID, err)
+		p.setStatus(ctx, &order, orders.StatusFailed, "payment failed: "+err.Error())
+		if retry.IsTerminal(err) {
+			// A declined payment is the order's outcome, not a failure of
+			// the message
+			p.releaseStock(ctx, &order)
+			p.releasePromo(ctx, &order)
+			if err := p.ledger.MarkDone(ctx, order.OrderID, p.ledgerConfig.TTL); err != nil {
+				log.Printf("Failed to record order %s in ledger: %v", order.OrderID, err)
+			}
+			p.deleteMessage(message)
+			atomic.AddInt64(&p.stats.messagesFailed, 1)
+			return
+		}
+		if err := p.ledger.Release(ctx, order.OrderID, claim); err != nil {
+			log.Printf("Failed to release order %s in ledger: %v", order.OrderID, err)
+		}
+		// The retry charges the order, so it keeps its stock until the
+		// message is given up
+		if p.retryLater(ctx, message, heartbeat, err) {
+			p.releaseStock(ctx, &order)
+			p.releasePromo(ctx, &order)
+		}
+		return
+	}
+	if err := p.ledger.MarkDone(ctx, order.OrderID, p.ledgerConfig.TTL); err != nil {
+		log.Printf("Failed to record order %s in ledger: %v", order.OrderID, err)
+	}
+	if err := p.inventory.Commit(ctx, order.OrderID); err != nil {
+		log.Printf("Failed to commit stock of order %s: %v", order.OrderID, err)
+	}
+	p.setPaymentStatus(ctx, &order, orders.StatusCompleted, "payment captured", auth.ID)
+
+	// Delete message from queue after successful processing
+	p.deleteMessage(message)
+
+	atomic.AddInt64(&p.stats.messagesProcessed, 1)
+	log.Printf("Order %s processed in %.2f seconds", order.OrderID, time.Since(startTime).Seconds())
+}
+
+// deleteMessage acknowledges a message with the next delete batch. If the
+// delete fails the message becomes visible again after the visibility
+// timeout and the ledger absorbs the redelivery.
+func (p *OrderProcessor) deleteMessage(message messaging.Message) {
+	p.acker.Ack(message.ReceiptHandle)
+}
+
+// retryLater stops the heartbeat of a failed message and hides it for the
+// retry policy's backoff. A message that cannot succeed is sent to the
+// dead-letter queue and acknowledged. One that ran out of attempts is made
+// visible at once; its receive count has reached the queue's
+// maxReceiveCount, so the redrive policy moves it on the next receive.
+// It reports whether the message was given up and will not be processed
+// again.
+func (p *OrderProcessor) retryLater(ctx context.Context, message messaging.Message, heartbeat *messaging.Heartbeat, cause error) bool {
+	if ctx.Err() != nil && p.draining() {
+		// Interrupted by the drain deadline, which is no fault of the
+		// message
+		log.Printf("Shutting down, releasing interrupted message %s: %v", message.ID, cause)
+		p.release(message, heartbeat)
+		return false
+	}
+	atomic.AddInt64(&p.stats.messagesFailed, 1)
+	heartbeat.Stop()
+	if heartbeat.Err() != nil {
+		// Another consumer may hold the message by now
+		return false
+	}
+
+	decision := p.retryPolicy.Decide(cause, message.ReceiveCount)
+	if decision.Terminal && p.deadLetter(message, cause) {
+		atomic.AddInt64(&p.stats.messagesGivenUp, 1)
+		return true
+	}
+	switch {
+	case decision.Retry:
+		log.Printf("Retrying message %s (attempt %d/%d) in %s", message.ID, message.ReceiveCount,
+			p.retryPolicy.MaxAttempts, decision.Delay.Round(time.Second))
+		atomic.AddInt64(&p.stats.messagesRetried, 1)
+	case decision.Terminal:
+		// SQS dead-letters a message only once its receive count exceeds
+		// maxReceiveCount, so it is tried again until then
+		log.Printf("Message %s cannot succeed and was not dead-lettered, releasing it: %v", message.ID, cause)
+		atomic.AddInt64(&p.stats.messagesRetried, 1)
+	default:
+		log.Printf("Message %s failed %d times, sending it to the dead-letter queue", message.ID, message.ReceiveCount)
+		atomic.AddInt64(&p.stats.messagesGivenUp, 1)
+	}
+	p.setVisibility(ctx, message, decision.Delay)
+	return !decision.Retry && !decision.Terminal
+}
+
+// awaitClaim hides a message whose order another consumer has claimed
+// until the claim lapses. The claimant may have crashed mid-charge; every
+// receive during its lease would otherwise count towards maxReceiveCount
+// and dead-letter the message while the order is still processing.
+func (p *OrderProcessor) awaitClaim(ctx context.Context, message messaging.Message, heartbeat *messaging.Heartbeat, order *orders.Order, claimErr error) {
+	heartbeat.Stop()
+	if heartbeat.Err() != nil {
+		return
+	}
+	wait, ok := ledger.LeaseRemaining(claimErr)
+	if !ok {
+		wait = p.ledgerConfig.Lease
+	}
+	log.Printf("Order %s is being processed by another consumer, retrying message %s in %s",
+		order.OrderID, message.ID, wait.Round(time.Second))
+	p.setVisibility(ctx, message, wait)
+}
+
+// deadLetter sends message, unchanged, to the dead-letter queue and
+// acknowledges it. It reports false if there is no dead-letter queue or the
+// send failed. Should the acknowledgement fail, the redelivered message is
+// dead-lettered again.
+func (p *OrderProcessor) deadLetter(message messaging.Message, cause error) bool {
+	if p.deadLetters == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.deadLetters.Publish(ctx, message.Body, message.Attributes); err != nil {
+		log.Printf("Failed to send message %s to the dead-letter queue: %v", message.ID, err)
+		return false
+	}
+	log.Printf("Message %s cannot succeed, sent it to the dead-letter queue: %v", message.ID, cause)
+	p.deleteMessage(message)
+	return true
+}
+
+// release makes a message that was not started visible again, so another
+// consumer can receive it without waiting for the visibility timeout
+func (p *OrderProcessor) release(message messaging.Message, heartbeat *messaging.Heartbeat) {
+	heartbeat.Stop()
+	if heartbeat.Err() != nil {
+		return
+	}
+	atomic.AddInt64(&p.stats.messagesReleased, 1)
+	p.setVisibility(context.Background(), message, 0)
+}
+
+// setVisibility hides message for timeout from now. It does not use ctx's
+// cancellation, which may come from the drain deadline.
+func (p *OrderProcessor) setVisibility(ctx context.Context, message messaging.Message, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := p.consumer.ChangeVisibility(ctx, message.ReceiptHandle, timeout); err != nil {
+		log.Printf("Failed to change visibility of message %s: %v", message.ID, err)
+	}
+}
+
+// draining reports whether shutdown has started
+func (p *OrderProcessor) draining() bool {
+	select {
+	case <-p.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// abandoned reports whether the heartbeat of message has stopped, so
+// another consumer may receive it; the message is then left to be
+// redelivered
+func (p *OrderProcessor) abandoned(message messaging.Message, heartbeat *messaging.Heartbeat) bool {
+	err := heartbeat.Err()
+	if err == nil {
+		return false
+	}
+	log.Printf("Abandoning message %s: %v", message.ID, err)
+	atomic.AddInt64(&p.stats.messagesAbandoned, 1)
+	return true
+}
+
+// skipPayment acknowledges the message of an order that can no longer be
+// charged. A cancelled order gives up its stock and promo redemption; one that was already
+// charged, e.g. on an earlier delivery, keeps it.
+func (p *OrderProcessor) skipPayment(ctx context.Context, message messaging.Message, order *orders.Order) {
+	if order.Status == orders.StatusCancelled {
+		log.Printf("Order %s was cancelled, skipping payment", order.OrderID)
+		p.releaseStock(ctx, order)
+		p.releasePromo(ctx, order)
+		atomic.AddInt64(&p.stats.messagesCancelled, 1)
+	} else {
+		log.Printf("Order %s is %q, skipping payment", order.OrderID, order.Status)
+		atomic.AddInt64(&p.stats.messagesDuplicate, 1)
+	}
+	if err := p.ledger.MarkDone(ctx, order.OrderID, p.ledgerConfig.TTL); err != nil {
+		log.Printf("Failed to record order %s in ledger: %v", order.OrderID, err)
+	}
+	p.deleteMessage(message)
+}
+
+// releaseStock gives back the stock held for an order that will not be
+// charged
+func (p *OrderProcessor) releaseStock(ctx context.Context, order *orders.Order) {
+	if err := p.inventory.Release(ctx, order.OrderID); err != nil {
+		log.Printf("Failed to release stock of order %s: %v", order.OrderID, err)
+	}
+}
+
+// releasePromo gives back the promo redemption of an order that failed for
+// good or was cancelled. A retried order keeps it, as it will be charged at
+// the discounted price.
+func (p *OrderProcessor) releasePromo(ctx context.Context, order *orders.Order) {
+	if err := p.pricer.Release(ctx, order); err != nil {
+		log.Printf("Failed to release promo %s of order %s: %v", order.PromoCode, order.OrderID, err)
+	}
+}
+
+// startPayment moves the order to processing, which keeps the receiver
+// from cancelling it. It returns false if the order may not be charged,
+// because the state machine refuses the move or the order changed since
+// it was loaded; other failures are logged and do not stop the payment.
+func (p *OrderProcessor) startPayment(ctx context.Context, order *orders.Order) bool {
+	t, err := order.Transition(orders.StatusProcessing, actor, "payment started")
+	if err != nil {
+		log.Printf("Failed to set order %s to %s: %v", order.OrderID, orders.StatusProcessing, err)
+		return false
+	}
+	version, err := p.repo.UpdateStatus(ctx, order.OrderID, t, order.Version)
+	if errors.Is(err, repository.ErrVersionConflict) {
+		if stored, getErr := p.repo.Get(ctx, order.OrderID); getErr == nil && !stored.Status.Chargeable() {
+			*order = *stored
+			return false
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to set order %s to %s: %v", order.OrderID, orders.StatusProcessing, err)
+		return true
+	}
+	order.Version = version
+	return true
+}
+
+// setStatus records an order status change, using the order's version for
+// optimistic locking. Status tracking is best effort and never blocks
+// payment processing.
+func (p *OrderProcessor) setStatus(ctx context.Context, order *orders.Order, status orders.Status, reason string) {
+	p.setPaymentStatus(ctx, order, status, reason, "")
+}
+
+// setPaymentStatus is setStatus for a change that captures the payment
+// authorization authorizationID
+func (p *OrderProcessor) setPaymentStatus(ctx context.Context, order *orders.Order, status orders.Status, reason, authorizationID string) {
+	t, err := order.PaymentTransition(status, actor, reason, authorizationID)
+	if err != nil {
+		log.Printf("Failed to set order %s to %s: %v", order.OrderID, status, err)
+		return
+	}
+	version, err := p.repo.UpdateStatus(ctx, order.OrderID, t, order.Version)
+	if err != nil {
+		log.Printf("Failed to set order %s to %s: %v", order.OrderID, status, err)
+		return
+	}
+	order.Version = version
+}
+
+// Worker polls the queue for as many messages as there are free
+// processing slots and processes them concurrently under processCtx. It
+// stops polling once ctx is done.
+func (p *OrderProcessor) Worker(ctx, processCtx context.Context, workerID int) {
+	atomic.AddInt32(&p.activeWorkers, 1)
+	defer atomic.AddInt32(&p.activeWorkers, -1)
+
+	log.Printf("Worker %d started", workerID)
+
+	// Consecutive receive failures, for the backoff between polls
+	receiveFailures := 0
+	for {
+		// SQS returns at most 10 messages per call
+		free := p.acquireSlots(ctx, 10)
+		if free == 0 {
+			log.Printf("Worker %d stopping", workerID)
+			return
+		}
+
+		// Poll for messages (long polling)
+		messages, err := p.consumer.Receive(ctx, free)
+		p.releaseSlots(free - len(messages))
+		if err != nil {
+			if ctx.Err() == nil {
+				receiveFailures++
+				delay := p.retryPolicy.Backoff(receiveFailures)
+				log.Printf("Worker %d: Failed to receive messages, polling again in %s: %v",
+					workerID, delay.Round(time.Millisecond), err)
+				select {
+				case <-ctx.Done():
+				case <-time.After(delay):
+				}
+			}
+			continue
+		}
+		receiveFailures = 0
+
+		// Each message holds its slot until it is processed; the heartbeat
+		// starts now because the visibility timeout already runs
+		for _, message := range messages {
+			heartbeat := messaging.StartHeartbeat(processCtx, p.consumer, message, p.queueConfig)
+			atomic.AddInt32(&p.inFlight, 1)
+			p.processing.Add(1)
+			go func(message messaging.Message) {
+				defer p.processing.Done()
+				defer p.releaseSlots(1)
+				defer atomic.AddInt32(&p.inFlight, -1)
+
+				p.ProcessMessage(processCtx, message, heartbeat)
+				heartbeat.Stop()
+			}(message)
+		}
+	}
+}
+
+// acquireSlots waits for a free processing slot, then takes up to limit-1
+// more that are free without waiting. It returns 0 once ctx is done.
+func (p *OrderProcessor) acquireSlots(ctx context.Context, limit int) int {
+	select {
+	case <-ctx.Done():
+		return 0
+	case p.slots <- struct{}{}:
+	}
+	n := 1
+	for n < limit {
+		select {
+		case p.slots <- struct{}{}:
+			n++
+		default:
+			return n
+		}
+	}
+	return n
+}
+
+// releaseSlots frees n processing slots
+func (p *OrderProcessor) releaseSlots(n int) {
+	for i := 0; i < n; i++ {
+		<-p.slots
+	}
+}
+
+// Start begins processing with configured number of workers. When ctx is
+// done the workers stop polling and the messages in flight get up to the
+// drain timeout to finish; those not started by then are released.
+func (p *OrderProcessor) Start(ctx context.Context) {
+	log.Printf("Starting order processor with %d workers, at most %d messages in flight", p.workerCount, cap(p.slots))
+	p.stopping = ctx.Done()
+
+	// Received messages are processed under their own context, so a
+	// shutdown does not interrupt payments; it ends with the drain
+	// deadline
+	processCtx, cancelProcessing := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelProcessing()
+
+	var wg sync.WaitGroup
+
+	// Start worker goroutines
+	for i := 0; i < p.workerCount; i++ {
+		wg.Add(1)
+		go func(workerID int) {
+			defer wg.Done()
+			p.Worker(ctx, processCtx, workerID)
+		}(i)
+	}
+
+	// Start stats reporter and delete batcher; deletes keep being sent
+	// while messages drain
+	go p.ReportStats(ctx)
+	acking := make(chan struct{})
+	go func() {
+		defer close(acking)
+		p.acker.Run(processCtx)
+	}()
+
+	// Wait for all workers, then for the messages they dispatched
+	wg.Wait()
+	log.Printf("All workers stopped, draining %d messages in flight", atomic.LoadInt32(&p.inFlight))
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		p.processing.Wait()
+	}()
+	select {
+	case <-drained:
+	case <-time.After(p.drainTimeout):
+		// Heartbeats stop with processCtx, so unfinished messages are
+		// abandoned and redelivered after the visibility timeout
+		log.Printf("Drain timeout of %s passed, interrupting %d messages", p.drainTimeout, atomic.LoadInt32(&p.inFlight))
+		cancelProcessing()
+		<-drained
+	}
+	cancelProcessing()
+
+	// Send the deletes still waiting for a batch with a fresh context
+	<-acking
+	flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := p.acker.Flush(flushCtx); err != nil {
+		log.Printf("Failed to flush message deletes: %v", err)
+	}
+	p.logStats()
+}
+
+// ReportStats periodically logs processing statistics
+func (p *OrderProcessor) ReportStats(ctx context.Context) {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.logStats()
+		}
+	}
+}
+
+// logStats logs processing statistics
+func (p *OrderProcessor) logStats() {
+	p.stats.mu.Lock()
+	received := atomic.LoadInt64(&p.stats.messagesReceived)
+	processed := atomic.LoadInt64(&p.stats.messagesProcessed)
+	failed := atomic.LoadInt64(&p.stats.messagesFailed)
+	duplicates := atomic.LoadInt64(&p.stats.messagesDuplicate)
+	soldOut := atomic.LoadInt64(&p.stats.messagesSoldOut)
+	cancelled := atomic.LoadInt64(&p.stats.messagesCancelled)
+	abandoned := atomic.LoadInt64(&p.stats.messagesAbandoned)
+	retried := atomic.LoadInt64(&p.stats.messagesRetried)
+	givenUp := atomic.LoadInt64(&p.stats.messagesGivenUp)
+	released := atomic.LoadInt64(&p.stats.messagesReleased)
+	uptime := time.Since(p.stats.startTime)
+	activeWorkers := atomic.LoadInt32(&p.activeWorkers)
+	inFlight := atomic.LoadInt32(&p.inFlight)
+	acks := p.acker.Stats()
+	p.stats.mu.Unlock()
+
+	rate := float64(processed) / uptime.Seconds()
+
+	log.Printf("=== PROCESSOR STATS ===")
+	log.Printf("Uptime: %.0f seconds", uptime.Seconds())
+	log.Printf("Active Workers: %d/%d", activeWorkers, p.workerCount)
+	log.Printf("In Flight: %d/%d", inFlight, cap(p.slots))
+	log.Printf("Messages Received: %d", received)
+	log.Printf("Messages Processed: %d", processed)
+	log.Printf("Messages Failed: %d", failed)
+	log.Printf("Duplicates Skipped: %d", duplicates)
+	log.Printf("Sold Out: %d", soldOut)
+	log.Printf("Cancelled Skipped: %d", cancelled)
+	log.Printf("Abandoned: %d", abandoned)
+	log.Printf("Retries Scheduled: %d", retried)
+	log.Printf("Given Up: %d", givenUp)
+	log.Printf("Released: %d", released)
+	log.Printf("Acknowledged: %d (pending %d, failed %d)", acks.Acknowledged, acks.Pending, acks.Failed)
+	log.Printf("Processing Rate: %.2f orders/second", rate)
+	log.Printf("====================")
+}
@@ -0,0 +1,266 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+)
+
+// CustomerIndex is the global secondary index (customer_id, created_at)
+// used by ListByCustomer
+const CustomerIndex = "customer_id-created_at-index"
+
+// timeLayout is fixed width so created_at sorts lexically
+const timeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// DynamoDB stores orders in a table keyed by order_id. Attribute names
+// follow the order's JSON field names.
+type DynamoDB struct {
+	client *dynamodb.Client
+	table  string
+}
+
+// NewDynamoDB creates a repository on an existing table
+func NewDynamoDB(client *dynamodb.Client, table string) *DynamoDB {
+	return &DynamoDB{client: client, table: table}
+}
+
+// encodeOptions and decodeOptions make attributevalue reuse the order's
+// json tags and store times in sortable UTC form
+func encodeOptions(o *attributevalue.EncoderOptions) {
+	o.TagKey = "json"
+	o.EncodeTime = func(t time.Time) (types.AttributeValue, error) {
+		return &types.AttributeValueMemberS{Value: t.UTC().Format(timeLayout)}, nil
+	}
+}
+
+func decodeOptions(o *attributevalue.DecoderOptions) { o.TagKey = "json" }
+
+// Create puts the order at version 1 unless the ID already exists
+func (d *DynamoDB) Create(ctx context.Context, order *orders.Order) error {
+	order.Version = 1
+	order.UpdatedAt = time.Now()
+	item, err := attributevalue.MarshalMapWithOptions(order, encodeOptions)
+	if err != nil {
+		return fmt.Errorf("marshal order: %w", err)
+	}
+
+	_, err = d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(d.table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(order_id)"),
+	})
+	if isConditionFailed(err) {
+		return ErrExists
+	}
+	return err
+}
+
+// CreateWith puts the order like Create in one transaction with the items
+// returned by fn, which runs after the version is set. ErrExists is returned
+// if the order ID is taken; other condition failures come back as the
+// transaction error.
+func (d *DynamoDB) CreateWith(ctx context.Context, order *orders.Order, fn func() ([]types.TransactWriteItem, error)) error {
+	order.Version = 1
+	order.UpdatedAt = time.Now()
+	item, err := attributevalue.MarshalMapWithOptions(order, encodeOptions)
+	if err != nil {
+		return fmt.Errorf("marshal order: %w", err)
+	}
+	extra, err := fn()
+	if err != nil {
+		return err
+	}
+
+	items := append([]types.TransactWriteItem{{
+		Put: &types.Put{
+			TableName:           aws.String(d.table),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(order_id)"),
+		},
+	}}, extra...)
+	_, err = d.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+
+	// The order put is the first item; its cancellation reason tells a
+	// duplicate ID from other failures
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 0 &&
+		aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+		return ErrExists
+	}
+	return err
+}
+
+// Get reads the order with a consistent read
+func (d *DynamoDB) Get(ctx context.Context, orderID string) (*orders.Order, error) {
+	out, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            orderKey(orderID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, ErrNotFound
+	}
+	return decodeOrder(out.Item)
+}
+
+// UpdateStatus applies t if the stored version matches, appending it to the
+// order's history list
+func (d *DynamoDB) UpdateStatus(ctx context.Context, orderID string, t orders.Transition, version int64) (int64, error) {
+	update, err := statusUpdate(d.table, orderID, t, version)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = d.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 update.TableName,
+		Key:                       update.Key,
+		UpdateExpression:          update.UpdateExpression,
+		ConditionExpression:       update.ConditionExpression,
+		ExpressionAttributeNames:  update.ExpressionAttributeNames,
+		ExpressionAttributeValues: update.ExpressionAttributeValues,
+	})
+	if isConditionFailed(err) {
+		return 0, d.conflict(ctx, orderID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return version + 1, nil
+}
+
+// UpdateStatusWith applies t like UpdateStatus in one transaction with the
+// items returned by fn. The status update is the first item; other
+// condition failures come back as the transaction error.
+func (d *DynamoDB) UpdateStatusWith(ctx context.Context, orderID string, t orders.Transition, version int64, fn func() ([]types.TransactWriteItem, error)) (int64, error) {
+	update, err := statusUpdate(d.table, orderID, t, version)
+	if err != nil {
+		return 0, err
+	}
+	extra, err := fn()
+	if err != nil {
+		return 0, err
+	}
+
+	items := append([]types.TransactWriteItem{{Update: update}}, extra...)
+	_, err = d.client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) && len(canceled.CancellationReasons) > 0 &&
+		aws.ToString(canceled.CancellationReasons[0].Code) == "ConditionalCheckFailed" {
+		return 0, d.conflict(ctx, orderID)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return version + 1, nil
+}
+
+// statusUpdate builds the conditional update applying t to an order still
+// at version
+func statusUpdate(table, orderID string, t orders.Transition, version int64) (*types.Update, error) {
+	if err := checkTransition(t); err != nil {
+		return nil, err
+	}
+	entry, err := attributevalue.MarshalMapWithOptions(t, encodeOptions)
+	if err != nil {
+		return nil, fmt.Errorf("marshal transition: %w", err)
+	}
+
+	return &types.Update{
+		TableName: aws.String(table),
+		Key:       orderKey(orderID),
+		UpdateExpression: aws.String("SET #status = :to, #version = :next, updated_at = :now, " +
+			"history = list_append(if_not_exists(history, :empty), :entry)"),
+		ConditionExpression: aws.String("#version = :version AND #status = :from"),
+		ExpressionAttributeNames: map[string]string{
+			"#status":  "status",
+			"#version": "version",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":to":      &types.AttributeValueMemberS{Value: string(t.To)},
+			":from":    &types.AttributeValueMemberS{Value: string(t.From)},
+			":version": &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)},
+			":next":    &types.AttributeValueMemberN{Value: strconv.FormatInt(version+1, 10)},
+			":now":     &types.AttributeValueMemberS{Value: time.Now().UTC().Format(timeLayout)},
+			":empty":   &types.AttributeValueMemberL{Value: []types.AttributeValue{}},
+			":entry":   &types.AttributeValueMemberL{Value: []types.AttributeValue{&types.AttributeValueMemberM{Value: entry}}},
+		},
+	}, nil
+}
+
+// conflict explains a failed status condition: the order is missing or its
+// version is stale
+func (d *DynamoDB) conflict(ctx context.Context, orderID string) error {
+	if _, err := d.Get(ctx, orderID); err != nil {
+		return err
+	}
+	return ErrVersionConflict
+}
+
+// ListByCustomer queries the customer index, newest first
+func (d *DynamoDB) ListByCustomer(ctx context.Context, customerID int, limit int) ([]*orders.Order, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(d.table),
+		IndexName:              aws.String(CustomerIndex),
+		KeyConditionExpression: aws.String("customer_id = :customer"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":customer": &types.AttributeValueMemberN{Value: strconv.Itoa(customerID)},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+
+	var result []*orders.Order
+	paginator := dynamodb.NewQueryPaginator(d.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			order, err := decodeOrder(item)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, order)
+			if limit > 0 && len(result) == limit {
+				return result, nil
+			}
+		}
+	}
+	return result, nil
+}
+
+func decodeOrder(item map[string]types.AttributeValue) (*orders.Order, error) {
+	var order orders.Order
+	if err := attributevalue.UnmarshalMapWithOptions(item, &order, decodeOptions); err != nil {
+		return nil, fmt.Errorf("unmarshal order: %w", err)
+	}
+	return &order, nil
+}
+
+func orderKey(orderID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"order_id": &types.AttributeValueMemberS{Value: orderID},
+	}
+}
+
+func isConditionFailed(err error) bool {
+	var ccf *types.ConditionalCheckFailedException
+	return errors.As(err, &ccf)
+}
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+)
+
+// Memory keeps orders in process memory
+type Memory struct {
+	mu     sync.RWMutex
+	orders map[string]*orders.Order
+}
+
+// NewMemory creates an empty in-memory repository
+func NewMemory() *Memory {
+	return &Memory{orders: make(map[string]*orders.Order)}
+}
+
+// Create stores a copy of the order
+func (m *Memory) Create(ctx context.Context, order *orders.Order) error {
+	return m.CreateWith(ctx, order, nil)
+}
+
+// CreateWith stores the order like Create and runs fn while holding the
+// repository lock, after the version is set. The order is only stored if fn
+// succeeds, which lets callers write related state atomically with it.
+func (m *Memory) CreateWith(ctx context.Context, order *orders.Order, fn func() error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.orders[order.OrderID]; ok {
+		return ErrExists
+	}
+	order.Version = 1
+	order.UpdatedAt = time.Now()
+	if fn != nil {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	m.orders[order.OrderID] = clone(order)
+	return nil
+}
+
+// Get returns a copy of the stored order
+func (m *Memory) Get(ctx context.Context, orderID string) (*orders.Order, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	order, ok := m.orders[orderID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return clone(order), nil
+}
+
+// UpdateStatus applies t if the order is still at version
+func (m *Memory) UpdateStatus(ctx context.Context, orderID string, t orders.Transition, version int64) (int64, error) {
+	return m.UpdateStatusWith(ctx, orderID, t, version, nil)
+}
+
+// UpdateStatusWith applies t like UpdateStatus and runs fn while holding
+// the repository lock, after the version is checked. The change is only
+// applied if fn succeeds.
+func (m *Memory) UpdateStatusWith(ctx context.Context, orderID string, t orders.Transition, version int64, fn func() error) (int64, error) {
+	if err := checkTransition(t); err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order, ok := m.orders[orderID]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	if order.Version != version || order.Status != t.From {
+		return 0, ErrVersionConflict
+	}
+	if fn != nil {
+		if err := fn(); err != nil {
+			return 0, err
+		}
+	}
+	order.Status = t.To
+	order.History = append(order.History, t)
+	order.UpdatedAt = time.Now()
+	order.Version++
+	return order.Version, nil
+}
+
+// ListByCustomer returns the customer's orders, newest first
+func (m *Memory) ListByCustomer(ctx context.Context, customerID int, limit int) ([]*orders.Order, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var result []*orders.Order
+	for _, order := range m.orders {
+		if order.CustomerID == customerID {
+			result = append(result, clone(order))
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.After(result[j].CreatedAt)
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// clone copies the order so callers cannot mutate stored state
+func clone(order *orders.Order) *orders.Order {
+	c := *order
+	c.Items = append([]orders.Item(nil), order.Items...)
+	c.History = append([]orders.Transition(nil), order.History...)
+	return &c
+}
@@ -0,0 +1,90 @@
+// Package repository persists orders so their status can be looked up after
+// they leave the receiver.
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+)
+
+// Errors returned by repositories
+var (
+	ErrNotFound        = errors.New("order not found")
+	ErrExists          = errors.New("order already exists")
+	ErrVersionConflict = errors.New("order was modified concurrently")
+)
+
+// OrderRepository persists orders and their status
+type OrderRepository interface {
+	// Create stores a new order at version 1, failing with ErrExists for
+	// a known ID
+	Create(ctx context.Context, order *orders.Order) error
+	// Get returns the order or ErrNotFound
+	Get(ctx context.Context, orderID string) (*orders.Order, error)
+	// UpdateStatus applies t, appending it to the order's history, if
+	// the stored order is still at version, failing with
+	// ErrVersionConflict otherwise. Transitions the state machine does not
+	// allow fail with orders.ErrIllegalTransition. It returns the new
+	// version.
+	UpdateStatus(ctx context.Context, orderID string, t orders.Transition, version int64) (int64, error)
+	// ListByCustomer returns up to limit orders of a customer, newest first
+	ListByCustomer(ctx context.Context, customerID int, limit int) ([]*orders.Order, error)
+}
+
+// checkTransition rejects moves the order state machine does not allow
+func checkTransition(t orders.Transition) error {
+	if !orders.CanTransition(t.From, t.To) {
+		return fmt.Errorf("%w: %q to %q", orders.ErrIllegalTransition, t.From, t.To)
+	}
+	return nil
+}
+
+// NewFromEnv selects the repository with ORDER_STORE:
+//
+//	memory    in-process, for tests and single-process runs (default)
+//	sqlite    embedded database at SQLITE_PATH (default "orders.db")
+//	dynamodb  table ORDERS_TABLE (default "orders"); DYNAMODB_ENDPOINT
+//	          overrides the endpoint, e.g. for DynamoDB Local
+func NewFromEnv(ctx context.Context) (OrderRepository, error) {
+	switch kind := os.Getenv("ORDER_STORE"); kind {
+	case "", "memory":
+		return NewMemory(), nil
+
+	case "sqlite":
+		path := os.Getenv("SQLITE_PATH")
+		if path == "" {
+			path = "orders.db"
+		}
+		return OpenSQLite(ctx, path)
+
+	case "dynamodb":
+		table := os.Getenv("ORDERS_TABLE")
+		if table == "" {
+			table = "orders"
+		}
+		awsCfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("load AWS config: %w", err)
+		}
+		return NewDynamoDB(NewDynamoDBClient(awsCfg), table), nil
+
+	default:
+		return nil, fmt.Errorf("unknown ORDER_STORE %q", kind)
+	}
+}
+
+// NewDynamoDBClient creates a DynamoDB client, honouring DYNAMODB_ENDPOINT
+func NewDynamoDBClient(awsCfg aws.Config) *dynamodb.Client {
+	return dynamodb.NewFromConfig(awsCfg, func(o *dynamodb.Options) {
+		if endpoint := os.Getenv("DYNAMODB_ENDPOINT"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+}
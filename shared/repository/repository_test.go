@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/money"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+)
+
+// stores returns an empty repository of every backend that runs without
+// AWS
+func stores(t *testing.T) map[string]OrderRepository {
+	t.Helper()
+	sqlite, err := OpenSQLite(context.Background(), filepath.Join(t.TempDir(), "orders.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite: %v", err)
+	}
+	t.Cleanup(func() { sqlite.Close() })
+	return map[string]OrderRepository{
+		"memory": NewMemory(),
+		"sqlite": sqlite,
+	}
+}
+
+func newOrder(id string) *orders.Order {
+	order := &orders.Order{
+		OrderID:    id,
+		CustomerID: 7,
+		Items:      []orders.Item{{ProductID: "p-1", Quantity: 2, Price: money.New(1050, "USD")}},
+		CreatedAt:  time.Now().UTC(),
+	}
+	order.Transition(orders.StatusAccepted, "test", "order received")
+	return order
+}
+
+func TestCreateGet(t *testing.T) {
+	for name, repo := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			if err := repo.Create(ctx, newOrder("o-1")); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			if err := repo.Create(ctx, newOrder("o-1")); !errors.Is(err, ErrExists) {
+				t.Errorf("Create duplicate = %v, want ErrExists", err)
+			}
+
+			got, err := repo.Get(ctx, "o-1")
+			if err != nil {
+				t.Fatalf("Get: %v", err)
+			}
+			if got.Version != 1 || got.Status != orders.StatusAccepted || got.CustomerID != 7 {
+				t.Errorf("Get = version %d, status %q, customer %d", got.Version, got.Status, got.CustomerID)
+			}
+			if len(got.Items) != 1 || got.Items[0].Price != money.New(1050, "USD") {
+				t.Errorf("Items = %+v", got.Items)
+			}
+			if len(got.History) != 1 || got.History[0].To != orders.StatusAccepted {
+				t.Errorf("History = %+v", got.History)
+			}
+
+			if _, err := repo.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get missing = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestUpdateStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		orderID string
+		from    orders.Status
+		to      orders.Status
+		version int64
+		want    error
+	}{
+		{name: "current version", orderID: "o-1", from: orders.StatusAccepted, to: orders.StatusProcessing, version: 1},
+		{name: "stale version", orderID: "o-1", from: orders.StatusAccepted, to: orders.StatusProcessing, version: 2, want: ErrVersionConflict},
+		{name: "stale status", orderID: "o-1", from: orders.StatusProcessing, to: orders.StatusCompleted, version: 1, want: ErrVersionConflict},
+		{name: "illegal transition", orderID: "o-1", from: orders.StatusAccepted, to: orders.StatusCompleted, version: 1, want: orders.ErrIllegalTransition},
+		{name: "missing order", orderID: "missing", from: orders.StatusAccepted, to: orders.StatusProcessing, version: 1, want: ErrNotFound},
+	}
+	for _, name := range []string{"memory", "sqlite"} {
+		t.Run(name, func(t *testing.T) {
+			for _, tt := range tests {
+				t.Run(tt.name, func(t *testing.T) {
+					ctx := context.Background()
+					repo := stores(t)[name]
+					if err := repo.Create(ctx, newOrder("o-1")); err != nil {
+						t.Fatal(err)
+					}
+
+					transition := orders.Transition{From: tt.from, To: tt.to, At: time.Now().UTC(), Actor: "test"}
+					version, err := repo.UpdateStatus(ctx, tt.orderID, transition, tt.version)
+					if !errors.Is(err, tt.want) {
+						t.Fatalf("UpdateStatus = %v, want %v", err, tt.want)
+					}
+
+					got, err := repo.Get(ctx, "o-1")
+					if err != nil {
+						t.Fatal(err)
+					}
+					if tt.want != nil {
+						if got.Version != 1 || got.Status != orders.StatusAccepted || len(got.History) != 1 {
+							t.Errorf("failed update changed the order: version %d, status %q, %d transitions",
+								got.Version, got.Status, len(got.History))
+						}
+						return
+					}
+					if version != 2 || got.Version != 2 {
+						t.Errorf("version = %d, stored %d, want 2", version, got.Version)
+					}
+					if got.Status != tt.to || len(got.History) != 2 || got.History[1].To != tt.to {
+						t.Errorf("status %q, history %+v", got.Status, got.History)
+					}
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+
+	_ "modernc.org/sqlite" // pure Go driver, builds with CGO_ENABLED=0
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS orders (
+	order_id    TEXT PRIMARY KEY,
+	customer_id INTEGER NOT NULL,
+	status      TEXT NOT NULL,
+	version     INTEGER NOT NULL,
+	created_at  INTEGER NOT NULL,
+	updated_at  INTEGER NOT NULL,
+	data        TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS orders_customer ON orders (customer_id, created_at);
+CREATE TABLE IF NOT EXISTS order_history (
+	order_id    TEXT NOT NULL,
+	seq         INTEGER NOT NULL,
+	from_status TEXT NOT NULL,
+	to_status   TEXT NOT NULL,
+	at          INTEGER NOT NULL,
+	actor       TEXT NOT NULL,
+	reason      TEXT NOT NULL,
+	auth_id     TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (order_id, seq)
+);
+`
+
+// SQLite stores orders in an embedded database file. The full order is kept
+// as JSON in data; status and version live in their own columns and take
+// precedence over the JSON copy. Status history is appended to
+// order_history, one row per transition.
+type SQLite struct {
+	db *sql.DB
+}
+
+// OpenSQLiteDB opens (creating if needed) the database file at path with
+// the settings shared by every SQLite-backed store
+func OpenSQLiteDB(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows a single writer; serialise access instead of failing
+	// with "database is locked"
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
+
+// OpenSQLite opens the order repository in the database at path
+func OpenSQLite(ctx context.Context, path string) (*SQLite, error) {
+	db, err := OpenSQLiteDB(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("create schema: %w", err)
+	}
+	return &SQLite{db: db}, nil
+}
+
+// Close closes the database
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+// DB returns the underlying database so related stores can share the file
+// and its transactions
+func (s *SQLite) DB() *sql.DB {
+	return s.db
+}
+
+// Create inserts the order at version 1
+func (s *SQLite) Create(ctx context.Context, order *orders.Order) error {
+	return s.CreateWith(ctx, order, nil)
+}
+
+// CreateWith inserts the order like Create and runs fn in the same
+// transaction, so rows written by fn are committed together with the order
+func (s *SQLite) CreateWith(ctx context.Context, order *orders.Order, fn func(tx *sql.Tx) error) error {
+	order.Version = 1
+	order.UpdatedAt = time.Now()
+	// History is kept in order_history only
+	stored := *order
+	stored.History = nil
+	data, err := json.Marshal(&stored)
+	if err != nil {
+		return fmt.Errorf("marshal order: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO orders (order_id, customer_id, status, version, created_at, updated_at, data)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		order.OrderID, order.CustomerID, order.Status, order.Version,
+		order.CreatedAt.UnixNano(), order.UpdatedAt.UnixNano(), string(data))
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed") {
+		return ErrExists
+	}
+	if err != nil {
+		return err
+	}
+	for i, t := range order.History {
+		if err := insertTransition(ctx, tx, order.OrderID, i+1, t); err != nil {
+			return err
+		}
+	}
+
+	if fn != nil {
+		if err := fn(tx); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Get loads the order
+func (s *SQLite) Get(ctx context.Context, orderID string) (*orders.Order, error) {
+	row := s.db.QueryRowContext(ctx,
+		`SELECT status, version, updated_at, data FROM orders WHERE order_id = ?`, orderID)
+
+	order, err := scanOrder(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.loadHistory(ctx, order); err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// UpdateStatus applies t if the order is still at version, recording it in
+// the same transaction
+func (s *SQLite) UpdateStatus(ctx context.Context, orderID string, t orders.Transition, version int64) (int64, error) {
+	return s.UpdateStatusWith(ctx, orderID, t, version, nil)
+}
+
+// UpdateStatusWith applies t like UpdateStatus and runs fn in the same
+// transaction, so rows written by fn are committed together with the change
+func (s *SQLite) UpdateStatusWith(ctx context.Context, orderID string, t orders.Transition, version int64, fn func(tx *sql.Tx) error) (int64, error) {
+	if err := checkTransition(t); err != nil {
+		return 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE orders SET status = ?, version = version + 1, updated_at = ?
+		 WHERE order_id = ? AND version = ? AND status = ?`,
+		string(t.To), time.Now().UnixNano(), orderID, version, string(t.From))
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		// Distinguish a missing order from a stale version
+		var exists int
+		err := tx.QueryRowContext(ctx, `SELECT 1 FROM orders WHERE order_id = ?`, orderID).Scan(&exists)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		if err != nil {
+			return 0, err
+		}
+		return 0, ErrVersionConflict
+	}
+
+	var seq int
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(MAX(seq), 0) + 1 FROM order_history WHERE order_id = ?`, orderID).Scan(&seq); err != nil {
+		return 0, err
+	}
+	if err := insertTransition(ctx, tx, orderID, seq, t); err != nil {
+		return 0, err
+	}
+
+	if fn != nil {
+		if err := fn(tx); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return version + 1, nil
+}
+
+// ListByCustomer returns the customer's orders, newest first
+func (s *SQLite) ListByCustomer(ctx context.Context, customerID int, limit int) ([]*orders.Order, error) {
+	if limit <= 0 {
+		limit = -1 // no limit
+	}
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT status, version, updated_at, data FROM orders
+		 WHERE customer_id = ? ORDER BY created_at DESC LIMIT ?`, customerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []*orders.Order
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// The single connection is free again once rows is drained
+	rows.Close()
+
+	for _, order := range result {
+		if err := s.loadHistory(ctx, order); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// loadHistory reads the status history of order, oldest first
+func (s *SQLite) loadHistory(ctx context.Context, order *orders.Order) error {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT from_status, to_status, at, actor, reason, auth_id FROM order_history
+		 WHERE order_id = ? ORDER BY seq`, order.OrderID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	order.History = nil
+	for rows.Next() {
+		var (
+			t        orders.Transition
+			from, to string
+			at       int64
+		)
+		if err := rows.Scan(&from, &to, &at, &t.Actor, &t.Reason, &t.AuthorizationID); err != nil {
+			return err
+		}
+		t.From, t.To, t.At = orders.Status(from), orders.Status(to), time.Unix(0, at).UTC()
+		order.History = append(order.History, t)
+	}
+	return rows.Err()
+}
+
+// insertTransition appends t to the history of orderID at position seq
+func insertTransition(ctx context.Context, tx *sql.Tx, orderID string, seq int, t orders.Transition) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO order_history (order_id, seq, from_status, to_status, at, actor, reason, auth_id)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		orderID, seq, string(t.From), string(t.To), t.At.UnixNano(), t.Actor, t.Reason, t.AuthorizationID)
+	return err
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanOrder decodes a (status, version, updated_at, data) row
+func scanOrder(row scanner) (*orders.Order, error) {
+	var (
+		status    string
+		version   int64
+		updatedAt int64
+		data      string
+	)
+	if err := row.Scan(&status, &version, &updatedAt, &data); err != nil {
+		return nil, err
+	}
+
+	var order orders.Order
+	if err := json.Unmarshal([]byte(data), &order); err != nil {
+		return nil, fmt.Errorf("unmarshal order: %w", err)
+	}
+	order.Status = orders.Status(status)
+	order.Version = version
+	order.UpdatedAt = time.Unix(0, updatedAt)
+	return &order, nil
+}
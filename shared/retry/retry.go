@@ -0,0 +1,150 @@
+// Package retry decides whether a failed message is tried again and when.
+// Terminal errors are never retried; others are retried with exponential
+// backoff and jitter until the message has been received MaxAttempts
+// times.
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/event"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/messaging"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/payment"
+)
+
+// maxVisibility is the longest SQS hides a message
+const maxVisibility = 12 * time.Hour
+
+// terminal lists errors retrying cannot fix: a payload that does not
+// parse, a forged notification and a payment the gateway refused
+var terminal = []error{
+	event.ErrMalformed,
+	messaging.ErrInvalidSignature,
+	payment.ErrDeclined,
+}
+
+type terminalError struct {
+	err error
+}
+
+func (e *terminalError) Error() string { return e.err.Error() }
+func (e *terminalError) Unwrap() error { return e.err }
+
+// Terminal marks err as one retrying cannot fix
+func Terminal(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &terminalError{err: err}
+}
+
+// IsTerminal reports whether err was marked Terminal or is one of the
+// known terminal errors
+func IsTerminal(err error) bool {
+	var t *terminalError
+	if errors.As(err, &t) {
+		return true
+	}
+	for _, target := range terminal {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy holds retry settings
+type Policy struct {
+	// MaxAttempts bounds the receives of one message; it should match the
+	// queue's maxReceiveCount
+	MaxAttempts int
+	// MinBackoff and MaxBackoff bound the exponential delay between
+	// attempts
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultPolicy returns 3 attempts with backoff from 5s to 5m
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts: 3,
+		MinBackoff:  5 * time.Second,
+		MaxBackoff:  5 * time.Minute,
+	}
+}
+
+// PolicyFromEnv reads RETRY_MAX_ATTEMPTS, RETRY_MIN_BACKOFF and
+// RETRY_MAX_BACKOFF over DefaultPolicy
+func PolicyFromEnv() (Policy, error) {
+	p := DefaultPolicy()
+	if v := os.Getenv("RETRY_MAX_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return p, fmt.Errorf("invalid RETRY_MAX_ATTEMPTS %q", v)
+		}
+		p.MaxAttempts = n
+	}
+	if v := os.Getenv("RETRY_MIN_BACKOFF"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return p, fmt.Errorf("invalid RETRY_MIN_BACKOFF %q", v)
+		}
+		p.MinBackoff = d
+	}
+	if v := os.Getenv("RETRY_MAX_BACKOFF"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d > maxVisibility {
+			return p, fmt.Errorf("invalid RETRY_MAX_BACKOFF %q, want at most %s", v, maxVisibility)
+		}
+		p.MaxBackoff = d
+	}
+	if p.MaxBackoff < p.MinBackoff {
+		return p, fmt.Errorf("RETRY_MAX_BACKOFF %s is below RETRY_MIN_BACKOFF %s", p.MaxBackoff, p.MinBackoff)
+	}
+	return p, nil
+}
+
+// Backoff returns the delay after the given failed attempt: MinBackoff
+// doubled per earlier attempt up to MaxBackoff, of which the upper half is
+// random so failures of one flash sale do not come back together
+func (p Policy) Backoff(attempt int) time.Duration {
+	delay := p.MinBackoff
+	for i := 1; i < attempt && delay < p.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay
+	}
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Decision is what to do with a failed message
+type Decision struct {
+	// Retry means the message should be delivered again after Delay
+	Retry bool
+	Delay time.Duration
+	// Terminal means err cannot be fixed by retrying; otherwise a message
+	// that is not retried has used up its attempts
+	Terminal bool
+}
+
+// Decide classifies err, the failure of the given attempt (the message's
+// receive count, 1 for the first delivery)
+func (p Policy) Decide(err error, attempt int) Decision {
+	if IsTerminal(err) {
+		return Decision{Terminal: true}
+	}
+	if attempt >= p.MaxAttempts {
+		return Decision{}
+	}
+	return Decision{Retry: true, Delay: p.Backoff(attempt)}
+}
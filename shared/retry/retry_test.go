@@ -0,0 +1,81 @@
+package retry
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/event"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/messaging"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/payment"
+)
+
+func TestDecide(t *testing.T) {
+	policy := Policy{MaxAttempts: 3, MinBackoff: 4 * time.Second, MaxBackoff: 10 * time.Second}
+	transient := errors.New("connection reset")
+
+	tests := []struct {
+		name     string
+		err      error
+		attempt  int
+		retry    bool
+		terminal bool
+		// minDelay and maxDelay bound the jittered delay of a retry
+		minDelay time.Duration
+		maxDelay time.Duration
+	}{
+		{name: "first failure", err: transient, attempt: 1, retry: true, minDelay: 2 * time.Second, maxDelay: 4 * time.Second},
+		{name: "backoff doubles", err: transient, attempt: 2, retry: true, minDelay: 4 * time.Second, maxDelay: 8 * time.Second},
+		{name: "attempts used up", err: transient, attempt: 3},
+		{name: "past max attempts", err: transient, attempt: 5},
+		{name: "malformed", err: fmt.Errorf("decode: %w", event.ErrMalformed), attempt: 1, terminal: true},
+		{name: "forged", err: messaging.ErrInvalidSignature, attempt: 1, terminal: true},
+		{name: "declined", err: fmt.Errorf("charge: %w", payment.ErrDeclined), attempt: 1, terminal: true},
+		{name: "marked terminal", err: Terminal(transient), attempt: 1, terminal: true},
+		{name: "terminal on last attempt", err: payment.ErrDeclined, attempt: 3, terminal: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := policy.Decide(tt.err, tt.attempt)
+			if d.Retry != tt.retry || d.Terminal != tt.terminal {
+				t.Fatalf("Decide = %+v, want Retry %v, Terminal %v", d, tt.retry, tt.terminal)
+			}
+			if d.Delay < tt.minDelay || d.Delay > tt.maxDelay {
+				t.Errorf("Delay = %s, want %s to %s", d.Delay, tt.minDelay, tt.maxDelay)
+			}
+		})
+	}
+}
+
+func TestBackoffCapped(t *testing.T) {
+	policy := Policy{MaxAttempts: 20, MinBackoff: 4 * time.Second, MaxBackoff: 10 * time.Second}
+	for attempt := 1; attempt <= 20; attempt++ {
+		if d := policy.Backoff(attempt); d > policy.MaxBackoff {
+			t.Errorf("Backoff(%d) = %s, above MaxBackoff", attempt, d)
+		}
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "plain", err: errors.New("timeout"), want: false},
+		{name: "wrapped terminal", err: fmt.Errorf("handle: %w", Terminal(errors.New("bad order"))), want: true},
+		{name: "known terminal", err: payment.ErrDeclined, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTerminal(tt.err); got != tt.want {
+				t.Errorf("IsTerminal(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+	if Terminal(nil) != nil {
+		t.Error("Terminal(nil) is not nil")
+	}
+}
@@ -0,0 +1,68 @@
+package validation
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ContentType is the media type of problem responses (RFC 7807)
+const ContentType = "application/problem+json"
+
+// Problem types returned by this package
+const (
+	TypeMalformed  = "/problems/malformed-request"
+	TypeValidation = "/problems/validation-error"
+	TypeTooLarge   = "/problems/request-too-large"
+)
+
+// FieldError describes one invalid field. Field is a JSON path such as
+// "items[2].quantity"; it is empty for errors about the whole body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Problem is an RFC 7807 problem details object with per-field errors
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// Error summarises the problem and its field errors
+func (p *Problem) Error() string {
+	msgs := make([]string, 0, len(p.Errors))
+	for _, fe := range p.Errors {
+		if fe.Field == "" {
+			msgs = append(msgs, fe.Message)
+			continue
+		}
+		msgs = append(msgs, fe.Field+": "+fe.Message)
+	}
+	if len(msgs) == 0 {
+		return p.Title
+	}
+	return p.Title + ": " + strings.Join(msgs, "; ")
+}
+
+// Invalid returns the 422 problem for an order with invalid fields
+func Invalid(errs ...FieldError) *Problem {
+	return &Problem{
+		Type:   TypeValidation,
+		Title:  "Invalid order",
+		Status: http.StatusUnprocessableEntity,
+		Detail: "One or more fields are invalid",
+		Errors: errs,
+	}
+}
+
+// Write sends the problem as the response
+func (p *Problem) Write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
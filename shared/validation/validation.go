@@ -0,0 +1,310 @@
+// Package validation decodes and checks order requests, reporting every
+// invalid field as an RFC 7807 problem.
+package validation
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/money"
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/orders"
+)
+
+// MaxBodyBytes bounds the size of an order request
+const MaxBodyBytes = 1 << 20
+
+// Rules holds the limits applied to orders
+type Rules struct {
+	MaxItems    int
+	MaxQuantity int
+	// MaxPrice bounds the unit price, in major units of its currency
+	MaxPrice int64
+}
+
+// DefaultRules allows up to 100 items, 1000 units per item and a unit
+// price of 100000 (in the item's currency)
+func DefaultRules() Rules {
+	return Rules{
+		MaxItems:    100,
+		MaxQuantity: 1000,
+		MaxPrice:    100000,
+	}
+}
+
+// orderIDPattern keeps client-supplied IDs usable in /orders/{id}
+var orderIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// codePattern bounds promo codes and region names such as "US-CA"
+var codePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// readOnlyFields are order fields set by the server
+var readOnlyFields = map[string]bool{
+	"status":     true,
+	"version":    true,
+	"created_at": true,
+	"updated_at": true,
+	"pricing":    true,
+	"history":    true,
+}
+
+// DecodeOrder reads an order request. Unknown and read-only fields,
+// values of the wrong type and rule violations are all reported in the
+// returned problem rather than stopping at the first one.
+func DecodeOrder(r io.Reader, rules Rules) (orders.Order, *Problem) {
+	var order orders.Order
+
+	data, err := io.ReadAll(io.LimitReader(r, MaxBodyBytes+1))
+	if err != nil {
+		return order, malformed("Could not read request body: " + err.Error())
+	}
+	if len(data) > MaxBodyBytes {
+		return order, &Problem{
+			Type:   TypeTooLarge,
+			Title:  "Request body too large",
+			Status: http.StatusRequestEntityTooLarge,
+			Detail: fmt.Sprintf("Order requests are limited to %d bytes", MaxBodyBytes),
+		}
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil || fields == nil {
+		detail := "Request body must be a JSON object"
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			detail = fmt.Sprintf("Invalid JSON at offset %d: %v", syntaxErr.Offset, err)
+		}
+		return order, malformed(detail)
+	}
+
+	var errs []FieldError
+	for _, name := range sortedKeys(fields) {
+		raw := fields[name]
+		switch name {
+		case "order_id":
+			decodeField(name, raw, &order.OrderID, &errs)
+		case "customer_id":
+			decodeField(name, raw, &order.CustomerID, &errs)
+		case "items":
+			order.Items = decodeItems(raw, &errs)
+		case "promo_code":
+			decodeField(name, raw, &order.PromoCode, &errs)
+		case "region":
+			decodeField(name, raw, &order.Region, &errs)
+		default:
+			if readOnlyFields[name] {
+				errs = append(errs, FieldError{Field: name, Message: "is assigned by the server and must not be set"})
+				continue
+			}
+			errs = append(errs, FieldError{Field: name, Message: "unknown field"})
+		}
+	}
+
+	errs = append(errs, ValidateOrder(&order, rules)...)
+	if len(errs) > 0 {
+		return order, Invalid(firstPerField(errs)...)
+	}
+	return order, nil
+}
+
+// ValidateOrder applies rules to a decoded order
+func ValidateOrder(order *orders.Order, rules Rules) []FieldError {
+	var errs []FieldError
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if order.OrderID != "" && !orderIDPattern.MatchString(order.OrderID) {
+		add("order_id", "must be 1-64 letters, digits, '-' or '_'")
+	}
+	if order.CustomerID <= 0 {
+		add("customer_id", "is required and must be positive")
+	}
+	if order.Status != "" {
+		add("status", "is assigned by the server and must not be set")
+	}
+	if order.Pricing != nil {
+		add("pricing", "is assigned by the server and must not be set")
+	}
+	if len(order.History) > 0 {
+		add("history", "is assigned by the server and must not be set")
+	}
+	if order.PromoCode != "" && !codePattern.MatchString(order.PromoCode) {
+		add("promo_code", "must be 1-32 letters, digits, '-' or '_'")
+	}
+	if order.Region != "" && !codePattern.MatchString(order.Region) {
+		add("region", "must be 1-32 letters, digits, '-' or '_'")
+	}
+
+	switch {
+	case len(order.Items) == 0:
+		add("items", "at least one item is required")
+	case rules.MaxItems > 0 && len(order.Items) > rules.MaxItems:
+		add("items", "at most %d items are allowed", rules.MaxItems)
+	}
+
+	seen := make(map[string]int, len(order.Items))
+	for i, item := range order.Items {
+		path := fmt.Sprintf("items[%d]", i)
+		if item.ProductID == "" {
+			add(path+".product_id", "is required")
+		} else if first, ok := seen[item.ProductID]; ok {
+			add(path+".product_id", "duplicates items[%d]; combine the quantities", first)
+		} else {
+			seen[item.ProductID] = i
+		}
+
+		switch {
+		case item.Quantity <= 0:
+			add(path+".quantity", "must be at least 1")
+		case rules.MaxQuantity > 0 && item.Quantity > rules.MaxQuantity:
+			add(path+".quantity", "must be at most %d", rules.MaxQuantity)
+		}
+
+		switch {
+		case !item.Price.IsPositive():
+			add(path+".price", "must be greater than 0")
+		case rules.MaxPrice > 0:
+			limit, err := money.Parse(strconv.FormatInt(rules.MaxPrice, 10), item.Price.Currency)
+			if err == nil && item.Price.Amount > limit.Amount {
+				add(path+".price", "must be at most %s", limit)
+			}
+		}
+		if currency := order.Currency(); item.Price.Currency != currency {
+			add(path+".currency", "must match the order currency %s", currency)
+		}
+	}
+	return errs
+}
+
+// decodeItems decodes the items array element by element so errors carry
+// the item index
+func decodeItems(raw json.RawMessage, errs *[]FieldError) []orders.Item {
+	var elems []json.RawMessage
+	if err := json.Unmarshal(raw, &elems); err != nil {
+		*errs = append(*errs, FieldError{Field: "items", Message: "must be an array"})
+		return nil
+	}
+
+	items := make([]orders.Item, len(elems))
+	for i, elem := range elems {
+		path := fmt.Sprintf("items[%d]", i)
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(elem, &fields); err != nil || fields == nil {
+			*errs = append(*errs, FieldError{Field: path, Message: "must be an object"})
+			continue
+		}
+
+		var (
+			price    json.RawMessage
+			currency string
+		)
+		for _, name := range sortedKeys(fields) {
+			raw := fields[name]
+			switch name {
+			case "product_id":
+				decodeField(path+"."+name, raw, &items[i].ProductID, errs)
+			case "quantity":
+				decodeField(path+"."+name, raw, &items[i].Quantity, errs)
+			case "price":
+				price = raw
+			case "currency":
+				decodeField(path+"."+name, raw, &currency, errs)
+			default:
+				*errs = append(*errs, FieldError{Field: path + "." + name, Message: "unknown field"})
+			}
+		}
+
+		// price is a decimal number, or a money object in minor units
+		p, err := orders.ParsePrice(price, currency)
+		switch {
+		case errors.Is(err, money.ErrUnknownCurrency):
+			*errs = append(*errs, FieldError{Field: path + ".currency", Message: "is not a supported ISO 4217 currency"})
+			// Check the amount as if it were in the default currency
+			items[i].Price, _ = orders.ParsePrice(price, "")
+			items[i].Price.Currency = currency
+		case err != nil:
+			exp, _ := money.Exponent(orDefault(currency))
+			*errs = append(*errs, FieldError{Field: path + ".price", Message: fmt.Sprintf("must be a number with at most %d decimal places", exp)})
+			items[i].Price = money.New(0, orDefault(currency))
+		default:
+			items[i].Price = p
+		}
+	}
+	return items
+}
+
+func orDefault(currency string) string {
+	if currency == "" {
+		return money.DefaultCurrency
+	}
+	return currency
+}
+
+// decodeField decodes one value, recording a type error under path
+func decodeField(path string, raw json.RawMessage, dst interface{}, errs *[]FieldError) {
+	if bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return
+	}
+	if err := json.Unmarshal(raw, dst); err != nil {
+		*errs = append(*errs, FieldError{Field: path, Message: typeMessage(err, dst)})
+	}
+}
+
+// typeMessage describes the expected type of dst
+func typeMessage(err error, dst interface{}) string {
+	var typeErr *json.UnmarshalTypeError
+	if !errors.As(err, &typeErr) {
+		return "is not valid JSON"
+	}
+	switch reflect.TypeOf(dst).Elem().Kind() {
+	case reflect.Int, reflect.Int64:
+		return "must be a whole number"
+	case reflect.Float64:
+		return "must be a number"
+	case reflect.String:
+		return "must be a string"
+	default:
+		return "has the wrong type"
+	}
+}
+
+// firstPerField keeps the first error of each field, so a value of the
+// wrong type is not reported again by the range checks
+func firstPerField(errs []FieldError) []FieldError {
+	seen := make(map[string]bool, len(errs))
+	out := errs[:0]
+	for _, fe := range errs {
+		if !seen[fe.Field] {
+			seen[fe.Field] = true
+			out = append(out, fe)
+		}
+	}
+	return out
+}
+
+func sortedKeys(m map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func malformed(detail string) *Problem {
+	return &Problem{
+		Type:   TypeMalformed,
+		Title:  "Malformed request",
+		Status: http.StatusBadRequest,
+		Detail: detail,
+	}
+}
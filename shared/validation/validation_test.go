@@ -0,0 +1,134 @@
+package validation
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/shivlal1/Order-Processing-System-on-AWS/shared/money"
+)
+
+func TestDecodeOrderRejects(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		// fields are the fields reported invalid, in any order
+		fields []string
+	}{
+		{name: "not JSON", body: `{"customer_id": 1,`, status: http.StatusBadRequest},
+		{name: "not an object", body: `[1, 2]`, status: http.StatusBadRequest},
+		{name: "null", body: `null`, status: http.StatusBadRequest},
+		{
+			name:   "missing customer and items",
+			body:   `{}`,
+			status: http.StatusUnprocessableEntity,
+			fields: []string{"customer_id", "items"},
+		},
+		{
+			name:   "unknown and read-only fields",
+			body:   `{"customer_id": 1, "items": [{"product_id": "p", "quantity": 1, "price": 1}], "colour": "red", "status": "completed"}`,
+			status: http.StatusUnprocessableEntity,
+			fields: []string{"colour", "status"},
+		},
+		{
+			name:   "wrong types reported once",
+			body:   `{"customer_id": "7", "items": {"product_id": "p"}}`,
+			status: http.StatusUnprocessableEntity,
+			fields: []string{"customer_id", "items"},
+		},
+		{
+			name:   "invalid order ID and codes",
+			body:   `{"order_id": "a/b", "customer_id": 1, "items": [{"product_id": "p", "quantity": 1, "price": 1}], "promo_code": "10% off", "region": ""}`,
+			status: http.StatusUnprocessableEntity,
+			fields: []string{"order_id", "promo_code"},
+		},
+		{
+			name: "item errors carry their index",
+			body: `{"customer_id": 1, "items": [
+				{"product_id": "p", "quantity": 0, "price": 1},
+				{"product_id": "", "quantity": 1, "price": -1},
+				{"product_id": "p", "quantity": 1001, "price": 100000.01}]}`,
+			status: http.StatusUnprocessableEntity,
+			fields: []string{"items[0].quantity", "items[1].product_id", "items[1].price", "items[2].product_id", "items[2].quantity", "items[2].price"},
+		},
+		{
+			name:   "too many decimal places",
+			body:   `{"customer_id": 1, "items": [{"product_id": "p", "quantity": 1, "price": 1.005}]}`,
+			status: http.StatusUnprocessableEntity,
+			fields: []string{"items[0].price"},
+		},
+		{
+			name:   "unknown currency",
+			body:   `{"customer_id": 1, "items": [{"product_id": "p", "quantity": 1, "price": 1, "currency": "XYZ"}]}`,
+			status: http.StatusUnprocessableEntity,
+			fields: []string{"items[0].currency"},
+		},
+		{
+			name: "mixed currencies",
+			body: `{"customer_id": 1, "items": [
+				{"product_id": "p", "quantity": 1, "price": 1, "currency": "EUR"},
+				{"product_id": "q", "quantity": 1, "price": 1, "currency": "USD"}]}`,
+			status: http.StatusUnprocessableEntity,
+			fields: []string{"items[1].currency"},
+		},
+		{
+			name:   "unknown item field",
+			body:   `{"customer_id": 1, "items": [{"product_id": "p", "quantity": 1, "price": 1, "sku": "x"}]}`,
+			status: http.StatusUnprocessableEntity,
+			fields: []string{"items[0].sku"},
+		},
+		{
+			name:   "too large",
+			body:   `{"order_id": "` + strings.Repeat("a", MaxBodyBytes) + `"}`,
+			status: http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, problem := DecodeOrder(strings.NewReader(tt.body), DefaultRules())
+			if problem == nil {
+				t.Fatal("DecodeOrder accepted the order")
+			}
+			if problem.Status != tt.status {
+				t.Errorf("Status = %d, want %d (%v)", problem.Status, tt.status, problem)
+			}
+
+			var fields []string
+			for _, fe := range problem.Errors {
+				fields = append(fields, fe.Field)
+			}
+			sort.Strings(fields)
+			want := append([]string(nil), tt.fields...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(fields, want) {
+				t.Errorf("invalid fields = %v, want %v", fields, want)
+			}
+		})
+	}
+}
+
+func TestDecodeOrderAccepts(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		price money.Money
+	}{
+		{name: "decimal price", body: `{"customer_id": 1, "items": [{"product_id": "p", "quantity": 2, "price": 10.5}]}`, price: money.New(1050, "USD")},
+		{name: "currency", body: `{"customer_id": 1, "items": [{"product_id": "p", "quantity": 2, "price": 500, "currency": "JPY"}]}`, price: money.New(500, "JPY")},
+		{name: "money object", body: `{"customer_id": 1, "items": [{"product_id": "p", "quantity": 2, "price": {"amount": 1050, "currency": "EUR"}}]}`, price: money.New(1050, "EUR")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, problem := DecodeOrder(strings.NewReader(tt.body), DefaultRules())
+			if problem != nil {
+				t.Fatalf("DecodeOrder: %v", problem)
+			}
+			if len(order.Items) != 1 || order.Items[0].Price != tt.price || order.Items[0].Quantity != 2 {
+				t.Errorf("Items = %+v", order.Items)
+			}
+		})
+	}
+}